@@ -2,56 +2,111 @@ package cli
 
 import (
 	"fmt"
+	"net"
 	"os"
 
 	"github.com/naggie/dsnet/lib"
-	"github.com/spf13/viper"
 )
 
+// AddOptions holds the optional arguments to Add. Missing owner and
+// description are prompted for. PrivateKeyFile and PublicKeyFile are paths to
+// user-supplied keys, or "-" to read them from stdin (prompting if
+// interactive).
+type AddOptions struct {
+	Owner          string
+	Description    string
+	PrivateKeyFile string
+	PublicKey      string
+	PublicKeyFile  string
+	// PersistentKeepalive and MTU of the peer, 0 for the defaults
+	Keepalive int
+	MTU       int
+	// static host:port of the peer, if any
+	Endpoint string
+	// explicit IPs, otherwise allocated from Pool, or outside every pool if
+	// Pool is empty
+	IP      string
+	IP6     string
+	Pool    string
+	Confirm bool
+	JSON    bool
+}
+
 // Add prompts for the required information and creates a new peer
-func Add(hostname string, privKey, pubKey bool, owner, description string, confirm bool) error {
-	config, err := LoadConfigFile()
+func Add(hostname string, opts AddOptions) error {
+	config, err := LoadConfigFileForUpdate()
 	if err != nil {
 		return fmt.Errorf("%w - failed to load configuration file", err)
 	}
 	server := GetServer(config)
 
+	if opts.PublicKey != "" && opts.PublicKeyFile != "" {
+		return fmt.Errorf("only one of --public-key or --public-key-file may be given")
+	}
+
+	ips := lib.PeerIPs{Pool: opts.Pool}
+	if opts.IP != "" {
+		if ips.IP = net.ParseIP(opts.IP); ips.IP == nil || ips.IP.To4() == nil {
+			return fmt.Errorf("invalid IPv4 address %s", opts.IP)
+		}
+	}
+	if opts.IP6 != "" {
+		if ips.IP6 = net.ParseIP(opts.IP6); ips.IP6 == nil || ips.IP6.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %s", opts.IP6)
+		}
+	}
+	if _, ok := config.Pools[opts.Pool]; opts.Pool != "" && !ok {
+		return fmt.Errorf("unknown pool %s, see Pools in CONFIG.md", opts.Pool)
+	}
+
 	var private, public string
-	if privKey {
-		if private, err = PromptString("private key", true); err != nil {
+	if opts.PrivateKeyFile != "" {
+		if private, err = ReadKeyFile("private key", opts.PrivateKeyFile); err != nil {
 			return err
 		}
 	}
-	if pubKey {
-		if public, err = PromptString("public key", true); err != nil {
+	if opts.PublicKeyFile != "" {
+		if public, err = ReadKeyFile("public key", opts.PublicKeyFile); err != nil {
 			return err
 		}
+	} else {
+		public = opts.PublicKey
 	}
+
+	owner := opts.Owner
 	if owner == "" {
-		owner, err = PromptString("owner", true)
+		owner, err = PromptString("owner", "--owner", true)
 		if err != nil {
 			return fmt.Errorf("%w - invalid input for owner", err)
 		}
 	}
+	description := opts.Description
 	if description == "" {
-		description, err = PromptString("Description", true)
+		description, err = PromptString("Description", "--description", true)
 		if err != nil {
 			return fmt.Errorf("%w - invalid input for Description", err)
 		}
 	}
 
 	// publicKey := MustPromptString("PublicKey (optional)", false)
-	if !confirm {
-		ConfirmOrAbort("\nDo you want to add the above configuration?")
+	if !opts.Confirm {
+		if err = ConfirmOrAbort("\nDo you want to add the above configuration?"); err != nil {
+			return err
+		}
 	}
 
 	// newline (not on stdout) to separate config
 	fmt.Fprintln(os.Stderr)
 
-	peer, err := lib.NewPeer(server, private, public, owner, hostname, description)
+	peer, err := lib.NewPeerWithIPs(server, private, public, owner, hostname, description, ips)
 	if err != nil {
 		return fmt.Errorf("%w - failed to get new peer", err)
 	}
+	if opts.Keepalive > 0 {
+		peer.PersistentKeepalive = opts.Keepalive
+	}
+	peer.MTU = opts.MTU
+	peer.Endpoint = opts.Endpoint
 
 	// TODO Some kind of recovery here would be nice, to avoid
 	// leaving things in a potential broken state
@@ -60,21 +115,19 @@ func Add(hostname string, privKey, pubKey bool, owner, description string, confi
 		return fmt.Errorf("%w - failed to add new peer", err)
 	}
 
-	peerType := viper.GetString("output")
-
-	peerConfigBytes, err := lib.AsciiPeerConfig(peer, peerType, *server)
-	if err != nil {
-		return fmt.Errorf("%w - failed to get peer configuration", err)
+	if err = PrintPeerConfig(peer, server, opts.JSON); err != nil {
+		return err
 	}
-	os.Stdout.Write(peerConfigBytes.Bytes())
 
-	if err = config.Save(); err != nil {
+	if err = config.SaveChange(fmt.Sprintf("Add peer %s (%s)", hostname, owner)); err != nil {
 		return fmt.Errorf("%w - failed to save config file", err)
 	}
 
 	server = GetServer(config)
-	if err = server.ConfigureDevice(); err != nil {
+	if err = WarnUnresolvedEndpoints(server.ConfigureDevice()); err != nil {
 		return fmt.Errorf("%w - failed to configure device", err)
 	}
+
+	printMeshNotice(hostname, meshPartners(server, peer))
 	return nil
 }
@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+// Adopt creates the dsnet config from an existing WireGuard setup, either a
+// wg-quick config file or a live interface, so it can be managed by dsnet
+// from then on. Like init, it refuses to overwrite an existing config.
+func Adopt(from, fromInterface, owner, description string) error {
+	configFile := viper.GetString("config_file")
+
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		return fmt.Errorf("Refusing to overwrite existing %s", configFile)
+	}
+
+	var wgConf *lib.WGQuickConfig
+	var interfaceName string
+	var err error
+
+	switch {
+	case from != "" && fromInterface != "":
+		return fmt.Errorf("only one of --from or --from-interface may be given")
+	case from != "":
+		f, err := os.Open(from)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		wgConf, err = lib.ParseWGQuickConfig(f)
+		if err != nil {
+			return fmt.Errorf("%w - failed to parse %s", err, from)
+		}
+		// wg-quick names the interface after the file
+		interfaceName = strings.TrimSuffix(filepath.Base(from), filepath.Ext(from))
+	case fromInterface != "":
+		wgConf, err = lib.ReadWGInterface(fromInterface)
+		if err != nil {
+			return err
+		}
+		// live endpoints are wherever the peers last roamed from, not
+		// configured ones
+		for i := range wgConf.Peers {
+			wgConf.Peers[i].Endpoint = ""
+		}
+		interfaceName = fromInterface
+	default:
+		return fmt.Errorf("one of --from or --from-interface is required")
+	}
+
+	if owner == "" {
+		owner, err = PromptString("owner of adopted peers", "--owner", true)
+		if err != nil {
+			return err
+		}
+	}
+	if description == "" {
+		description = "Adopted from " + interfaceName
+	}
+
+	conf, err := AdoptConfig(wgConf, interfaceName, owner, description)
+	if err != nil {
+		return err
+	}
+
+	if conf.ExternalIP, err = getExternalIP(); err != nil {
+		return err
+	}
+	if conf.ExternalIP6, err = getExternalIP6(); err != nil {
+		return err
+	}
+	if len(conf.ExternalIP) == 0 && len(conf.ExternalIP6) == 0 {
+		return fmt.Errorf("Could not determine any external IP, v4 or v6")
+	}
+
+	if err := conf.SaveChange("Adopt " + interfaceName); err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	fmt.Printf("Adopted %d peers from %s. Config written to %s. Please check/edit hostnames, owners and descriptions.\n", len(conf.Peers), interfaceName, configFile)
+	return nil
+}
+
+// AdoptConfig converts an existing WireGuard setup to a DsnetConfig. Network
+// and Network6 are taken from the interface addresses, widened if necessary
+// to contain every peer address. Peers are named from comments where
+// available; otherwise peer1, peer2 etc. External IPs are left to the caller.
+func AdoptConfig(wgConf *lib.WGQuickConfig, interfaceName, owner, description string) (*DsnetConfig, error) {
+	conf := &DsnetConfig{
+		Version:             ConfigVersion,
+		PrivateKey:          lib.JSONKey{Key: wgConf.Interface.PrivateKey},
+		ListenPort:          wgConf.Interface.ListenPort,
+		Domain:              "dsnet",
+		InterfaceName:       interfaceName,
+		Networks:            []lib.JSONIPNet{},
+		Peers:               []PeerConfig{},
+		PostUp:              strings.Join(wgConf.Interface.PostUp, "; "),
+		PostDown:            strings.Join(wgConf.Interface.PostDown, "; "),
+		PersistentKeepalive: 25,
+		MTU:                 wgConf.Interface.MTU,
+	}
+
+	if conf.ListenPort == 0 {
+		conf.ListenPort = viper.GetInt("listen_port")
+	}
+	if conf.MTU == 0 {
+		conf.MTU = viper.GetInt("mtu")
+	}
+
+	// host addresses of peers, by family, to infer the networks
+	var peerIPs, peerIP6s []net.IP
+	for _, peer := range wgConf.Peers {
+		for _, allowedIP := range peer.AllowedIPs {
+			if !isHostNet(allowedIP) {
+				continue
+			}
+			if allowedIP.IP.To4() != nil {
+				peerIPs = append(peerIPs, allowedIP.IP)
+			} else {
+				peerIP6s = append(peerIP6s, allowedIP.IP)
+			}
+		}
+	}
+
+	for _, addr := range wgConf.Interface.Addresses {
+		if addr.IP.To4() != nil && len(conf.IP) == 0 {
+			conf.IP = addr.IP.To4()
+			conf.Network = lib.JSONIPNet{IPNet: coveringNetwork(addr, peerIPs)}
+		} else if addr.IP.To4() == nil && len(conf.IP6) == 0 {
+			conf.IP6 = addr.IP
+			conf.Network6 = lib.JSONIPNet{IPNet: coveringNetwork(addr, peerIP6s)}
+		}
+	}
+
+	if len(conf.IP) == 0 && len(conf.IP6) == 0 {
+		return nil, fmt.Errorf("interface has no address to infer the network from")
+	}
+
+	for i, wgPeer := range wgConf.Peers {
+		peer := PeerConfig{
+			Hostname:     uniqueHostname(conf.Peers, adoptedHostname(wgPeer.Name, i+1)),
+			Owner:        owner,
+			Description:  description,
+			Added:        time.Now(),
+			Networks:     []lib.JSONIPNet{},
+			PublicKey:    lib.JSONKey{Key: wgPeer.PublicKey},
+			PresharedKey: lib.JSONKey{Key: wgPeer.PresharedKey},
+			// the server side keepalive, kept as the peer's own
+			PersistentKeepalive: wgPeer.PersistentKeepalive,
+			Endpoint:            wgPeer.Endpoint,
+		}
+
+		for _, allowedIP := range wgPeer.AllowedIPs {
+			switch {
+			case isHostNet(allowedIP) && len(peer.IP) == 0 && conf.Network.IPNet.Contains(allowedIP.IP):
+				peer.IP = allowedIP.IP.To4()
+			case isHostNet(allowedIP) && len(peer.IP6) == 0 && conf.Network6.IPNet.Contains(allowedIP.IP):
+				peer.IP6 = allowedIP.IP
+			default:
+				peer.Networks = append(peer.Networks, lib.JSONIPNet{IPNet: net.IPNet{
+					IP:   allowedIP.IP.Mask(allowedIP.Mask),
+					Mask: allowedIP.Mask,
+				}})
+			}
+		}
+
+		conf.Peers = append(conf.Peers, peer)
+	}
+
+	return conf, nil
+}
+
+func isHostNet(n net.IPNet) bool {
+	ones, bits := n.Mask.Size()
+	return ones == bits
+}
+
+// coveringNetwork returns the network of addr, widened until it contains all
+// of IPs. wg-quick configs often give the server a bare or /32 address, in
+// which case a /24 (or /64 for IPv6) is assumed to start with.
+func coveringNetwork(addr net.IPNet, IPs []net.IP) net.IPNet {
+	ones, bits := addr.Mask.Size()
+	if ones == bits && bits == 32 {
+		ones = 24
+	} else if ones == bits {
+		ones = 64
+	}
+
+	for ; ones > 0; ones-- {
+		mask := net.CIDRMask(ones, bits)
+		network := net.IPNet{IP: addr.IP.Mask(mask), Mask: mask}
+
+		containsAll := true
+		for _, IP := range IPs {
+			if !network.Contains(IP) {
+				containsAll = false
+				break
+			}
+		}
+
+		if containsAll {
+			return network
+		}
+	}
+
+	return net.IPNet{IP: addr.IP.Mask(net.CIDRMask(0, bits)), Mask: net.CIDRMask(0, bits)}
+}
+
+var invalidHostnameChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+func adoptedHostname(name string, n int) string {
+	hostname := strings.Trim(invalidHostnameChars.ReplaceAllString(name, "-"), "-.")
+	if hostname == "" {
+		return "peer" + strconv.Itoa(n)
+	}
+	return hostname
+}
+
+func uniqueHostname(peers []PeerConfig, hostname string) string {
+	candidate := hostname
+	for n := 2; ; n++ {
+		taken := false
+		for _, p := range peers {
+			if p.Hostname == candidate {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", hostname, n)
+	}
+}
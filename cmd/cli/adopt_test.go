@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/naggie/dsnet/lib"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func testWGQuickConfig(t *testing.T, addresses string) *lib.WGQuickConfig {
+	t.Helper()
+	serverKey, _ := wgtypes.GeneratePrivateKey()
+	peerKey1, _ := wgtypes.GeneratePrivateKey()
+	peerKey2, _ := wgtypes.GeneratePrivateKey()
+
+	raw := "[Interface]\nAddress = " + addresses + "\nListenPort = 51821\nPrivateKey = " + serverKey.String() + "\n" +
+		"# Name = laptop\n[Peer]\nPublicKey = " + peerKey1.PublicKey().String() + "\nAllowedIPs = 10.0.0.2/32, fd00::2/128\n" +
+		"[Peer]\nPublicKey = " + peerKey2.PublicKey().String() + "\nAllowedIPs = 10.0.0.130/32, 192.168.1.0/24\n"
+
+	conf, err := lib.ParseWGQuickConfig(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	return conf
+}
+
+func TestAdoptConfig(t *testing.T) {
+	wgConf := testWGQuickConfig(t, "10.0.0.1/24, fd00::1/64")
+
+	conf, err := AdoptConfig(wgConf, "wg0", "alice", "Adopted from wg0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if conf.InterfaceName != "wg0" || conf.ListenPort != 51821 {
+		t.Fatalf("unexpected interface/port: %s/%d", conf.InterfaceName, conf.ListenPort)
+	}
+	if conf.PrivateKey.Key != wgConf.Interface.PrivateKey {
+		t.Fatal("server private key should be kept")
+	}
+	if conf.Network.String() != "10.0.0.0/24" || !conf.IP.Equal(net.IP{10, 0, 0, 1}) {
+		t.Fatalf("unexpected network/IP: %s/%s", conf.Network.String(), conf.IP)
+	}
+	if conf.Network6.String() != "fd00::/64" {
+		t.Fatalf("unexpected network6: %s", conf.Network6.String())
+	}
+
+	if len(conf.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(conf.Peers))
+	}
+	if conf.Peers[0].Hostname != "laptop" || conf.Peers[1].Hostname != "peer2" {
+		t.Fatalf("unexpected hostnames %s, %s", conf.Peers[0].Hostname, conf.Peers[1].Hostname)
+	}
+	if !conf.Peers[0].IP6.Equal(net.ParseIP("fd00::2")) {
+		t.Fatalf("expected IP6 fd00::2, got %s", conf.Peers[0].IP6)
+	}
+	if !conf.Peers[1].IP.Equal(net.IP{10, 0, 0, 130}) {
+		t.Fatalf("expected IP 10.0.0.130, got %s", conf.Peers[1].IP)
+	}
+	if len(conf.Peers[1].Networks) != 1 || conf.Peers[1].Networks[0].String() != "192.168.1.0/24" {
+		t.Fatalf("expected routed network, got %v", conf.Peers[1].Networks)
+	}
+	if conf.Peers[0].Owner != "alice" {
+		t.Fatalf("expected owner alice, got %s", conf.Peers[0].Owner)
+	}
+}
+
+func TestAdoptConfigWidensHostAddress(t *testing.T) {
+	wgConf := testWGQuickConfig(t, "10.0.0.1/32")
+
+	conf, err := AdoptConfig(wgConf, "wg0", "alice", "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if conf.Network.String() != "10.0.0.0/24" {
+		t.Fatalf("expected network 10.0.0.0/24, got %s", conf.Network.String())
+	}
+	if len(conf.Network6.IPNet.IP) != 0 {
+		t.Fatal("Network6 should be unset without an IPv6 interface address")
+	}
+	if len(conf.Peers[0].Networks) != 1 || conf.Peers[0].Networks[0].String() != "fd00::2/128" {
+		t.Fatalf("IPv6 host route outside any network should become a routed network, got %v", conf.Peers[0].Networks)
+	}
+}
+
+func TestAdoptConfigNoAddress(t *testing.T) {
+	serverKey, _ := wgtypes.GeneratePrivateKey()
+	wgConf := &lib.WGQuickConfig{Interface: lib.WGQuickInterface{PrivateKey: serverKey}}
+
+	if _, err := AdoptConfig(wgConf, "wg0", "alice", "test"); err == nil {
+		t.Fatal("expected error without an interface address")
+	}
+}
+
+func TestCoveringNetwork(t *testing.T) {
+	_, addr, _ := net.ParseCIDR("10.0.0.1/32")
+	addr.IP = net.IP{10, 0, 0, 1}
+
+	network := coveringNetwork(*addr, []net.IP{{10, 0, 3, 4}})
+	if network.String() != "10.0.0.0/22" {
+		t.Fatalf("expected 10.0.0.0/22, got %s", network.String())
+	}
+}
+
+func TestAdoptedHostname(t *testing.T) {
+	conf := testDsnetConfig(t)
+	conf.Peers = []PeerConfig{{Hostname: "laptop"}}
+
+	if h := adoptedHostname("Alice's laptop", 1); h != "Alice-s-laptop" {
+		t.Fatalf("unexpected hostname %s", h)
+	}
+	if h := adoptedHostname("", 3); h != "peer3" {
+		t.Fatalf("unexpected hostname %s", h)
+	}
+	if h := uniqueHostname(conf.Peers, "laptop"); h != "laptop-2" {
+		t.Fatalf("expected laptop-2, got %s", h)
+	}
+}
+
+func TestAdoptConfigLoads(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+
+	conf, err := AdoptConfig(testWGQuickConfig(t, "10.0.0.1/32"), "wg0", "alice", "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf.ExternalIP = net.IP{198, 51, 100, 1}
+
+	if err := conf.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if _, err := LoadConfigFile(); err != nil {
+		t.Fatalf("adopted config should load: %v", err)
+	}
+}
+
+func TestAdoptConfigKeepsKeepalive(t *testing.T) {
+	wgConf := testWGQuickConfig(t, "10.0.0.1/24")
+	wgConf.Peers[1].PersistentKeepalive = 15
+
+	conf, err := AdoptConfig(wgConf, "wg0", "alice", "Adopted from wg0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if conf.Peers[0].PersistentKeepalive != 0 || conf.Peers[1].PersistentKeepalive != 15 {
+		t.Fatalf("expected keepalives 0 and 15, got %d and %d", conf.Peers[0].PersistentKeepalive, conf.Peers[1].PersistentKeepalive)
+	}
+}
+
+func TestAdoptConfigKeepsEndpoint(t *testing.T) {
+	wgConf := testWGQuickConfig(t, "10.0.0.1/24")
+	wgConf.Peers[1].Endpoint = "router.example.com:51820"
+
+	conf, err := AdoptConfig(wgConf, "wg0", "alice", "Adopted from wg0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if conf.Peers[0].Endpoint != "" || conf.Peers[1].Endpoint != "router.example.com:51820" {
+		t.Fatalf("expected endpoints \"\" and router.example.com:51820, got %q and %q", conf.Peers[0].Endpoint, conf.Peers[1].Endpoint)
+	}
+
+	conf.ExternalIP = net.IP{192, 0, 2, 10}
+	if problems := ValidateConfig(conf); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
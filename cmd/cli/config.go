@@ -2,6 +2,7 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -9,8 +10,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/go-playground/validator"
 	"github.com/naggie/dsnet/lib"
+	"github.com/naggie/dsnet/utils"
 	"github.com/spf13/viper"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -27,16 +28,26 @@ type PeerConfig struct {
 	IP    net.IP
 	IP6   net.IP
 	Added time.Time `validate:"required"`
-	// TODO ExternalIP support (Endpoint)
-	//ExternalIP     net.UDPAddr `validate:"required,udp4_addr"`
+	// static host:port of the peer, for the server to connect to peers that
+	// are not behind NAT. Hostnames are resolved on every sync.
+	Endpoint string `json:",omitempty"`
 	// TODO support routing additional networks (AllowedIPs)
 	Networks     []lib.JSONIPNet `validate:"required"`
 	PublicKey    lib.JSONKey     `validate:"required,len=44"`
 	PrivateKey   lib.JSONKey     `json:"-"` // omitted from config!
 	PresharedKey lib.JSONKey     `validate:"required,len=44"`
+	// when PresharedKey was generated. Zero for peers added before this was
+	// recorded, in which case Added is used.
+	PresharedKeyCreated time.Time
+	// overrides the server PersistentKeepalive for this peer, unless 0
+	PersistentKeepalive int `json:",omitempty" validate:"gte=0,lte=255"`
+	// MTU of the peer interface in generated configs, unless 0
+	MTU int `json:",omitempty" validate:"gte=0,lte=65535"`
 }
 
 type DsnetConfig struct {
+	// schema version, see ConfigVersion. Older configs are migrated on load.
+	Version int
 	// When generating configs, the ExternalHostname has precendence for the
 	// server Endpoint, followed by ExternalIP (IPv4) and ExternalIP6 (IPv6)
 	// The IPs are discovered automatically on init. Define an ExternalHostname
@@ -58,22 +69,60 @@ type DsnetConfig struct {
 	IP       net.IP
 	IP6      net.IP
 	DNS      net.IP
+	// IP ranges never allocated to peers, e.g. for infrastructure. They can
+	// still be given explicitly with dsnet add --ip.
+	Reserved []lib.IPRange `json:",omitempty"`
+	// named IP ranges, allocated only to peers added with dsnet add --pool
+	Pools map[string][]lib.IPRange `json:",omitempty"`
 	// extra networks available, will be added to AllowedIPs
 	Networks []lib.JSONIPNet `validate:"required"`
 	// TODO Default subnets to route via VPN
 	PrivateKey lib.JSONKey `validate:"required,len=44"`
-	PostUp     string
-	PostDown   string
-	Peers      []PeerConfig `validate:"dive"`
+	// alternatively, PrivateKey is read from a file or the output of a
+	// command on load, so that the config itself holds no secret. PrivateKey
+	// is then never written back to the config.
+	PrivateKeyFile    string `json:",omitempty"`
+	PrivateKeyCommand string `json:",omitempty"`
+	// when PrivateKey was last rotated, if ever. Peers that have handshaken
+	// since are known to use the current key.
+	PrivateKeyActivated *time.Time `json:",omitempty"`
+	// a staged server key rotation, see `dsnet rotate-server-key`
+	KeyRotation *ServerKeyRotation `json:",omitempty"`
+	PostUp      string
+	PostDown    string
+	Peers       []PeerConfig `validate:"dive"`
 	// used for server and client
 	PersistentKeepalive int `validate:"gte=0,lte=255"`
 	MTU                 int `validate:"gte=0,lte=65535"`
+	// preshared keys older than this are rotated by sync. 0 disables.
+	PSKMaxAge lib.JSONDuration
+	// link peers with an Endpoint directly to each other in generated
+	// configs, rather than only via the server
+	Mesh bool `json:",omitempty"`
+	// secret the preshared keys of mesh peer pairs are derived from, so that
+	// they do not change with the server key. Generated when Mesh is enabled.
+	MeshKey *lib.JSONKey `json:",omitempty"`
+	// one of several servers with the same peers, see ReplicationConfig
+	Replication *ReplicationConfig `json:",omitempty"`
 }
 
 // LoadConfigFile parses the json config file, validates and stuffs
 // it in to a struct
 func LoadConfigFile() (*DsnetConfig, error) {
-	configFile := viper.GetString("config_file")
+	conf, err := ReadConfigFile(viper.GetString("config_file"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conf.Validate(); err != nil {
+		return nil, fmt.Errorf("%w - fix the config, or check it with dsnet validate", err)
+	}
+
+	return conf, nil
+}
+
+// ReadConfigFile parses and migrates a config file without validating it
+func ReadConfigFile(configFile string) (*DsnetConfig, error) {
 	raw, err := ioutil.ReadFile(configFile)
 
 	if os.IsNotExist(err) {
@@ -84,76 +133,154 @@ func LoadConfigFile() (*DsnetConfig, error) {
 		return nil, err
 	}
 
-	conf := DsnetConfig{
-		// set default for if key is not set. If it is set, this will not be
-		// used _even if value is zero!_
-		// Effectively, this is a migration
-		PersistentKeepalive: 25,
-		MTU:                 1420,
+	return parseConfig(raw, configFile)
+}
+
+// parseConfig parses and migrates the contents of configFile, in the format
+// given by its extension
+func parseConfig(raw []byte, configFile string) (*DsnetConfig, error) {
+	conf := DsnetConfig{}
+
+	format, err := configFormat(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err = configToJSON(raw, format)
+	if err != nil {
+		return nil, fmt.Errorf("%w - failed to parse %s", err, configFile)
 	}
 
-	err = json.Unmarshal(raw, &conf)
+	raw, _, err = migrateConfig(raw)
 	if err != nil {
 		return nil, err
 	}
 
-	err = validator.New().Struct(conf)
+	err = json.Unmarshal(raw, &conf)
 	if err != nil {
 		return nil, err
 	}
 
-	if conf.ExternalHostname == "" && len(conf.ExternalIP) == 0 && len(conf.ExternalIP6) == 0 {
-		return nil, fmt.Errorf("config does not contain ExternalIP, ExternalIP6 or ExternalHostname")
+	if err = conf.resolvePrivateKey(); err != nil {
+		return nil, err
 	}
 
 	return &conf, nil
 }
 
-// Save writes the configuration to disk
+// externalPrivateKey reports whether PrivateKey is kept outside the config
+func (conf *DsnetConfig) externalPrivateKey() bool {
+	return conf.PrivateKeyFile != "" || conf.PrivateKeyCommand != ""
+}
+
+// resolvePrivateKey sets PrivateKey from PrivateKeyFile or PrivateKeyCommand,
+// if either is given
+func (conf *DsnetConfig) resolvePrivateKey() error {
+	var b64Key string
+
+	switch {
+	case conf.PrivateKeyFile != "" && conf.PrivateKeyCommand != "":
+		return errors.New("only one of PrivateKeyFile or PrivateKeyCommand may be set")
+	case !conf.externalPrivateKey():
+		return nil
+	case conf.PrivateKey.Key != (wgtypes.Key{}):
+		return errors.New("PrivateKey must not be set together with PrivateKeyFile or PrivateKeyCommand")
+	case conf.PrivateKeyFile != "":
+		raw, err := ioutil.ReadFile(conf.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("%w - failed to read PrivateKeyFile", err)
+		}
+		b64Key = string(raw)
+	default:
+		output, err := utils.ShellOutput(conf.PrivateKeyCommand, "PrivateKeyCommand")
+		if err != nil {
+			return err
+		}
+		b64Key = output
+	}
+
+	key, err := wgtypes.ParseKey(strings.TrimSpace(b64Key))
+	if err != nil {
+		return fmt.Errorf("%w - invalid private key from PrivateKeyFile or PrivateKeyCommand", err)
+	}
+	conf.PrivateKey = lib.JSONKey{Key: key}
+	return nil
+}
+
+// Save writes the configuration to disk, in JSON, YAML or TOML depending on
+// the extension of the config file
 func (conf *DsnetConfig) Save() error {
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("%w - refusing to save an invalid config", err)
+	}
+
 	configFile := viper.GetString("config_file")
-	_json, _ := json.MarshalIndent(conf, "", "    ")
-	_json = append(_json, '\n')
-	err := ioutil.WriteFile(configFile, _json, 0600)
+
+	format, err := configFormat(configFile)
+	if err != nil {
+		return err
+	}
+
+	raw, err := conf.Marshal(format)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(configFile, raw, 0600)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Marshal renders the config in the given format: json, yaml or toml
+func (conf *DsnetConfig) Marshal(format string) ([]byte, error) {
+	_json, err := json.MarshalIndent(conf, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	_json = append(_json, '\n')
+
+	return configFromJSON(_json, format)
+}
+
 // AddPeer adds a provided peer to the Peers list in the conf
 func (conf *DsnetConfig) AddPeer(peer lib.Peer) error {
-	// TODO validate all PeerConfig (keys etc)
-
-	for _, p := range conf.Peers {
-		if peer.Hostname == p.Hostname {
-			return fmt.Errorf("%s is not an unique hostname", peer.Hostname)
-		}
+	newPeerConfig := PeerConfig{
+		Hostname:            peer.Hostname,
+		Description:         peer.Description,
+		Owner:               peer.Owner,
+		IP:                  peer.IP,
+		IP6:                 peer.IP6,
+		Added:               peer.Added,
+		Networks:            peer.Networks,
+		PublicKey:           peer.PublicKey,
+		PrivateKey:          peer.PrivateKey,
+		PresharedKey:        peer.PresharedKey,
+		PresharedKeyCreated: peer.PresharedKeyCreated,
+		MTU:                 peer.MTU,
+		Endpoint:            peer.Endpoint,
 	}
 
-	for _, p := range conf.Peers {
-		if peer.PublicKey.Key == p.PublicKey.Key {
-			return fmt.Errorf("%s is not an unique public key", peer.Hostname)
-		}
+	// new peers inherit the server setting, which need not be stored
+	if peer.PersistentKeepalive != conf.PersistentKeepalive {
+		newPeerConfig.PersistentKeepalive = peer.PersistentKeepalive
 	}
 
-	for _, p := range conf.Peers {
-		if peer.PresharedKey.Key == p.PresharedKey.Key {
-			return fmt.Errorf("%s is not an unique preshared key", peer.Hostname)
+	// check the config as it would be with the new peer, reporting only
+	// problems with the peer itself
+	candidate := *conf
+	candidate.Peers = append(append(make([]PeerConfig, 0, len(conf.Peers)+1), conf.Peers...), newPeerConfig)
+	peerPath := fmt.Sprintf("$.Peers[%d]", len(conf.Peers))
+
+	problems := make([]ConfigProblem, 0)
+	for _, problem := range ValidateConfig(&candidate) {
+		if problem.Path == peerPath || strings.HasPrefix(problem.Path, peerPath+".") {
+			problems = append(problems, problem)
 		}
 	}
-
-	newPeerConfig := PeerConfig{
-		Hostname:     peer.Hostname,
-		Description:  peer.Description,
-		Owner:        peer.Owner,
-		IP:           peer.IP,
-		IP6:          peer.IP6,
-		Added:        peer.Added,
-		Networks:     peer.Networks,
-		PublicKey:    peer.PublicKey,
-		PrivateKey:   peer.PrivateKey,
-		PresharedKey: peer.PresharedKey,
+	if len(problems) > 0 {
+		return fmt.Errorf("%w - cannot add %s", &InvalidConfigError{Problems: problems}, peer.Hostname)
 	}
 
 	conf.Peers = append(conf.Peers, newPeerConfig)
@@ -180,205 +307,8 @@ func (conf *DsnetConfig) RemovePeer(hostname string) error {
 	return nil
 }
 
-func (conf DsnetConfig) GetWgPeerConfigs() []wgtypes.PeerConfig {
-	wgPeers := make([]wgtypes.PeerConfig, 0, len(conf.Peers))
-
-	for _, peer := range conf.Peers {
-		// create a new PSK in memory to avoid passing the same value by
-		// pointer to each peer (d'oh)
-		presharedKey := peer.PresharedKey.Key
-
-		// AllowedIPs = private IP + defined networks
-		allowedIPs := make([]net.IPNet, 0, len(peer.Networks)+2)
-
-		if len(peer.IP) > 0 {
-			allowedIPs = append(
-				allowedIPs,
-				net.IPNet{
-					IP:   peer.IP,
-					Mask: net.IPMask{255, 255, 255, 255},
-				},
-			)
-		}
-
-		if len(peer.IP6) > 0 {
-			allowedIPs = append(
-				allowedIPs,
-				net.IPNet{
-					IP:   peer.IP6,
-					Mask: net.IPMask{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
-				},
-			)
-		}
-
-		for _, net := range peer.Networks {
-			allowedIPs = append(allowedIPs, net.IPNet)
-		}
-
-		wgPeers = append(wgPeers, wgtypes.PeerConfig{
-			PublicKey:         peer.PublicKey.Key,
-			Remove:            false,
-			UpdateOnly:        false,
-			PresharedKey:      &presharedKey,
-			Endpoint:          nil,
-			ReplaceAllowedIPs: true,
-			AllowedIPs:        allowedIPs,
-		})
-	}
-
-	return wgPeers
-}
-
-func (conf *DsnetConfig) Merge(patch map[string]interface{}) error {
-	// Merge the patch into the config
-
-	if val, ok := patch["ExternalHostname"].(string); ok && val != "" {
-		conf.ExternalHostname = val
-	}
-	if val, ok := patch["ExternalIP"].(string); ok && len(val) > 0 {
-		conf.ExternalIP = net.ParseIP(val)
-	}
-	if val, ok := patch["ExternalIP6"].(string); ok && len(val) > 0 {
-		conf.ExternalIP6 = net.ParseIP(val)
-	}
-	if val, ok := patch["ListenPort"].(int); ok && val > 0 {
-		conf.ListenPort = val
-	}
-	if val, ok := patch["Domain"].(string); ok && val != "" {
-		conf.Domain = val
-	}
-	if val, ok := patch["InterfaceName"].(string); ok && val != "" {
-		conf.InterfaceName = val
-	}
-	if val, ok := patch["Network"].(string); ok && len(val) > 0 {
-		net, err := lib.ParseJSONIPNet(val)
-		if err != nil {
-			return fmt.Errorf("failed to parse network: %w", err)
-		}
-		conf.Network = net
-	}
-	if val, ok := patch["Network6"].(string); ok && len(val) > 0 {
-		net, err := lib.ParseJSONIPNet(val)
-		if err != nil {
-			return fmt.Errorf("failed to parse network6: %w", err)
-		}
-		conf.Network6 = net
-	}
-	if val, ok := patch["IP"].(string); ok && len(val) > 0 {
-		conf.IP = net.ParseIP(val)
-	}
-	if val, ok := patch["IP6"].(string); ok && len(val) > 0 {
-		conf.IP6 = net.ParseIP(val)
-	}
-	if val, ok := patch["DNS"].(string); ok && len(val) > 0 {
-		conf.DNS = net.ParseIP(val)
-	}
-	if val, ok := patch["Networks"].([]string); ok && len(val) > 0 {
-		conf.Networks = make([]lib.JSONIPNet, len(val))
-		for i, v := range val {
-			net, err := lib.ParseJSONIPNet(v)
-			if err != nil {
-				return fmt.Errorf("failed to parse network: %w", err)
-			}
-			conf.Networks[i] = net
-		}
-	}
-	if val, ok := patch["PrivateKey"].(string); ok && len(val) > 0 {
-		conf.PrivateKey = lib.JSONKey{}
-		b64Key := strings.Trim(val, "\"")
-		key, err := wgtypes.ParseKey(b64Key)
-		if err != nil {
-			return fmt.Errorf("failed to parse private key: %w", err)
-		}
-		conf.PrivateKey.Key = key
-	}
-	if val, ok := patch["PostUp"].(string); ok && len(val) > 0 {
-		conf.PostUp = val
-	}
-	if val, ok := patch["PostDown"].(string); ok && len(val) > 0 {
-		conf.PostDown = val
-	}
-	if val, ok := patch["Peers"].([]interface{}); ok && len(val) > 0 {
-		conf.Peers = make([]PeerConfig, len(val))
-		for i, v := range val {
-			peerMap, ok := v.(map[string]interface{})
-			if !ok {
-				return fmt.Errorf("failed to parse peer: %v", v)
-			}
-			peer := PeerConfig{}
-			// decode manually without peerstructure
-			if val, ok := peerMap["Hostname"].(string); ok && val != "" {
-				peer.Hostname = val
-			} else {
-				return fmt.Errorf("failed to parse peer hostname: %v", peerMap)
-			}
-			if val, ok := peerMap["Description"].(string); ok && val != "" {
-				peer.Description = val
-			} else {
-				return fmt.Errorf("failed to parse peer description: %v", peerMap)
-			}
-			if val, ok := peerMap["Owner"].(string); ok && val != "" {
-				peer.Owner = val
-			} else {
-				return fmt.Errorf("failed to parse peer owner: %v", peerMap)
-			}
-			if val, ok := peerMap["IP"].(string); ok && len(val) > 0 {
-				peer.IP = net.ParseIP(val)
-			} else {
-				return fmt.Errorf("failed to parse peer IP: %v", peerMap)
-			}
-			if val, ok := peerMap["IP6"].(string); ok && len(val) > 0 {
-				peer.IP6 = net.ParseIP(val)
-			} else {
-				return fmt.Errorf("failed to parse peer IP6: %v", peerMap)
-			}
-			if val, ok := peerMap["Added"].(string); ok && len(val) > 0 {
-				t, err := time.Parse(time.RFC3339, val)
-				if err != nil {
-					return fmt.Errorf("failed to parse peer Added: %w", err)
-				}
-				peer.Added = t
-			} else {
-				return fmt.Errorf("failed to parse peer Added: %v", peerMap)
-			}
-			if val, ok := peerMap["Networks"].([]interface{}); ok && len(val) > 0 {
-				peer.Networks = make([]lib.JSONIPNet, len(val))
-				for j, v := range val {
-					net, err := lib.ParseJSONIPNet(v.(string))
-					if err != nil {
-						return fmt.Errorf("failed to parse peer network: %w", err)
-					}
-					peer.Networks[j] = net
-				}
-			} else {
-				return fmt.Errorf("failed to parse peer networks: %v", peerMap)
-			}
-			if val, ok := peerMap["PublicKey"].(string); ok && len(val) > 0 {
-				b64Key := strings.Trim(val, "\"")
-				key, err := wgtypes.ParseKey(b64Key)
-				if err != nil {
-					return fmt.Errorf("failed to parse peer public key: %w", err)
-				}
-				peer.PublicKey.Key = key
-			} else {
-				return fmt.Errorf("failed to parse peer public key: %v", peerMap)
-			}
-
-			if val, ok := peerMap["PresharedKey"].(string); ok && len(val) > 0 {
-				b64Key := strings.Trim(val, "\"")
-				key, err := wgtypes.ParseKey(b64Key)
-				if err != nil {
-					return fmt.Errorf("failed to parse peer preshared key: %w", err)
-				}
-				peer.PresharedKey.Key = key
-			} else {
-				return fmt.Errorf("failed to parse peer preshared key: %v", peerMap)
-			}
-
-			conf.Peers[i] = peer
-		}
-	}
-
-	// Validate the updated configuration
-	return validator.New().Struct(conf)
+// GetWgPeerConfigs returns the device config of every peer, see
+// lib.Server.GetPeers
+func (conf DsnetConfig) GetWgPeerConfigs() ([]wgtypes.PeerConfig, error) {
+	return GetServer(&conf).GetPeers()
 }
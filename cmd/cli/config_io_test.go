@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -344,3 +345,89 @@ func TestSaveOverwritesExisting(t *testing.T) {
 		t.Fatalf("expected 1 peer after overwrite, got %d", len(loaded.Peers))
 	}
 }
+
+func TestLoadPrivateKeyFromFileNotSaved(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "dsnetconfig.json")
+	keyPath := filepath.Join(tmpDir, "server.key")
+	setupViperForTest(t, configPath)
+
+	conf := testDsnetConfig(t)
+	privateKey := conf.PrivateKey
+	if err := os.WriteFile(keyPath, []byte(privateKey.Key.String()+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	conf.PrivateKey = lib.JSONKey{}
+	conf.PrivateKeyFile = keyPath
+
+	if err := conf.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if strings.Contains(string(raw), `"PrivateKey"`) {
+		t.Fatal("PrivateKey should not be written when PrivateKeyFile is set")
+	}
+
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if loaded.PrivateKey.Key != privateKey.Key {
+		t.Fatal("PrivateKey should be read from PrivateKeyFile")
+	}
+
+	// saving the loaded config must not leak the resolved key
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	raw, _ = os.ReadFile(configPath)
+	if strings.Contains(string(raw), privateKey.Key.String()) {
+		t.Fatal("resolved PrivateKey should never be written back")
+	}
+}
+
+func TestLoadPrivateKeyFromCommand(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+
+	conf := testDsnetConfig(t)
+	privateKey := conf.PrivateKey
+	conf.PrivateKey = lib.JSONKey{}
+	conf.PrivateKeyCommand = "echo " + privateKey.Key.String()
+	writeTestConfig(t, configPath, conf)
+
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if loaded.PrivateKey.Key != privateKey.Key {
+		t.Fatal("PrivateKey should be read from PrivateKeyCommand output")
+	}
+}
+
+func TestLoadPrivateKeyExternalConflicts(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+
+	conf := testDsnetConfig(t)
+	conf.PrivateKeyCommand = "false"
+
+	// PrivateKey is omitted when saving, so write it by hand
+	raw := `{"PrivateKey": "` + conf.PrivateKey.Key.String() + `", "PrivateKeyCommand": "false"}`
+	if err := os.WriteFile(configPath, []byte(raw), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := LoadConfigFile(); err == nil {
+		t.Fatal("expected error when both PrivateKey and PrivateKeyCommand are set")
+	}
+
+	conf.PrivateKey = lib.JSONKey{}
+	writeTestConfig(t, configPath, conf)
+	if _, err := LoadConfigFile(); err == nil {
+		t.Fatal("expected error when PrivateKeyCommand fails")
+	}
+}
@@ -284,7 +284,7 @@ func TestGetWgPeerConfigs(t *testing.T) {
 	peer := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
 	conf.AddPeer(peer)
 
-	wgPeers := conf.GetWgPeerConfigs()
+	wgPeers, _ := conf.GetWgPeerConfigs()
 	if len(wgPeers) != 1 {
 		t.Fatalf("expected 1 wg peer, got %d", len(wgPeers))
 	}
@@ -306,7 +306,7 @@ func TestGetWgPeerConfigsAllowedIPs(t *testing.T) {
 	peer := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
 	conf.AddPeer(peer)
 
-	wgPeers := conf.GetWgPeerConfigs()
+	wgPeers, _ := conf.GetWgPeerConfigs()
 	p := wgPeers[0]
 
 	// Should have /32 for IPv4 + /128 for IPv6 = 2
@@ -334,7 +334,7 @@ func TestGetWgPeerConfigsWithNetworks(t *testing.T) {
 
 	conf.AddPeer(peer)
 
-	wgPeers := conf.GetWgPeerConfigs()
+	wgPeers, _ := conf.GetWgPeerConfigs()
 	p := wgPeers[0]
 
 	// /32 for IPv4 + extra network = 2
@@ -351,10 +351,44 @@ func TestGetWgPeerConfigsPresharedKeyIsolation(t *testing.T) {
 	conf.AddPeer(peer1)
 	conf.AddPeer(peer2)
 
-	wgPeers := conf.GetWgPeerConfigs()
+	wgPeers, _ := conf.GetWgPeerConfigs()
 
 	// Each peer's preshared key pointer should point to different values
 	if *wgPeers[0].PresharedKey == *wgPeers[1].PresharedKey {
 		t.Fatal("preshared keys should be different between peers")
 	}
 }
+
+func TestAddPeerKeepaliveAndMTU(t *testing.T) {
+	conf := testDsnetConfig(t)
+
+	// as from NewPeer, which inherits the server keepalive
+	peer1 := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
+	peer1.PersistentKeepalive = conf.PersistentKeepalive
+	peer2 := testLibPeer(t, "phone", "alice", net.IP{10, 0, 0, 3})
+	peer2.PersistentKeepalive = 10
+	peer2.MTU = 1280
+
+	for _, peer := range []lib.Peer{peer1, peer2} {
+		if err := conf.AddPeer(peer); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if conf.Peers[0].PersistentKeepalive != 0 {
+		t.Fatalf("the server keepalive should not be stored, got %d", conf.Peers[0].PersistentKeepalive)
+	}
+	if conf.Peers[1].PersistentKeepalive != 10 || conf.Peers[1].MTU != 1280 {
+		t.Fatalf("expected keepalive 10 and MTU 1280, got %d and %d", conf.Peers[1].PersistentKeepalive, conf.Peers[1].MTU)
+	}
+
+	peers := GetServer(conf).Peers
+	if peers[1].PersistentKeepalive != 10 || peers[1].MTU != 1280 {
+		t.Fatal("expected keepalive and MTU to be copied to the server peers")
+	}
+
+	wgPeers, _ := conf.GetWgPeerConfigs()
+	if *wgPeers[0].PersistentKeepaliveInterval != 0 || *wgPeers[1].PersistentKeepaliveInterval != 10*time.Second {
+		t.Fatalf("expected server side keepalive only for phone, got %s and %s", *wgPeers[0].PersistentKeepaliveInterval, *wgPeers[1].PersistentKeepaliveInterval)
+	}
+}
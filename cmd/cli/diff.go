@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 2
+
+// lineDiff returns a minimal line diff between a and b, with changed lines
+// prefixed - or + and a little unchanged context. It is meant for previewing
+// config changes, which are small, so a simple LCS is fine.
+func lineDiff(a, b string) string {
+	aLines := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	bLines := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// lcs[i][j] is the length of the LCS of aLines[i:] and bLines[j:]
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type line struct {
+		op   byte
+		text string
+	}
+	lines := make([]line, 0, len(aLines)+len(bLines))
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			lines = append(lines, line{' ', aLines[i]})
+			i++
+			j++
+		case i < len(aLines) && (j == len(bLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', aLines[i]})
+			i++
+		default:
+			lines = append(lines, line{'+', bLines[j]})
+			j++
+		}
+	}
+
+	// only show unchanged lines near a change
+	show := make([]bool, len(lines))
+	for n, l := range lines {
+		if l.op == ' ' {
+			continue
+		}
+		for k := n - diffContext; k <= n+diffContext; k++ {
+			if k >= 0 && k < len(lines) {
+				show[k] = true
+			}
+		}
+	}
+
+	var diff strings.Builder
+	skipped := false
+	for n, l := range lines {
+		if !show[n] {
+			skipped = true
+			continue
+		}
+		if skipped && diff.Len() > 0 {
+			diff.WriteString("...\n")
+		}
+		skipped = false
+		diff.WriteByte(l.op)
+		diff.WriteString(l.text)
+		diff.WriteByte('\n')
+	}
+	return diff.String()
+}
+
+// ExitDrift is the exit code of dsnet diff and sync --dry-run when the
+// interface differs from the config; 1 is any other error
+const ExitDrift = 2
+
+// Diff compares the live interface with the config, printing what sync
+// would change. It returns an *ExitError with ExitDrift if anything differs.
+func Diff() error {
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	return diffDevice(conf)
+}
+
+func diffDevice(conf *DsnetConfig) error {
+	changes, err := GetServer(conf).Diff()
+	if err != nil {
+		return fmt.Errorf("%w - failed to read the interface", err)
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+
+	if len(changes) > 0 {
+		return &ExitError{
+			Code: ExitDrift,
+			Err:  fmt.Errorf("interface %s differs from the config in %d ways", conf.InterfaceName, len(changes)),
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "interface %s matches the config\n", conf.InterfaceName)
+	return nil
+}
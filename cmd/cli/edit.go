@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// EditOptions holds the changes to make to a peer. Empty strings and nil
+// pointers leave the field unchanged.
+type EditOptions struct {
+	Owner       string
+	Description string
+	// new hostname
+	Rename string
+	IP     string
+	IP6    string
+	// PersistentKeepalive override, 0 to use the server setting
+	Keepalive *int
+	// MTU of the peer interface, 0 to leave it to the client
+	MTU *int
+	// static host:port of the peer, "" to remove it. Server side only.
+	Endpoint *string
+	JSON     bool
+}
+
+// changesMeshPartners reports whether the edit changes what the mesh partners
+// of the peer have in their configs: its hostname, IPs or endpoint
+func (opts EditOptions) changesMeshPartners() bool {
+	return opts.Rename != "" || opts.IP != "" || opts.IP6 != "" || opts.Endpoint != nil
+}
+
+// EditPeer changes the metadata, IPs, keepalive, MTU or endpoint of a peer, keeping its
+// keys. It reports whether the client config must be reissued, i.e. whether
+// anything in it, in the --output format, has changed. conf is not changed if
+// there is an error.
+func (conf *DsnetConfig) EditPeer(hostname string, opts EditOptions) (bool, error) {
+	index := -1
+	for i, peer := range conf.Peers {
+		if peer.Hostname == hostname {
+			index = i
+		}
+	}
+	if index < 0 {
+		return false, fmt.Errorf("unknown hostname: %s", hostname)
+	}
+
+	// the rest of the config, to check the new IPs against
+	others := *conf
+	others.Peers = append(append(make([]PeerConfig, 0, len(conf.Peers)-1), conf.Peers[:index]...), conf.Peers[index+1:]...)
+	server := GetServer(&others)
+
+	peer := conf.Peers[index]
+	reissue := false
+
+	// the container formats name the config after the hostname, and
+	// annotate it with the owner and description
+	outputType := viper.GetString("output")
+	metadataInConfig := outputType == "k8s-secret" || outputType == "compose"
+
+	if opts.Owner != "" && opts.Owner != peer.Owner {
+		peer.Owner = opts.Owner
+		reissue = reissue || metadataInConfig
+	}
+	if opts.Description != "" && opts.Description != peer.Description {
+		peer.Description = opts.Description
+		reissue = reissue || metadataInConfig
+	}
+
+	if opts.Rename != "" && opts.Rename != peer.Hostname {
+		if err := checkFileSafeHostname(opts.Rename); err != nil {
+			return false, err
+		}
+		peer.Hostname = opts.Rename
+		reissue = reissue || metadataInConfig
+	}
+
+	if opts.IP != "" {
+		IP := net.ParseIP(opts.IP)
+		if IP == nil || IP.To4() == nil {
+			return false, fmt.Errorf("invalid IPv4 address %s", opts.IP)
+		}
+		if !IP.Equal(peer.IP) {
+			if err := server.CheckStaticIP(IP); err != nil {
+				return false, err
+			}
+			peer.IP = IP.To4()
+			reissue = true
+		}
+	}
+
+	if opts.IP6 != "" {
+		IP6 := net.ParseIP(opts.IP6)
+		if IP6 == nil || IP6.To4() != nil {
+			return false, fmt.Errorf("invalid IPv6 address %s", opts.IP6)
+		}
+		if !IP6.Equal(peer.IP6) {
+			if err := server.CheckStaticIP(IP6); err != nil {
+				return false, err
+			}
+			peer.IP6 = IP6
+			reissue = true
+		}
+	}
+
+	if opts.Keepalive != nil && *opts.Keepalive != peer.PersistentKeepalive {
+		peer.PersistentKeepalive = *opts.Keepalive
+		reissue = true
+	}
+
+	if opts.MTU != nil && *opts.MTU != peer.MTU {
+		peer.MTU = *opts.MTU
+		reissue = true
+	}
+
+	// the client config listens on the port of its endpoint, and in mesh
+	// mode is linked to other peers by having one
+	if opts.Endpoint != nil && *opts.Endpoint != peer.Endpoint {
+		_, oldPort, _ := net.SplitHostPort(peer.Endpoint)
+		_, newPort, _ := net.SplitHostPort(*opts.Endpoint)
+		peer.Endpoint = *opts.Endpoint
+		reissue = reissue || conf.Mesh || newPort != oldPort
+	}
+
+	candidate := others
+	candidate.Peers = append(append(make([]PeerConfig, 0, len(conf.Peers)), conf.Peers[:index]...), peer)
+	candidate.Peers = append(candidate.Peers, conf.Peers[index+1:]...)
+	if err := candidate.Validate(); err != nil {
+		return false, fmt.Errorf("%w - cannot edit %s", err, hostname)
+	}
+
+	conf.Peers[index] = peer
+	return reissue, nil
+}
+
+// Edit changes a peer, saves and syncs. If the client config has changed,
+// it is printed so that it can be reissued.
+func Edit(hostname string, opts EditOptions) error {
+	conf, err := LoadConfigFileForUpdate()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	// mesh partners before and after, which both need updating
+	partners := meshPartnersOf(conf, hostname)
+
+	reissue, err := conf.EditPeer(hostname, opts)
+	if err != nil {
+		return err
+	}
+
+	if err = conf.SaveChange("Edit peer " + hostname); err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	server := GetServer(conf)
+	if err = WarnUnresolvedEndpoints(server.ConfigureDevice()); err != nil {
+		return fmt.Errorf("%w - failed to configure device", err)
+	}
+
+	if opts.Rename != "" {
+		hostname = opts.Rename
+	}
+
+	if opts.changesMeshPartners() {
+		printMeshNotice(hostname, appendUnique(partners, meshPartnersOf(conf, hostname)...))
+	}
+
+	if !reissue {
+		fmt.Fprintf(os.Stderr, "The client config of %s is unchanged, it does not need to be reissued.\n", hostname)
+		return nil
+	}
+
+	for _, peer := range server.Peers {
+		if peer.Hostname == hostname {
+			if err = PrintPeerConfig(peer, server, opts.JSON); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "The client config of %s has changed and must be reissued. %s\n", hostname, privateKeyNotice)
+	return nil
+}
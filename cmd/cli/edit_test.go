@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+func testEditConfig(t *testing.T) *DsnetConfig {
+	t.Helper()
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if err := conf.AddPeer(testLibPeer(t, "phone", "bob", net.IP{10, 0, 0, 3})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	return conf
+}
+
+func TestEditPeerMetadata(t *testing.T) {
+	conf := testEditConfig(t)
+	publicKey := conf.Peers[0].PublicKey
+	viper.Set("output", "wg-quick")
+	t.Cleanup(func() { viper.Set("output", "") })
+
+	reissue, err := conf.EditPeer("laptop", EditOptions{Owner: "carol", Description: "work laptop", Rename: "worklaptop"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reissue {
+		t.Fatal("metadata changes should not require a wg-quick config to be reissued")
+	}
+
+	peer := conf.Peers[0]
+	if peer.Hostname != "worklaptop" || peer.Owner != "carol" || peer.Description != "work laptop" {
+		t.Fatalf("unexpected peer after edit: %+v", peer)
+	}
+	if peer.PublicKey != publicKey {
+		t.Fatal("keys should be kept")
+	}
+}
+
+func TestEditPeerMetadataContainer(t *testing.T) {
+	t.Cleanup(func() { viper.Set("output", "") })
+	for _, output := range []string{"k8s-secret", "compose"} {
+		viper.Set("output", output)
+		for _, opts := range []EditOptions{{Owner: "carol"}, {Description: "work laptop"}, {Rename: "worklaptop"}} {
+			conf := testEditConfig(t)
+			if reissue, err := conf.EditPeer("laptop", opts); err != nil || !reissue {
+				t.Fatalf("expected %+v to require a %s config to be reissued, got %v, %v", opts, output, reissue, err)
+			}
+		}
+
+		// unchanged metadata leaves the config as it is
+		conf := testEditConfig(t)
+		if reissue, err := conf.EditPeer("laptop", EditOptions{Owner: "alice"}); err != nil || reissue {
+			t.Fatalf("expected no reissue for an unchanged owner, got %v, %v", reissue, err)
+		}
+	}
+}
+
+func TestEditPeerNetwork(t *testing.T) {
+	conf := testEditConfig(t)
+	keepalive := 15
+
+	reissue, err := conf.EditPeer("phone", EditOptions{IP: "10.0.0.9", IP6: "fd00::9", Keepalive: &keepalive})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reissue {
+		t.Fatal("IP changes should require the client config to be reissued")
+	}
+
+	peer := conf.Peers[1]
+	if !peer.IP.Equal(net.IP{10, 0, 0, 9}) || !peer.IP6.Equal(net.ParseIP("fd00::9")) || peer.PersistentKeepalive != 15 {
+		t.Fatalf("unexpected peer after edit: %+v", peer)
+	}
+
+	mtu := 1280
+	if reissue, err = conf.EditPeer("phone", EditOptions{MTU: &mtu}); err != nil || !reissue || conf.Peers[1].MTU != 1280 {
+		t.Fatalf("expected the MTU to change, got %v, %v", reissue, err)
+	}
+
+	// setting the same IP again is not a change
+	if reissue, err = conf.EditPeer("phone", EditOptions{IP: "10.0.0.9"}); err != nil || reissue {
+		t.Fatalf("expected no change, got %v, %v", reissue, err)
+	}
+}
+
+func TestEditPeerReissuedConfig(t *testing.T) {
+	conf := testEditConfig(t)
+	// as loaded from the config file
+	conf.Peers[0].PrivateKey = lib.JSONKey{}
+	keepalive := 15
+
+	if _, err := conf.EditPeer("laptop", EditOptions{Keepalive: &keepalive}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := GetServer(conf)
+	buf, err := lib.GetWGPeerTemplate(server.Peers[0], lib.WGQuick, *server)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := buf.String()
+	if !strings.Contains(config, "PersistentKeepalive=15\n") {
+		t.Fatalf("expected the reissued config to have the new keepalive, got:\n%s", config)
+	}
+	if !strings.Contains(config, "PrivateKey="+lib.PrivateKeyPlaceholder+"\n") {
+		t.Fatalf("expected the unknown private key to be a placeholder, got:\n%s", config)
+	}
+}
+
+func TestEditPeerErrors(t *testing.T) {
+	conf := testEditConfig(t)
+	tooLong := 256
+
+	for _, tc := range []struct {
+		name     string
+		hostname string
+		opts     EditOptions
+	}{
+		{"unknown hostname", "tablet", EditOptions{Owner: "carol"}},
+		{"duplicate hostname", "phone", EditOptions{Rename: "laptop"}},
+		{"unsafe hostname", "phone", EditOptions{Rename: "../phone"}},
+		{"IP of another peer", "phone", EditOptions{IP: "10.0.0.2"}},
+		{"server IP", "phone", EditOptions{IP: "10.0.0.1"}},
+		{"IP outside the network", "phone", EditOptions{IP: "192.168.0.3"}},
+		{"IPv6 as IP", "phone", EditOptions{IP: "fd00::9"}},
+		{"IPv4 as IP6", "phone", EditOptions{IP6: "10.0.0.9"}},
+		{"keepalive out of range", "phone", EditOptions{Keepalive: &tooLong}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := conf.Peers[1]
+			if _, err := conf.EditPeer(tc.hostname, tc.opts); err == nil {
+				t.Fatal("expected an error")
+			}
+			if conf.Peers[1].Hostname != before.Hostname || !conf.Peers[1].IP.Equal(before.IP) || conf.Peers[1].PersistentKeepalive != before.PersistentKeepalive {
+				t.Fatal("expected the config to be unchanged")
+			}
+		})
+	}
+}
+
+func TestEditPeerEndpoint(t *testing.T) {
+	conf := testEditConfig(t)
+	endpoint := "router.example.com:51820"
+
+	// the client config listens on the endpoint port
+	reissue, err := conf.EditPeer("laptop", EditOptions{Endpoint: &endpoint})
+	if err != nil || !reissue || conf.Peers[0].Endpoint != endpoint {
+		t.Fatalf("expected the endpoint to change with a reissue, got %v, %v, %q", reissue, err, conf.Peers[0].Endpoint)
+	}
+
+	endpoint = "203.0.113.7:51820"
+	if reissue, err = conf.EditPeer("laptop", EditOptions{Endpoint: &endpoint}); err != nil || reissue || conf.Peers[0].Endpoint != endpoint {
+		t.Fatalf("expected the host to change without a reissue, got %v, %v, %q", reissue, err, conf.Peers[0].Endpoint)
+	}
+
+	invalid := "router.example.com"
+	if _, err = conf.EditPeer("laptop", EditOptions{Endpoint: &invalid}); err == nil || conf.Peers[0].Endpoint != endpoint {
+		t.Fatal("expected an invalid endpoint to be rejected")
+	}
+
+	none := ""
+	if _, err = conf.EditPeer("laptop", EditOptions{Endpoint: &none}); err != nil || conf.Peers[0].Endpoint != "" {
+		t.Fatalf("expected the endpoint to be removed, got %v", err)
+	}
+}
+
+func TestEditPeerEndpointMesh(t *testing.T) {
+	conf := testEditConfig(t)
+	conf.Mesh = true
+	if err := conf.ensureMeshKey(); err != nil {
+		t.Fatal(err)
+	}
+	laptop, phone := "192.0.2.2:51820", "192.0.2.3:51820"
+
+	// the client config now links to the other reachable peers
+	if reissue, err := conf.EditPeer("laptop", EditOptions{Endpoint: &laptop}); err != nil || !reissue {
+		t.Fatalf("expected a reissue in mesh mode, got %v, %v", reissue, err)
+	}
+	if reissue, err := conf.EditPeer("phone", EditOptions{Endpoint: &phone}); err != nil || !reissue {
+		t.Fatalf("expected a reissue in mesh mode, got %v, %v", reissue, err)
+	}
+
+	server := GetServer(conf)
+	if partners := meshPartners(server, server.Peers[0]); len(partners) != 1 || partners[0] != "phone" {
+		t.Fatalf("expected laptop to link to phone, got %v", partners)
+	}
+}
+
+func TestEditPeerIPMesh(t *testing.T) {
+	conf := testEditConfig(t)
+	conf.Mesh = true
+	if err := conf.ensureMeshKey(); err != nil {
+		t.Fatal(err)
+	}
+	laptop, phone := "192.0.2.2:51820", "192.0.2.3:51820"
+	conf.Peers[0].Endpoint = laptop
+	conf.Peers[1].Endpoint = phone
+
+	// phone has the IP of laptop in its config
+	partners := meshPartnersOf(conf, "laptop")
+	opts := EditOptions{IP: "10.0.0.20"}
+	if _, err := conf.EditPeer("laptop", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.changesMeshPartners() {
+		t.Fatal("expected an IP change to affect the mesh partners")
+	}
+	if partners = appendUnique(partners, meshPartnersOf(conf, "laptop")...); len(partners) != 1 || partners[0] != "phone" {
+		t.Fatalf("expected phone to be told, got %v", partners)
+	}
+
+	keepalive := 15
+	if (EditOptions{Owner: "carol", Keepalive: &keepalive}).changesMeshPartners() {
+		t.Fatal("expected the owner and keepalive to stay out of the configs of mesh partners")
+	}
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+// ExportServer writes the server side of the network, the server interface
+// and every peer, to stdout in the format selected by --output
+// (wg-quick/nixos/networkd). Useful to move a network off dsnet or to run a
+// cold standby without dsnet.
+func ExportServer() error {
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	server := GetServer(conf)
+
+	serverConfigBytes, err := lib.AsciiServerConfig(*server, viper.GetString("output"))
+	if err != nil {
+		return fmt.Errorf("%w - failed to get server configuration", err)
+	}
+
+	os.Stdout.Write(serverConfigBytes.Bytes())
+	return nil
+}
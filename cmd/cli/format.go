@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// The config is always (un)marshalled as JSON, so that JSONIPNet, JSONKey,
+// secret encryption and validation behave the same whatever the format. YAML
+// and TOML are converted to and from JSON at the edges.
+
+// configFormat returns the format of a config file by extension: json, yaml
+// or toml
+func configFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", "":
+		return "json", nil
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".toml":
+		return "toml", nil
+	default:
+		return "", fmt.Errorf("unsupported config file extension %s, use .json, .yaml, .yml or .toml", filepath.Ext(path))
+	}
+}
+
+// configToJSON converts a config file in the given format to JSON
+func configToJSON(raw []byte, format string) ([]byte, error) {
+	var generic map[string]interface{}
+
+	switch format {
+	case "json":
+		return raw, nil
+	case "yaml":
+		if err := yaml.Unmarshal(raw, &generic); err != nil {
+			return nil, err
+		}
+	case "toml":
+		if err := toml.Unmarshal(raw, &generic); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %s", format)
+	}
+
+	return json.Marshal(generic)
+}
+
+// configFromJSON converts a marshalled config to the given format. Key order
+// is kept for YAML; TOML keys are sorted.
+func configFromJSON(_json []byte, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return _json, nil
+	case "yaml":
+		// JSON is YAML, so parse it as such to keep the key order, then
+		// switch from flow to block style
+		var node yaml.Node
+		if err := yaml.Unmarshal(_json, &node); err != nil {
+			return nil, err
+		}
+		resetYAMLStyle(&node)
+		return yaml.Marshal(&node)
+	case "toml":
+		decoder := json.NewDecoder(bytes.NewReader(_json))
+		decoder.UseNumber()
+		var generic map[string]interface{}
+		if err := decoder.Decode(&generic); err != nil {
+			return nil, err
+		}
+		return toml.Marshal(tomlValue(generic))
+	default:
+		return nil, fmt.Errorf("unsupported config format %s", format)
+	}
+}
+
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// tomlValue prepares a generic JSON value for TOML, which has no null and
+// distinguishes integers from floats
+func tomlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if child == nil {
+				delete(v, key)
+			} else {
+				v[key] = tomlValue(child)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = tomlValue(child)
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// Convert writes the config in another format (json, yaml or toml) beside
+// the current config file, e.g. /etc/dsnetconfig.yaml. The current file is
+// left alone; point DSNET_CONFIG_FILE at the new one to switch.
+func Convert(to string) error {
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	configFile := viper.GetString("config_file")
+	from, err := configFormat(configFile)
+	if err != nil {
+		return err
+	}
+
+	newConfigFile := strings.TrimSuffix(configFile, filepath.Ext(configFile)) + "." + to
+	format, err := configFormat(newConfigFile)
+	if err != nil || to == "" {
+		return fmt.Errorf("cannot convert to %q, use json, yaml or toml", to)
+	}
+	if from == format {
+		return fmt.Errorf("%s is already %s", configFile, format)
+	}
+
+	if _, err := os.Stat(newConfigFile); !os.IsNotExist(err) {
+		return fmt.Errorf("Refusing to overwrite existing %s", newConfigFile)
+	}
+
+	raw, err := conf.Marshal(format)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(newConfigFile, raw, 0600); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Config written to %s. Set DSNET_CONFIG_FILE=%s to use it, then remove %s.\n", newConfigFile, newConfigFile, configFile)
+	return nil
+}
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/naggie/dsnet/lib"
+)
+
+func TestConfigFormat(t *testing.T) {
+	cases := map[string]string{
+		"/etc/dsnetconfig.json": "json",
+		"/etc/dsnetconfig.yaml": "yaml",
+		"/etc/dsnetconfig.YML":  "yaml",
+		"/etc/dsnetconfig.toml": "toml",
+		"/etc/dsnetconfig":      "json",
+	}
+	for path, expected := range cases {
+		format, err := configFormat(path)
+		if err != nil || format != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", path, expected, format, err)
+		}
+	}
+
+	if _, err := configFormat("/etc/dsnetconfig.ini"); err == nil {
+		t.Fatal("expected error for unsupported extension")
+	}
+}
+
+func testSaveAndLoadFormat(t *testing.T, name string) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), name)
+	setupViperForTest(t, configPath)
+
+	conf := testDsnetConfig(t)
+	peer := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
+	_, extraNet, _ := net.ParseCIDR("192.168.1.0/24")
+	peer.Networks = append(peer.Networks, lib.JSONIPNet{IPNet: *extraNet})
+	if err := conf.AddPeer(peer); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	if err := conf.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	if loaded.PrivateKey.Key != conf.PrivateKey.Key || loaded.ListenPort != conf.ListenPort {
+		t.Fatal("server fields did not round trip")
+	}
+	if loaded.Network.IPNet.String() != conf.Network.IPNet.String() {
+		t.Fatalf("network did not round trip: %s", loaded.Network.IPNet.String())
+	}
+	if len(loaded.Peers) != 1 || loaded.Peers[0].PresharedKey.Key != conf.Peers[0].PresharedKey.Key {
+		t.Fatal("peers did not round trip")
+	}
+	if !loaded.Peers[0].Added.Equal(conf.Peers[0].Added) {
+		t.Fatalf("Added did not round trip: %s", loaded.Peers[0].Added)
+	}
+	if len(loaded.Peers[0].Networks) != 1 || loaded.Peers[0].Networks[0].IPNet.String() != "192.168.1.0/24" {
+		t.Fatalf("peer networks did not round trip: %v", loaded.Peers[0].Networks)
+	}
+
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	return string(raw)
+}
+
+func TestSaveAndLoadYAML(t *testing.T) {
+	raw := testSaveAndLoadFormat(t, "dsnetconfig.yaml")
+
+	if !strings.Contains(raw, "ListenPort: 51820\n") {
+		t.Fatalf("expected block style YAML, got:\n%s", raw)
+	}
+	// key order is kept
+	if strings.Index(raw, "ExternalHostname") > strings.Index(raw, "Peers") {
+		t.Fatal("expected YAML keys in struct order")
+	}
+}
+
+func TestSaveAndLoadTOML(t *testing.T) {
+	raw := testSaveAndLoadFormat(t, "dsnetconfig.toml")
+
+	if !strings.Contains(raw, "ListenPort = 51820\n") {
+		t.Fatalf("expected integer ListenPort in TOML, got:\n%s", raw)
+	}
+	if !strings.Contains(raw, "[[Peers]]") {
+		t.Fatalf("expected peers as an array of tables, got:\n%s", raw)
+	}
+}
+
+func TestLoadYAMLValidates(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.yaml")
+	setupViperForTest(t, configPath)
+
+	if err := os.WriteFile(configPath, []byte("ListenPort: 0\nDomain: dsnet\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := LoadConfigFile(); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+
+	conf := testDsnetConfig(t)
+	writeTestConfig(t, configPath, conf)
+
+	if err := Convert("json"); err == nil {
+		t.Fatal("expected error converting to the same format")
+	}
+
+	if err := Convert("yml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	setupViperForTest(t, strings.TrimSuffix(configPath, ".json")+".yml")
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load converted config: %v", err)
+	}
+	if loaded.PrivateKey.Key != conf.PrivateKey.Key {
+		t.Fatal("converted config does not match")
+	}
+
+	setupViperForTest(t, configPath)
+	if err := Convert("yml"); err == nil {
+		t.Fatal("expected error overwriting existing converted config")
+	}
+}
@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+// gitEnabled reports whether changes to the config are committed to the git
+// repo containing it, as set by DSNET_GIT
+func gitEnabled() bool {
+	return viper.GetBool("git")
+}
+
+// runGit runs git in dir and returns its stdout
+func runGit(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w - git %s: %s", err, args[0], msg)
+		}
+		return "", fmt.Errorf("%w - git %s", err, args[0])
+	}
+	return string(output), nil
+}
+
+// configRepo returns the directory and name of the config file, as used
+// with git -C
+func configRepo() (string, string) {
+	configFile := viper.GetString("config_file")
+	return filepath.Dir(configFile), filepath.Base(configFile)
+}
+
+// checkGitStore fails if the config cannot be committed: if its directory is
+// not in a git repo, or if secrets would be committed in plaintext
+func checkGitStore(conf *DsnetConfig) error {
+	dir, _ := configRepo()
+	if _, err := runGit(dir, nil, "rev-parse", "--show-toplevel"); err != nil {
+		return fmt.Errorf("%w - DSNET_GIT is set but %s is not in a git repo. `git init %s` creates one", err, dir, dir)
+	}
+
+	// the server private key may be in PrivateKeyFile, but preshared keys are
+	// always in the config
+	if !lib.SecretsEncrypted() {
+		return errors.New("DSNET_GIT requires secrets to be encrypted at rest, so that keys are not committed. See DSNET_SECRET_KEY_FILE")
+	}
+
+	secretFiles := []string{viper.GetString("secret_key_file"), conf.PrivateKeyFile}
+	if conf.Replication != nil {
+		secretFiles = append(secretFiles, conf.Replication.KeyFile)
+	}
+	for _, secretFile := range secretFiles {
+		if secretFile == "" {
+			continue
+		}
+		path, err := filepath.Abs(secretFile)
+		if err != nil {
+			return err
+		}
+		// fails for untracked files and files outside the repo
+		if _, err = runGit(dir, nil, "ls-files", "--error-unmatch", "--", path); err == nil {
+			return fmt.Errorf("%s is tracked by git, remove it from the repo with `git rm --cached` and rotate the keys it holds", secretFile)
+		}
+	}
+
+	return nil
+}
+
+// gitIdentity returns the environment to commit as the user running dsnet,
+// via sudo if so. The committer is taken from the git config if set, so that
+// commits show both the user and the server.
+func gitIdentity(dir string) []string {
+	user := os.Getenv("SUDO_USER")
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	if user == "" {
+		user = "dsnet"
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+	email := user + "@" + hostname
+
+	env := []string{"GIT_AUTHOR_NAME=" + user, "GIT_AUTHOR_EMAIL=" + email}
+
+	name, _ := runGit(dir, nil, "config", "user.name")
+	configEmail, _ := runGit(dir, nil, "config", "user.email")
+	if strings.TrimSpace(name) == "" || strings.TrimSpace(configEmail) == "" {
+		env = append(env, "GIT_COMMITTER_NAME="+user, "GIT_COMMITTER_EMAIL="+email)
+	}
+	return env
+}
+
+// commitConfig commits the config file, and nothing else, with message. It
+// does nothing if the config file is unchanged.
+func commitConfig(message string) error {
+	dir, name := configRepo()
+
+	if _, err := runGit(dir, nil, "add", "--", name); err != nil {
+		return err
+	}
+
+	if _, err := runGit(dir, nil, "diff", "--cached", "--quiet", "--", name); err == nil {
+		return nil
+	}
+
+	_, err := runGit(dir, gitIdentity(dir), "commit", "--quiet", "-m", message, "--", name)
+	return err
+}
+
+// notCommittedError is returned by SaveChange when the config was saved but
+// could not be committed
+type notCommittedError struct {
+	Err error
+}
+
+func (e *notCommittedError) Error() string {
+	return fmt.Sprintf("%v - config saved but not committed", e.Err)
+}
+
+func (e *notCommittedError) Unwrap() error {
+	return e.Err
+}
+
+// SaveChange saves the config after a change described by message, as the
+// subject of the commit if DSNET_GIT is set
+func (conf *DsnetConfig) SaveChange(message string) error {
+	if gitEnabled() {
+		if err := checkGitStore(conf); err != nil {
+			return err
+		}
+	}
+
+	if err := conf.Save(); err != nil {
+		return err
+	}
+
+	if gitEnabled() {
+		if err := commitConfig(message); err != nil {
+			return &notCommittedError{err}
+		}
+	}
+	return nil
+}
+
+// checkoutConfigRevision reads the config file as of a git revision, such as
+// a tag, branch or HEAD~1, and validates it. Unless dryRun, the config file is
+// then replaced by that revision and committed if DSNET_GIT is set, so that
+// the config file always matches the interface.
+func checkoutConfigRevision(ref string, dryRun bool) (*DsnetConfig, error) {
+	dir, name := configRepo()
+
+	commit, err := runGit(dir, nil, "rev-parse", "--verify", "--short", ref+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("%w - unknown revision %s", err, ref)
+	}
+	commit = strings.TrimSpace(commit)
+
+	raw, err := runGit(dir, nil, "show", commit+":./"+name)
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s is not in revision %s", err, name, ref)
+	}
+
+	conf, err := parseConfig([]byte(raw), name)
+	if err != nil {
+		return nil, fmt.Errorf("%w - failed to parse %s at %s", err, name, ref)
+	}
+	if err = conf.Validate(); err != nil {
+		return nil, fmt.Errorf("%w - refusing to sync revision %s", err, ref)
+	}
+
+	if dryRun {
+		return conf, nil
+	}
+
+	if gitEnabled() {
+		if err = checkGitStore(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	// as committed rather than re-marshalled, so that resealed secrets do not
+	// make a change
+	if err = ioutil.WriteFile(viper.GetString("config_file"), []byte(raw), 0600); err != nil {
+		return nil, err
+	}
+
+	if gitEnabled() {
+		if err = commitConfig(fmt.Sprintf("Sync config from %s (%s)", ref, commit)); err != nil {
+			return nil, fmt.Errorf("%w - config saved but not committed", err)
+		}
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// useGitStoreForTest enables DSNET_GIT with the config file in a new git repo,
+// returning the repo directory
+func useGitStoreForTest(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	if _, err := runGit(dir, nil, "init", "--quiet"); err != nil {
+		t.Fatal(err)
+	}
+	setupViperForTest(t, filepath.Join(dir, "dsnetconfig.json"))
+
+	viper.Set("git", true)
+	t.Cleanup(func() { viper.Set("git", false) })
+
+	oldSudoUser, hadSudoUser := os.LookupEnv("SUDO_USER")
+	os.Setenv("SUDO_USER", "alice")
+	t.Cleanup(func() {
+		if hadSudoUser {
+			os.Setenv("SUDO_USER", oldSudoUser)
+		} else {
+			os.Unsetenv("SUDO_USER")
+		}
+	})
+
+	return dir
+}
+
+func gitLog(t *testing.T, dir, format string) []string {
+	t.Helper()
+	output, err := runGit(dir, nil, "log", "--format="+format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(output), "\n")
+}
+
+func TestSaveChange(t *testing.T) {
+	dir := useGitStoreForTest(t)
+	conf := testDsnetConfig(t)
+
+	// keys would be committed in plaintext
+	if err := conf.SaveChange("Initialise config"); err == nil {
+		t.Fatal("expected plaintext secrets to be refused")
+	}
+	if _, err := os.Stat(viper.GetString("config_file")); !os.IsNotExist(err) {
+		t.Fatal("expected the config not to be saved")
+	}
+
+	setSecretKeyFileForTest(t, "passphrase")
+	if err := conf.SaveChange("Initialise config"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.SaveChange("Add peer laptop (alice)"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// secrets loaded unchanged are saved as they were, so once migrated
+	// nothing is committed
+	for _, message := range []string{"Migrate config", "Nothing"} {
+		loaded, err := LoadConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = loaded.SaveChange(message); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if status, _ := runGit(dir, nil, "status", "--porcelain"); status != "" {
+		t.Fatalf("expected a clean tree, got %q", status)
+	}
+
+	if log := gitLog(t, dir, "%an: %s"); strings.Join(log, "\n") != "alice: Migrate config\nalice: Add peer laptop (alice)\nalice: Initialise config" {
+		t.Fatalf("unexpected log %q", log)
+	}
+
+	committed, err := runGit(dir, nil, "show", "HEAD:dsnetconfig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(committed, conf.Peers[0].PresharedKey.Key.String()) {
+		t.Fatal("expected the committed preshared key to be encrypted")
+	}
+}
+
+func TestSaveChangeTrackedSecretKey(t *testing.T) {
+	dir := useGitStoreForTest(t)
+
+	keyFile := filepath.Join(dir, "secret.key")
+	if err := os.WriteFile(keyFile, []byte("passphrase"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setSecretKeyFileForTest(t, "passphrase")
+	viper.Set("secret_key_file", keyFile)
+
+	// untracked keys are fine, as only the config is committed
+	if err := testDsnetConfig(t).SaveChange("Initialise config"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := runGit(dir, nil, "add", "secret.key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDsnetConfig(t).SaveChange("Initialise config"); err == nil {
+		t.Fatal("expected a tracked secret key file to be refused")
+	}
+}
+
+func TestSaveChangeNotRepo(t *testing.T) {
+	setupViperForTest(t, filepath.Join(t.TempDir(), "dsnetconfig.json"))
+	viper.Set("git", true)
+	t.Cleanup(func() { viper.Set("git", false) })
+	setSecretKeyFileForTest(t, "passphrase")
+
+	if err := testDsnetConfig(t).SaveChange("Initialise config"); err == nil {
+		t.Fatal("expected an error outside a git repo")
+	}
+}
+
+func TestCheckoutConfigRevision(t *testing.T) {
+	dir := useGitStoreForTest(t)
+	setSecretKeyFileForTest(t, "passphrase")
+
+	conf := testDsnetConfig(t)
+	if err := conf.SaveChange("Initialise config"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(dir, nil, "tag", "initial"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.SaveChange("Add peer laptop (alice)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// dry run leaves the config file alone
+	reverted, err := checkoutConfigRevision("initial", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reverted.Peers) != 0 {
+		t.Fatalf("expected the config without peers, got %d", len(reverted.Peers))
+	}
+	if current, _ := LoadConfigFile(); len(current.Peers) != 1 {
+		t.Fatal("expected the config file to be unchanged")
+	}
+
+	if _, err = checkoutConfigRevision("initial", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := LoadConfigFile(); len(current.Peers) != 0 {
+		t.Fatal("expected the config file to be replaced")
+	}
+	if log := gitLog(t, dir, "%s"); !strings.HasPrefix(log[0], "Sync config from initial (") {
+		t.Fatalf("unexpected log %q", log)
+	}
+
+	// syncing the same revision again makes no commit
+	if _, err = checkoutConfigRevision("HEAD", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if log := gitLog(t, dir, "%s"); len(log) != 3 {
+		t.Fatalf("expected no new commit, got %q", log)
+	}
+
+	if _, err = checkoutConfigRevision("no-such-ref", true); err == nil {
+		t.Fatal("expected an unknown revision to be refused")
+	}
+}
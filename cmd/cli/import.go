@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/naggie/dsnet/lib"
+)
+
+// ImportRecord describes a peer to be created by Import. CSV columns are in
+// the same order; PublicKey and Networks are optional. Networks are separated
+// by spaces or semicolons in CSV.
+type ImportRecord struct {
+	Hostname    string
+	Owner       string
+	Description string
+	PublicKey   string
+	Networks    []string
+}
+
+// ParseImportCSV reads import records from CSV. A header row starting with
+// "hostname" is skipped, as are lines starting with #.
+func ParseImportCSV(r io.Reader) ([]ImportRecord, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records := make([]ImportRecord, 0)
+	rowNum := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		rowNum++
+		if rowNum == 1 && strings.EqualFold(strings.TrimSpace(row[0]), "hostname") {
+			continue
+		}
+
+		if len(row) < 3 || len(row) > 5 {
+			return nil, fmt.Errorf("row %d: expected 3 to 5 columns (hostname, owner, description, public key, networks), got %d", rowNum, len(row))
+		}
+
+		record := ImportRecord{
+			Hostname:    strings.TrimSpace(row[0]),
+			Owner:       strings.TrimSpace(row[1]),
+			Description: strings.TrimSpace(row[2]),
+		}
+		if len(row) > 3 {
+			record.PublicKey = strings.TrimSpace(row[3])
+		}
+		if len(row) > 4 {
+			record.Networks = strings.FieldsFunc(row[4], func(r rune) bool {
+				return r == ' ' || r == ';'
+			})
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ParseImportJSON reads import records from a JSON array of objects with the
+// same fields as ImportRecord
+func ParseImportJSON(r io.Reader) ([]ImportRecord, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	records := make([]ImportRecord, 0)
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ImportPeers creates a peer for each record and adds it to conf. Nothing is
+// saved; on error conf must be discarded, making the import all-or-nothing.
+func ImportPeers(conf *DsnetConfig, records []ImportRecord) ([]lib.Peer, error) {
+	server := GetServer(conf)
+	peers := make([]lib.Peer, 0, len(records))
+
+	for i, record := range records {
+		if err := checkFileSafeHostname(record.Hostname); err != nil {
+			return nil, fmt.Errorf("%w - record %d", err, i+1)
+		}
+
+		peer, err := lib.NewPeer(server, "", record.PublicKey, record.Owner, record.Hostname, record.Description)
+		if err != nil {
+			return nil, fmt.Errorf("%w - record %d (%s)", err, i+1, record.Hostname)
+		}
+
+		for _, cidr := range record.Networks {
+			network, err := lib.ParseJSONIPNet(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%w - record %d (%s)", err, i+1, record.Hostname)
+			}
+			peer.Networks = append(peer.Networks, network)
+		}
+
+		if err = conf.AddPeer(peer); err != nil {
+			return nil, fmt.Errorf("%w - record %d", err, i+1)
+		}
+
+		// so the next allocation sees this peer's IPs
+		server.Peers = append(server.Peers, peer)
+		peers = append(peers, peer)
+	}
+
+	return peers, nil
+}
+
+// Import creates peers in bulk from a CSV or JSON (by extension) file, writes
+// their configs to outputDir, then saves and syncs once. If any peer fails,
+// nothing is changed.
+func Import(path, outputDir string, confirm bool) error {
+	conf, err := LoadConfigFileForUpdate()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records []ImportRecord
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		records, err = ParseImportJSON(f)
+	} else {
+		records, err = ParseImportCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("%w - failed to parse %s", err, path)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("no peers found in %s", path)
+	}
+
+	peers, err := ImportPeers(conf, records)
+	if err != nil {
+		return fmt.Errorf("%w - nothing was imported", err)
+	}
+
+	if !confirm {
+		if err = ConfirmOrAbort("Import %d peers, writing configs to %s?", len(peers), outputDir); err != nil {
+			return err
+		}
+	}
+
+	server := GetServer(conf)
+
+	written, err := WritePeerConfigs(outputDir, peers, server)
+	if err != nil {
+		return fmt.Errorf("%w - nothing was imported", err)
+	}
+
+	hostnames := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		hostnames = append(hostnames, peer.Hostname)
+	}
+	if err = conf.SaveChange(fmt.Sprintf("Import %d peers: %s", len(peers), strings.Join(hostnames, ", "))); err != nil {
+		discardPeerConfigs(written, err)
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	if err = WarnUnresolvedEndpoints(server.ConfigureDevice()); err != nil {
+		return fmt.Errorf("%w - failed to configure device", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d peers, configs written to %s\n", len(peers), outputDir)
+	return nil
+}
@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	pubKey, _ := wgtypes.GeneratePrivateKey()
+	input := "hostname,owner,description,public key,networks\n" +
+		"# comment\n" +
+		"laptop,alice,Alice's laptop\n" +
+		"router,bob,Office router," + pubKey.PublicKey().String() + ",192.168.1.0/24;192.168.2.0/24\n"
+
+	records, err := ParseImportCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Hostname != "laptop" || records[0].Owner != "alice" || records[0].PublicKey != "" {
+		t.Fatalf("unexpected first record: %+v", records[0])
+	}
+	if records[1].PublicKey != pubKey.PublicKey().String() {
+		t.Fatalf("expected public key, got %q", records[1].PublicKey)
+	}
+	if len(records[1].Networks) != 2 || records[1].Networks[1] != "192.168.2.0/24" {
+		t.Fatalf("unexpected networks: %v", records[1].Networks)
+	}
+}
+
+func TestParseImportCSVTooFewColumns(t *testing.T) {
+	_, err := ParseImportCSV(strings.NewReader("laptop,alice\n"))
+	if err == nil {
+		t.Fatal("expected error for missing columns")
+	}
+}
+
+func TestParseImportJSON(t *testing.T) {
+	input := `[{"Hostname": "laptop", "Owner": "alice", "Description": "test", "Networks": ["192.168.1.0/24"]}]`
+
+	records, err := ParseImportJSON(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 || records[0].Networks[0] != "192.168.1.0/24" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
+
+func TestParseImportJSONUnknownField(t *testing.T) {
+	_, err := ParseImportJSON(strings.NewReader(`[{"Hostname": "laptop", "Colour": "red"}]`))
+	if err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestImportPeers(t *testing.T) {
+	conf := testDsnetConfig(t)
+	records := []ImportRecord{
+		{Hostname: "laptop", Owner: "alice", Description: "test"},
+		{Hostname: "router", Owner: "bob", Description: "test", Networks: []string{"192.168.1.0/24"}},
+	}
+
+	peers, err := ImportPeers(conf, records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(peers) != 2 || len(conf.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d (config %d)", len(peers), len(conf.Peers))
+	}
+	if peers[0].IP.Equal(peers[1].IP) {
+		t.Fatal("imported peers should be allocated distinct IPs")
+	}
+	if len(conf.Peers[1].Networks) != 1 {
+		t.Fatal("expected routed network on second peer")
+	}
+}
+
+func TestImportPeersDuplicateHostname(t *testing.T) {
+	conf := testDsnetConfig(t)
+	records := []ImportRecord{
+		{Hostname: "laptop", Owner: "alice", Description: "test"},
+		{Hostname: "laptop", Owner: "bob", Description: "test"},
+	}
+
+	if _, err := ImportPeers(conf, records); err == nil {
+		t.Fatal("expected error for duplicate hostname")
+	}
+}
+
+func TestImportPeersInvalidNetwork(t *testing.T) {
+	conf := testDsnetConfig(t)
+	records := []ImportRecord{
+		{Hostname: "router", Owner: "bob", Description: "test", Networks: []string{"not-a-cidr"}},
+	}
+
+	if _, err := ImportPeers(conf, records); err == nil {
+		t.Fatal("expected error for invalid network")
+	}
+}
+
+func TestImportPeersUnsafeHostname(t *testing.T) {
+	conf := testDsnetConfig(t)
+	records := []ImportRecord{
+		{Hostname: "../etc/passwd", Owner: "mallory", Description: "test"},
+	}
+
+	if _, err := ImportPeers(conf, records); err == nil {
+		t.Fatal("expected error for hostname containing a path separator")
+	}
+}
+
+func TestWritePeerConfigs(t *testing.T) {
+	viper.Set("output", "wg-quick")
+	t.Cleanup(func() { viper.Set("output", "") })
+	conf := testDsnetConfig(t)
+	peers, err := ImportPeers(conf, []ImportRecord{
+		{Hostname: "laptop", Owner: "alice", Description: "test"},
+		{Hostname: "phone", Owner: "alice", Description: "test"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "configs")
+	written, err := WritePeerConfigs(dir, peers, GetServer(conf))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(written) != 2 || written[0] != filepath.Join(dir, "laptop.conf") {
+		t.Fatalf("expected the written paths, got %v", written)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "phone.conf"))
+	if err != nil {
+		t.Fatalf("expected phone.conf to be written: %v", err)
+	}
+	if !strings.Contains(string(b), "[Interface]") {
+		t.Fatal("expected wg-quick config")
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "laptop.conf"))
+	if err != nil {
+		t.Fatalf("expected laptop.conf to be written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected 0600 permissions, got %o", info.Mode().Perm())
+	}
+
+	if _, err := WritePeerConfigs(dir, peers, GetServer(conf)); err == nil {
+		t.Fatal("expected error when configs already exist")
+	}
+}
+
+func TestWritePeerConfigsExclusive(t *testing.T) {
+	viper.Set("output", "wg-quick")
+	t.Cleanup(func() { viper.Set("output", "") })
+	conf := testDsnetConfig(t)
+	peers, err := ImportPeers(conf, []ImportRecord{
+		{Hostname: "laptop", Owner: "alice", Description: "test"},
+		{Hostname: "phone", Owner: "alice", Description: "test"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the second phone.conf appears after the check, as if written by
+	// something else meanwhile
+	dir := t.TempDir()
+	if _, err = WritePeerConfigs(dir, append(peers, peers[1]), GetServer(conf)); err == nil {
+		t.Fatal("expected an existing file to be refused")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected the configs written to be removed, got %d files", len(entries))
+	}
+}
+
+func TestDiscardPeerConfigs(t *testing.T) {
+	viper.Set("output", "wg-quick")
+	t.Cleanup(func() { viper.Set("output", "") })
+	conf := testDsnetConfig(t)
+	peers, err := ImportPeers(conf, []ImportRecord{{Hostname: "laptop", Owner: "alice", Description: "test"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	written, err := WritePeerConfigs(dir, peers, GetServer(conf))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// saved but not committed, so the configs match the config file
+	discardPeerConfigs(written, &notCommittedError{errors.New("git failed")})
+	if _, err = os.Stat(written[0]); err != nil {
+		t.Fatal("expected the config to be kept when the config file was saved")
+	}
+
+	discardPeerConfigs(written, errors.New("disk full"))
+	if _, err = os.Stat(written[0]); !os.IsNotExist(err) {
+		t.Fatal("expected the config to be removed when the config file was not saved")
+	}
+}
@@ -27,6 +27,21 @@ func Init() error {
 		return fmt.Errorf("Refusing to overwrite existing %s", configFile)
 	}
 
+	// other instances, to keep clear of
+	others := make(map[string]*DsnetConfig)
+	instance := viper.GetString("instance")
+	if instance != "" {
+		if err = os.MkdirAll(viper.GetString("config_dir"), 0755); err != nil {
+			return err
+		}
+		if others, err = ReadInstances(); err != nil {
+			return err
+		}
+		if interfaceName == "dsnet" {
+			interfaceName = instanceInterfaceName(instance)
+		}
+	}
+
 	privateKey, err := lib.GenerateJSONPrivateKey()
 	if err != nil {
 		return fmt.Errorf("%w - failed to generate private key", err)
@@ -43,6 +58,7 @@ func Init() error {
 	}
 
 	conf := &DsnetConfig{
+		Version:             ConfigVersion,
 		PrivateKey:          privateKey,
 		ListenPort:          listenPort,
 		Network:             getPrivateNet(),
@@ -57,6 +73,14 @@ func Init() error {
 		MTU:                 MTU,
 	}
 
+	avoidInstanceConflicts(conf, others)
+	if instance != "" {
+		others[instance] = conf
+		if problems := InstanceConflicts(others); len(problems) > 0 {
+			return fmt.Errorf("%s - choose another with DSNET_INTERFACE_NAME", problems[0])
+		}
+	}
+
 	server := GetServer(conf)
 
 	ipv4, err := server.AllocateIP()
@@ -76,7 +100,7 @@ func Init() error {
 		return fmt.Errorf("Could not determine any external IP, v4 or v6")
 	}
 
-	if err := conf.Save(); err != nil {
+	if err := conf.SaveChange("Initialise config"); err != nil {
 		return fmt.Errorf("%w - failed to save config file", err)
 	}
 
@@ -84,6 +108,49 @@ func Init() error {
 	return nil
 }
 
+// instanceInterfaceName derives an interface name from an instance name,
+// within the 15 characters Linux allows
+func instanceInterfaceName(instance string) string {
+	name := "dsnet-" + instance
+	if len(name) > 15 {
+		name = name[:15]
+	}
+	return name
+}
+
+// avoidInstanceConflicts moves the ListenPort and random networks of a new
+// instance clear of those of the other instances
+func avoidInstanceConflicts(conf *DsnetConfig, others map[string]*DsnetConfig) {
+	conflicts := func(field func(*DsnetConfig) lib.JSONIPNet) bool {
+		for _, other := range others {
+			if len(field(other).IPNet.IP) > 0 && networksOverlap(field(conf), field(other)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for portUsed := true; portUsed; {
+		portUsed = false
+		for _, other := range others {
+			if other.ListenPort == conf.ListenPort {
+				conf.ListenPort++
+				portUsed = true
+			}
+		}
+	}
+
+	network := func(c *DsnetConfig) lib.JSONIPNet { return c.Network }
+	for i := 0; i < 100 && conflicts(network); i++ {
+		conf.Network = getPrivateNet()
+	}
+
+	network6 := func(c *DsnetConfig) lib.JSONIPNet { return c.Network6 }
+	for i := 0; i < 100 && conflicts(network6); i++ {
+		conf.Network6 = getULANet()
+	}
+}
+
 // get a random IPv4  /22 subnet on 10.0.0.0 (1023 hosts) (or /24?)
 func getPrivateNet() lib.JSONIPNet {
 	rbs := make([]byte, 2)
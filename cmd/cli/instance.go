@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// instanceExtensions are the config file extensions of instances, in order of
+// preference if there is more than one
+var instanceExtensions = []string{".json", ".yml", ".yaml", ".toml"}
+
+var validInstanceName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// InstanceConfigFile returns the config file of the named instance in
+// config_dir, in whichever format it exists, or name.json if it does not
+// exist yet
+func InstanceConfigFile(name string) (string, error) {
+	if !validInstanceName.MatchString(name) {
+		return "", fmt.Errorf("invalid instance name %q, use letters, digits, - and _", name)
+	}
+
+	configDir := viper.GetString("config_dir")
+	for _, ext := range instanceExtensions {
+		configFile := filepath.Join(configDir, name+ext)
+		if _, err := os.Stat(configFile); err == nil {
+			return configFile, nil
+		}
+	}
+
+	return filepath.Join(configDir, name+".json"), nil
+}
+
+// UseInstance points config_file at the named instance, so that everything
+// loading the config operates on that instance
+func UseInstance(name string) error {
+	configFile, err := InstanceConfigFile(name)
+	if err != nil {
+		return err
+	}
+
+	viper.Set("instance", name)
+	viper.Set("config_file", configFile)
+	return nil
+}
+
+// Instances lists the names of the instances in config_dir, sorted. Files
+// that are not configs, such as a README, are ignored.
+func Instances() ([]string, error) {
+	configDir := viper.GetString("config_dir")
+	entries, err := ioutil.ReadDir(configDir)
+	if err != nil {
+		return nil, fmt.Errorf("%w - failed to list instances", err)
+	}
+
+	seen := make(map[string]bool)
+	instances := make([]string, 0)
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+
+		if entry.IsDir() || !validInstanceName.MatchString(name) {
+			continue
+		}
+		if _, err := configFormat(entry.Name()); err != nil || ext == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("instance %s has more than one config file in %s", name, configDir)
+		}
+
+		seen[name] = true
+		instances = append(instances, name)
+	}
+
+	sort.Strings(instances)
+	return instances, nil
+}
+
+// ReadInstances reads the config of every instance in config_dir, by name
+func ReadInstances() (map[string]*DsnetConfig, error) {
+	instances, err := Instances()
+	if err != nil {
+		return nil, err
+	}
+
+	confs := make(map[string]*DsnetConfig)
+	for _, name := range instances {
+		configFile, err := InstanceConfigFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if confs[name], err = ReadConfigFile(configFile); err != nil {
+			return nil, fmt.Errorf("%w - instance %s", err, name)
+		}
+	}
+	return confs, nil
+}
+
+// InstanceConflicts reports settings that instances cannot share on one
+// host: ListenPort, InterfaceName and overlapping networks. Each conflict is
+// reported once, against the later instance by name.
+func InstanceConflicts(confs map[string]*DsnetConfig) []ConfigProblem {
+	problems := make([]ConfigProblem, 0)
+
+	names := make([]string, 0, len(confs))
+	for name := range confs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		conf := confs[name]
+		for _, other := range names[:i] {
+			otherConf := confs[other]
+			add := func(field, format string, a ...interface{}) {
+				problems = append(problems, ConfigProblem{
+					Path:    name + " $." + field,
+					Message: fmt.Sprintf(format, a...) + " of instance " + other,
+				})
+			}
+
+			if conf.ListenPort == otherConf.ListenPort {
+				add("ListenPort", "%d is also the ListenPort", conf.ListenPort)
+			}
+			if conf.InterfaceName == otherConf.InterfaceName {
+				add("InterfaceName", "%s is also the InterfaceName", conf.InterfaceName)
+			}
+			if len(conf.Network.IPNet.IP) > 0 && len(otherConf.Network.IPNet.IP) > 0 && networksOverlap(conf.Network, otherConf.Network) {
+				add("Network", "%s overlaps the Network %s", conf.Network.IPNet.String(), otherConf.Network.IPNet.String())
+			}
+			if len(conf.Network6.IPNet.IP) > 0 && len(otherConf.Network6.IPNet.IP) > 0 && networksOverlap(conf.Network6, otherConf.Network6) {
+				add("Network6", "%s overlaps the Network6 %s", conf.Network6.IPNet.String(), otherConf.Network6.IPNet.String())
+			}
+		}
+	}
+
+	return problems
+}
+
+// CheckInstanceConflicts fails if the instances in config_dir conflict. It
+// does nothing unless an instance is in use, i.e. with --instance or --all.
+func CheckInstanceConflicts() error {
+	if viper.GetString("instance") == "" {
+		return nil
+	}
+	return checkInstanceConflicts()
+}
+
+func checkInstanceConflicts() error {
+	confs, err := ReadInstances()
+	if err != nil {
+		return err
+	}
+
+	problems := InstanceConflicts(confs)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	strs := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		strs = append(strs, "  "+problem.String())
+	}
+	return fmt.Errorf("instances in %s conflict:\n%s", viper.GetString("config_dir"), strings.Join(strs, "\n"))
+}
+
+// ForEachInstance runs fn with each instance of config_dir in use in turn,
+// carrying on if it fails for one. The errors are returned together.
+func ForEachInstance(fn func(name string) error) error {
+	// as before afterwards, e.g. for the next round of sync --interval
+	defer func(instance, configFile string) {
+		viper.Set("instance", instance)
+		viper.Set("config_file", configFile)
+	}(viper.GetString("instance"), viper.GetString("config_file"))
+
+	instances, err := Instances()
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances in %s. `dsnet --instance <name> init` creates one", viper.GetString("config_dir"))
+	}
+
+	failed := make([]string, 0)
+	code := 0
+	for _, name := range instances {
+		if err := UseInstance(name); err != nil {
+			return err
+		}
+		if err := fn(name); err != nil {
+			fmt.Fprintf(os.Stderr, "\033[31m%s: %s\033[0m\n", name, err)
+			failed = append(failed, name)
+
+			// keep exit codes such as ExitDrift, if all agree
+			var exitErr *ExitError
+			if !errors.As(err, &exitErr) {
+				code = 1
+			} else if code == 0 {
+				code = exitErr.Code
+			} else if code != exitErr.Code {
+				code = 1
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ExitError{Code: code, Err: errors.New("failed for instances " + strings.Join(failed, ", "))}
+	}
+	return nil
+}
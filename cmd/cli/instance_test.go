@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+// useConfigDir points config_dir at a new temporary directory
+func useConfigDir(t *testing.T) string {
+	t.Helper()
+	configDir := t.TempDir()
+	for _, key := range []string{"config_dir", "config_file", "instance"} {
+		old := viper.GetString(key)
+		t.Cleanup(func() { viper.Set(key, old) })
+	}
+	viper.Set("config_dir", configDir)
+	return configDir
+}
+
+// saveInstance saves conf as the named instance
+func saveInstance(t *testing.T, name string, conf *DsnetConfig) {
+	t.Helper()
+	if err := UseInstance(name); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conf.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	viper.Set("instance", "")
+}
+
+func TestInstances(t *testing.T) {
+	configDir := useConfigDir(t)
+
+	saveInstance(t, "staff", testDsnetConfig(t))
+	if err := ioutil.WriteFile(filepath.Join(configDir, "iot.yml"), []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// not instances
+	for _, name := range []string{"README", "notes.txt", ".hidden.json"} {
+		if err := ioutil.WriteFile(filepath.Join(configDir, name), []byte{}, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(configDir, "backup.json"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := Instances()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(instances, []string{"iot", "staff"}) {
+		t.Fatalf("expected iot and staff, got %v", instances)
+	}
+
+	if configFile, _ := InstanceConfigFile("iot"); configFile != filepath.Join(configDir, "iot.yml") {
+		t.Fatalf("expected the existing YAML config, got %s", configFile)
+	}
+	if configFile, _ := InstanceConfigFile("customers"); configFile != filepath.Join(configDir, "customers.json") {
+		t.Fatalf("expected a JSON config for a new instance, got %s", configFile)
+	}
+	if _, err = InstanceConfigFile("../staff"); err == nil {
+		t.Fatal("expected an invalid instance name to be rejected")
+	}
+
+	// the same instance twice is ambiguous
+	if err = ioutil.WriteFile(filepath.Join(configDir, "staff.toml"), []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Instances(); err == nil {
+		t.Fatal("expected an error for two configs of one instance")
+	}
+}
+
+func TestInstanceConflicts(t *testing.T) {
+	staff := testDsnetConfig(t)
+
+	iot := testDsnetConfig(t)
+	iot.InterfaceName = "dsnet-iot"
+	iot.ListenPort = 51821
+	_, network, _ := net.ParseCIDR("10.1.0.0/24")
+	iot.Network = lib.JSONIPNet{IPNet: *network}
+	_, network6, _ := net.ParseCIDR("fd01::/64")
+	iot.Network6 = lib.JSONIPNet{IPNet: *network6}
+
+	confs := map[string]*DsnetConfig{"staff": staff, "iot": iot}
+	if problems := InstanceConflicts(confs); len(problems) != 0 {
+		t.Fatalf("expected no conflicts, got %v", problems)
+	}
+
+	customers := testDsnetConfig(t)
+	customers.InterfaceName = "dsnet-iot"
+	customers.ListenPort = 51822
+	_, network, _ = net.ParseCIDR("10.0.0.0/16")
+	customers.Network = lib.JSONIPNet{IPNet: *network}
+	customers.Network6 = iot.Network6
+	confs["customers"] = customers
+
+	expected := []string{"iot $.InterfaceName", "iot $.Network6", "staff $.Network"}
+	if paths := problemPaths(InstanceConflicts(confs)); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestCheckInstanceConflicts(t *testing.T) {
+	useConfigDir(t)
+	saveInstance(t, "staff", testDsnetConfig(t))
+	saveInstance(t, "iot", testDsnetConfig(t))
+
+	// only checked when using instances
+	if err := CheckInstanceConflicts(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := UseInstance("iot"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := CheckInstanceConflicts(); err == nil {
+		t.Fatal("expected the identical instances to conflict")
+	}
+}
+
+func TestForEachInstance(t *testing.T) {
+	configDir := useConfigDir(t)
+
+	if err := ForEachInstance(func(string) error { return nil }); err == nil {
+		t.Fatal("expected an error without instances")
+	}
+
+	saveInstance(t, "staff", testDsnetConfig(t))
+	saveInstance(t, "iot", testDsnetConfig(t))
+	viper.Set("config_file", "/etc/dsnetconfig.json")
+
+	visited := make([]string, 0)
+	err := ForEachInstance(func(name string) error {
+		visited = append(visited, name)
+		if viper.GetString("config_file") != filepath.Join(configDir, name+".json") {
+			t.Fatalf("expected the config of %s in use, got %s", name, viper.GetString("config_file"))
+		}
+		return &ExitError{Code: ExitDrift, Err: errors.New("drift")}
+	})
+
+	if !reflect.DeepEqual(visited, []string{"iot", "staff"}) {
+		t.Fatalf("expected every instance to be visited, got %v", visited)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != ExitDrift {
+		t.Fatalf("expected the drift exit code to be kept, got %v", err)
+	}
+	if viper.GetString("config_file") != "/etc/dsnetconfig.json" || viper.GetString("instance") != "" {
+		t.Fatal("expected config_file and instance to be restored")
+	}
+}
+
+func TestAvoidInstanceConflicts(t *testing.T) {
+	staff := testDsnetConfig(t)
+	iot := testDsnetConfig(t)
+	iot.ListenPort = 51821
+	others := map[string]*DsnetConfig{"staff": staff, "iot": iot}
+
+	conf := testDsnetConfig(t)
+	conf.InterfaceName = instanceInterfaceName("customers")
+	avoidInstanceConflicts(conf, others)
+
+	others["customers"] = conf
+	if problems := InstanceConflicts(others); !reflect.DeepEqual(problemPaths(problems), []string{"staff $.InterfaceName", "staff $.Network", "staff $.Network6"}) {
+		t.Fatalf("expected only the conflicts between staff and iot, got %v", problems)
+	}
+	if conf.ListenPort != 51822 {
+		t.Fatalf("expected the next free port, got %d", conf.ListenPort)
+	}
+}
+
+func TestInstanceInterfaceName(t *testing.T) {
+	if name := instanceInterfaceName("iot"); name != "dsnet-iot" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if name := instanceInterfaceName("customers-europe"); len(name) != 15 {
+		t.Fatalf("expected the name to be truncated to 15 characters, got %s", name)
+	}
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/naggie/dsnet/lib"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ensureMeshKey generates the MeshKey if mesh mode has been enabled without
+// one
+func (conf *DsnetConfig) ensureMeshKey() error {
+	if !conf.Mesh || conf.MeshKey != nil {
+		return nil
+	}
+
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		return err
+	}
+	conf.MeshKey = &lib.JSONKey{Key: key}
+	return nil
+}
+
+// meshPartners returns the hostnames of the peers that link directly to peer
+// in mesh mode
+func meshPartners(server *lib.Server, peer lib.Peer) []string {
+	hostnames := make([]string, 0)
+	for _, meshPeer := range server.GetMeshPeers(peer) {
+		hostnames = append(hostnames, meshPeer.Peer.Hostname)
+	}
+	return hostnames
+}
+
+// meshPartnersOf returns the hostnames of the peers that link directly to the
+// peer called hostname in mesh mode, none if there is no such peer
+func meshPartnersOf(conf *DsnetConfig, hostname string) []string {
+	server := GetServer(conf)
+	for _, peer := range server.Peers {
+		if peer.Hostname == hostname {
+			return meshPartners(server, peer)
+		}
+	}
+	return []string{}
+}
+
+// printMeshNotice tells the user which other peers must have their configs
+// updated after hostname has joined, changed or been removed
+func printMeshNotice(hostname string, partners []string) {
+	if len(partners) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Mesh: the configs of %s link directly to %s and must be updated too. %s\n", strings.Join(partners, ", "), hostname, privateKeyNotice)
+}
+
+func appendUnique(strs []string, more ...string) []string {
+	for _, s := range more {
+		found := false
+		for _, existing := range strs {
+			if existing == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
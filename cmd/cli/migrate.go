@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigVersion is the version of the config schema written by this build.
+// Bump it with each new entry in configMigrations.
+const ConfigVersion = 2
+
+// configMigrations upgrade a config, as generic JSON, from version i to i+1.
+// Configs without a Version are version 0.
+var configMigrations = []func(conf map[string]interface{}) error{
+	// 0 -> 1: PersistentKeepalive and MTU were added with these defaults
+	func(conf map[string]interface{}) error {
+		setDefault(conf, "PersistentKeepalive", json.Number("25"))
+		setDefault(conf, "MTU", json.Number("1420"))
+		return nil
+	},
+	// 1 -> 2: PresharedKeyCreated was added; keys are as old as the peer
+	func(conf map[string]interface{}) error {
+		peers, _ := conf["Peers"].([]interface{})
+		for _, p := range peers {
+			peer, ok := p.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid peer %v", p)
+			}
+			created, _ := peer["PresharedKeyCreated"].(string)
+			if created == "" || created == "0001-01-01T00:00:00Z" {
+				peer["PresharedKeyCreated"] = peer["Added"]
+			}
+		}
+		return nil
+	},
+}
+
+func setDefault(conf map[string]interface{}, key string, value interface{}) {
+	if _, ok := conf[key]; !ok {
+		conf[key] = value
+	}
+}
+
+// migrateConfig upgrades a JSON config to ConfigVersion, returning the
+// upgraded config and the version it had. Configs from a newer version of
+// dsnet are refused rather than guessed at.
+func migrateConfig(raw []byte) ([]byte, int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var conf map[string]interface{}
+	if err := decoder.Decode(&conf); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if v, ok := conf["Version"]; ok {
+		number, ok := v.(json.Number)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid config Version %v", v)
+		}
+		n, err := number.Int64()
+		if err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("invalid config Version %v", v)
+		}
+		version = int(n)
+	}
+
+	if version > ConfigVersion {
+		return nil, version, fmt.Errorf("config is version %d but this dsnet only supports up to version %d. Upgrade dsnet", version, ConfigVersion)
+	}
+
+	if version == ConfigVersion {
+		return raw, version, nil
+	}
+
+	for v := version; v < ConfigVersion; v++ {
+		if err := configMigrations[v](conf); err != nil {
+			return nil, version, fmt.Errorf("%w - failed to migrate config from version %d to %d", err, v, v+1)
+		}
+	}
+	conf["Version"] = ConfigVersion
+
+	migrated, err := json.Marshal(conf)
+	return migrated, version, err
+}
+
+// Migrate upgrades the config file to the current schema version. With
+// dryRun, the changes are printed as a diff instead.
+func Migrate(dryRun bool) error {
+	configFile := viper.GetString("config_file")
+
+	original, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	format, err := configFormat(configFile)
+	if err != nil {
+		return err
+	}
+
+	migrated, err := conf.Marshal(format)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(original, migrated) {
+		fmt.Fprintf(os.Stderr, "%s is up to date (version %d)\n", configFile, ConfigVersion)
+		return nil
+	}
+
+	if dryRun {
+		fmt.Print(lineDiff(string(original), string(migrated)))
+		return nil
+	}
+
+	if err = conf.SaveChange(fmt.Sprintf("Migrate config to version %d", ConfigVersion)); err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s migrated to version %d\n", configFile, ConfigVersion)
+	return nil
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigMigrationsMatchVersion(t *testing.T) {
+	if len(configMigrations) != ConfigVersion {
+		t.Fatalf("ConfigVersion is %d but there are %d migrations", ConfigVersion, len(configMigrations))
+	}
+}
+
+func TestMigrateConfigFromVersion0(t *testing.T) {
+	raw := `{"ListenPort": 51820, "Peers": [{"Hostname": "a", "Added": "2020-05-07T10:04:46Z"}]}`
+
+	migrated, version, err := migrateConfig([]byte(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != 0 {
+		t.Fatalf("expected original version 0, got %d", version)
+	}
+
+	var conf map[string]interface{}
+	if err := json.Unmarshal(migrated, &conf); err != nil {
+		t.Fatalf("invalid migrated JSON: %v", err)
+	}
+	if conf["Version"] != float64(ConfigVersion) {
+		t.Fatalf("expected Version %d, got %v", ConfigVersion, conf["Version"])
+	}
+	if conf["PersistentKeepalive"] != float64(25) || conf["MTU"] != float64(1420) {
+		t.Fatalf("expected defaults, got %v/%v", conf["PersistentKeepalive"], conf["MTU"])
+	}
+	if conf["ListenPort"] != float64(51820) {
+		t.Fatal("existing values should be kept")
+	}
+	peer := conf["Peers"].([]interface{})[0].(map[string]interface{})
+	if peer["PresharedKeyCreated"] != "2020-05-07T10:04:46Z" {
+		t.Fatalf("expected PresharedKeyCreated from Added, got %v", peer["PresharedKeyCreated"])
+	}
+}
+
+func TestMigrateConfigKeepsExplicitZero(t *testing.T) {
+	migrated, _, err := migrateConfig([]byte(`{"PersistentKeepalive": 0}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(migrated), `"PersistentKeepalive":0`) {
+		t.Fatalf("explicit zero should be kept, got %s", migrated)
+	}
+}
+
+func TestMigrateConfigCurrentUnchanged(t *testing.T) {
+	raw := []byte(`{"Version": 2, "MTU": 1280}`)
+	migrated, _, err := migrateConfig(raw)
+	if err != nil || string(migrated) != string(raw) {
+		t.Fatalf("current config should be unchanged, got %s, %v", migrated, err)
+	}
+}
+
+func TestMigrateConfigRefusesNewer(t *testing.T) {
+	_, version, err := migrateConfig([]byte(`{"Version": 99}`))
+	if err == nil || !strings.Contains(err.Error(), "Upgrade dsnet") {
+		t.Fatalf("expected error for newer config, got %v", err)
+	}
+	if version != 99 {
+		t.Fatalf("expected version 99, got %d", version)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+
+	conf := testDsnetConfig(t)
+	writeTestConfig(t, configPath, conf)
+	before, _ := os.ReadFile(configPath)
+
+	if err := Migrate(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, _ := os.ReadFile(configPath)
+	if string(before) != string(after) {
+		t.Fatal("dry run should not change the config")
+	}
+
+	if err := Migrate(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if loaded.Version != ConfigVersion {
+		t.Fatalf("expected version %d, got %d", ConfigVersion, loaded.Version)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n"
+	b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n"
+
+	expected := "" +
+		" 3\n" +
+		" 4\n" +
+		"-5\n" +
+		"+five\n" +
+		" 6\n" +
+		" 7\n" +
+		" 8\n" +
+		"+9\n"
+	if diff := lineDiff(a, b); diff != expected {
+		t.Fatalf("unexpected diff:\n%s", diff)
+	}
+
+	if lineDiff(a, a) != "" {
+		t.Fatal("identical input should have an empty diff")
+	}
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// privateKeyNotice follows configs rendered for existing peers, whose
+// private keys dsnet does not have
+const privateKeyNotice = "Peer private keys are not stored, so replace " + lib.PrivateKeyPlaceholder + " with the PrivateKey of the existing config."
+
+// PeerOutput is the machine-readable form of a new or regenerated peer,
+// written to stdout instead of the bare config when --json is given
+type PeerOutput struct {
+	Hostname    string
+	Owner       string
+	Description string
+	IP          net.IP
+	IP6         net.IP
+	PublicKey   lib.JSONKey
+	// omitted if the peer supplied only its public key
+	PrivateKey   *lib.JSONKey `json:",omitempty"`
+	PresharedKey lib.JSONKey
+	// server endpoint, host:port
+	Endpoint string
+	// networks the peer should route via the server
+	AllowedIPs []lib.JSONIPNet
+	// format of Config, see --output
+	Output string
+	Config string
+}
+
+// GetPeerOutput renders the peer config in the requested output format and
+// collects the fields automation is likely to want separately
+func GetPeerOutput(peer lib.Peer, server *lib.Server, peerType string) (PeerOutput, error) {
+	peerConfigBytes, err := lib.AsciiPeerConfig(peer, peerType, *server)
+	if err != nil {
+		return PeerOutput{}, fmt.Errorf("%w - failed to get peer configuration", err)
+	}
+
+	host, err := server.GetEndpointHost()
+	if err != nil {
+		return PeerOutput{}, err
+	}
+
+	output := PeerOutput{
+		Hostname:     peer.Hostname,
+		Owner:        peer.Owner,
+		Description:  peer.Description,
+		IP:           peer.IP,
+		IP6:          peer.IP6,
+		PublicKey:    peer.PublicKey,
+		PresharedKey: peer.PresharedKey,
+		Endpoint:     net.JoinHostPort(host, strconv.Itoa(server.ListenPort)),
+		AllowedIPs:   server.GetClientAllowedIPs(),
+		Output:       peerType,
+		Config:       peerConfigBytes.String(),
+	}
+
+	if peer.PrivateKey.Key != (wgtypes.Key{}) {
+		privateKey := peer.PrivateKey
+		output.PrivateKey = &privateKey
+	}
+
+	return output, nil
+}
+
+// PrintPeerConfig writes the peer config to stdout in the format selected by
+// --output, or as a PeerOutput JSON object if asJSON is set
+func PrintPeerConfig(peer lib.Peer, server *lib.Server, asJSON bool) error {
+	peerType := viper.GetString("output")
+
+	if !asJSON {
+		peerConfigBytes, err := lib.AsciiPeerConfig(peer, peerType, *server)
+		if err != nil {
+			return fmt.Errorf("%w - failed to get peer configuration", err)
+		}
+		os.Stdout.Write(peerConfigBytes.Bytes())
+		return nil
+	}
+
+	output, err := GetPeerOutput(peer, server, peerType)
+	if err != nil {
+		return err
+	}
+
+	_json, _ := json.MarshalIndent(output, "", "    ")
+	_json = append(_json, '\n')
+	os.Stdout.Write(_json)
+	return nil
+}
+
+// checkFileSafeHostname ensures a hostname can be used as a file name when
+// writing peer configs to a directory
+func checkFileSafeHostname(hostname string) error {
+	if hostname == "." || hostname == ".." || strings.ContainsAny(hostname, `/\`) {
+		return fmt.Errorf("hostname %q cannot be used as a file name", hostname)
+	}
+	return nil
+}
+
+// peerConfigExtensions maps output types to the file extension used when
+// writing peer configs to a directory
+var peerConfigExtensions = map[string]string{
+	"wg-quick":   ".conf",
+	"vyatta":     ".vyatta",
+	"nixos":      ".nix",
+	"routeros":   ".rsc",
+	"k8s-secret": ".yaml",
+	"compose":    ".yaml",
+}
+
+// WritePeerConfigs renders each peer's config in the format selected by
+// --output to <dir>/<hostname><ext>, returning the paths written. Existing
+// files are never overwritten; if any config cannot be written, those already
+// written are removed.
+func WritePeerConfigs(dir string, peers []lib.Peer, server *lib.Server) ([]string, error) {
+	peerType := viper.GetString("output")
+	ext, ok := peerConfigExtensions[peerType]
+	if !ok {
+		return nil, errors.New("unrecognised OUTPUT type")
+	}
+
+	// render and check everything before touching the filesystem
+	paths := make([]string, 0, len(peers))
+	configs := make([][]byte, 0, len(peers))
+	for _, peer := range peers {
+		if err := checkFileSafeHostname(peer.Hostname); err != nil {
+			return nil, err
+		}
+
+		path := filepath.Join(dir, peer.Hostname+ext)
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("refusing to overwrite existing %s", path)
+		}
+
+		peerConfigBytes, err := lib.AsciiPeerConfig(peer, peerType, *server)
+		if err != nil {
+			return nil, fmt.Errorf("%w - failed to get peer configuration for %s", err, peer.Hostname)
+		}
+
+		paths = append(paths, path)
+		configs = append(configs, peerConfigBytes.Bytes())
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	for i, path := range paths {
+		if err := writeNewFile(path, configs[i]); err != nil {
+			removePeerConfigs(paths[:i])
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// writeNewFile writes data to path, which must not exist, even if it was
+// created since it was checked
+func writeNewFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+func removePeerConfigs(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// discardPeerConfigs removes the configs written by WritePeerConfigs for a
+// change that SaveChange then failed to save, as the keys in them are not in
+// the config. They are kept if the config was saved but not committed.
+func discardPeerConfigs(paths []string, saveErr error) {
+	var notCommitted *notCommittedError
+	if saveErr == nil || errors.As(saveErr, &notCommitted) {
+		return
+	}
+	removePeerConfigs(paths)
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/naggie/dsnet/lib"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestGetPeerOutput(t *testing.T) {
+	conf := testDsnetConfig(t)
+	server := GetServer(conf)
+	peer := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
+
+	output, err := GetPeerOutput(peer, server, "wg-quick")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if output.Hostname != "laptop" || output.Owner != "alice" {
+		t.Fatalf("unexpected hostname/owner: %s/%s", output.Hostname, output.Owner)
+	}
+	if output.Endpoint != "vpn.example.com:51820" {
+		t.Fatalf("expected endpoint vpn.example.com:51820, got %s", output.Endpoint)
+	}
+	if output.PrivateKey == nil || output.PrivateKey.Key != peer.PrivateKey.Key {
+		t.Fatal("expected private key to be included")
+	}
+	if output.PresharedKey.Key != peer.PresharedKey.Key {
+		t.Fatal("expected preshared key to be included")
+	}
+	if len(output.AllowedIPs) != 2 {
+		t.Fatalf("expected 2 allowed IPs, got %d", len(output.AllowedIPs))
+	}
+	if !strings.Contains(output.Config, "[Interface]") {
+		t.Fatal("expected rendered wg-quick config")
+	}
+}
+
+func TestGetPeerOutputPublicKeyOnly(t *testing.T) {
+	conf := testDsnetConfig(t)
+	server := GetServer(conf)
+	peer := testLibPeer(t, "phone", "bob", net.IP{10, 0, 0, 3})
+	peer.PrivateKey = lib.JSONKey{Key: wgtypes.Key{}}
+
+	output, err := GetPeerOutput(peer, server, "wg-quick")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output.PrivateKey != nil {
+		t.Fatal("private key should be omitted when not known")
+	}
+
+	b, err := json.Marshal(output)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	if strings.Contains(string(b), "\"PrivateKey\"") {
+		t.Fatal("PrivateKey field should be omitted from JSON")
+	}
+}
+
+func TestGetPeerOutputIPv6Endpoint(t *testing.T) {
+	conf := testDsnetConfig(t)
+	conf.ExternalHostname = ""
+	conf.ExternalIP6 = net.ParseIP("2001:db8::1")
+	server := GetServer(conf)
+	peer := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
+
+	output, err := GetPeerOutput(peer, server, "wg-quick")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output.Endpoint != "[2001:db8::1]:51820" {
+		t.Fatalf("expected bracketed IPv6 endpoint, got %s", output.Endpoint)
+	}
+}
+
+func TestGetPeerOutputInvalidType(t *testing.T) {
+	conf := testDsnetConfig(t)
+	server := GetServer(conf)
+	peer := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
+
+	if _, err := GetPeerOutput(peer, server, "invalid"); err == nil {
+		t.Fatal("expected error for invalid output type")
+	}
+}
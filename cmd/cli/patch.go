@@ -1,18 +1,416 @@
 package cli
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 
-func Patch(patch map[string]interface{}) error {
-	conf, err := LoadConfigFile()
+	"github.com/spf13/viper"
+)
+
+// Patch applies a JSON Merge Patch (RFC 7396, patchType "merge") or a JSON
+// Patch (RFC 6902, patchType "json") to the config. With dryRun, the
+// resulting changes are shown as a diff and validated, but not saved.
+func Patch(patch []byte, patchType string, dryRun bool) error {
+	conf, err := LoadConfigFileForUpdate()
 	if err != nil {
 		return fmt.Errorf("%w - failed to load config", err)
 	}
 
-	conf.Merge(patch)
+	patched, err := conf.ApplyPatch(patch, patchType)
+	if err != nil {
+		return fmt.Errorf("%w - failed to apply patch", err)
+	}
+
+	if dryRun {
+		format, err := configFormat(viper.GetString("config_file"))
+		if err != nil {
+			return err
+		}
+
+		before, err := conf.Marshal(format)
+		if err != nil {
+			return err
+		}
+		after, err := patched.Marshal(format)
+		if err != nil {
+			return err
+		}
+
+		diff := lineDiff(maskSealed(before), maskSealed(after))
+		if diff == "" {
+			fmt.Fprintln(os.Stderr, "The patch makes no changes")
+		}
+		fmt.Print(diff)
 
-	if err = conf.Save(); err != nil {
+		if err = patched.Validate(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "The patched config is valid. Run without --dry-run to save it.")
+		return nil
+	}
+
+	if err = patched.SaveChange(fmt.Sprintf("Apply %s patch", patchType)); err != nil {
 		return fmt.Errorf("%w - failure to save config", err)
 	}
 
+	fmt.Fprintln(os.Stderr, "Config patched. Run dsnet sync to apply it to the interface.")
 	return nil
 }
+
+// ApplyPatch returns a copy of the config with a JSON Merge Patch (RFC 7396,
+// patchType "merge") or a JSON Patch (RFC 6902, patchType "json") applied.
+// Unknown keys and type mismatches in the result are errors. The result is
+// not validated.
+func (conf *DsnetConfig) ApplyPatch(patch []byte, patchType string) (*DsnetConfig, error) {
+	current, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeJSONValue(current)
+	if err != nil {
+		return nil, err
+	}
+
+	patchDoc, err := decodeJSONValue(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w - patch is not valid JSON", err)
+	}
+
+	switch patchType {
+	case "merge":
+		doc = mergePatch(doc, patchDoc)
+	case "json":
+		var operations []jsonPatchOperation
+		if err := json.Unmarshal(patch, &operations); err != nil {
+			return nil, fmt.Errorf("%w - a JSON patch must be an array of operations", err)
+		}
+		// values are taken from patchDoc to keep numbers as json.Number
+		values, _ := patchDoc.([]interface{})
+		for i, operation := range operations {
+			if object, ok := values[i].(map[string]interface{}); ok {
+				operation.Value = object["value"]
+			}
+			if doc, err = operation.apply(doc); err != nil {
+				return nil, fmt.Errorf("%w - operation %d (%s %s)", err, i, operation.Op, operation.Path)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown patch type %q, use merge or json", patchType)
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+
+	newConf := DsnetConfig{}
+	if err = decoder.Decode(&newConf); err != nil {
+		return nil, err
+	}
+
+	if err = newConf.resolvePrivateKey(); err != nil {
+		return nil, err
+	}
+
+	if err = newConf.ensureMeshKey(); err != nil {
+		return nil, err
+	}
+
+	return &newConf, nil
+}
+
+// sealedValue matches an encrypted secret, which is sealed with a new nonce
+// unless it was loaded from the config
+var sealedValue = regexp.MustCompile(`enc:v1:[A-Za-z0-9+/=]+:[A-Za-z0-9+/=]+`)
+
+// maskSealed hides encrypted secrets so that unchanged ones compare equal
+func maskSealed(raw []byte) string {
+	return sealedValue.ReplaceAllString(string(raw), "enc:v1:...")
+}
+
+// decodeJSONValue decodes JSON to generic values, keeping numbers exact
+func decodeJSONValue(raw []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return value, nil
+}
+
+// mergePatch applies an RFC 7396 JSON Merge Patch: objects are merged
+// recursively, null removes a key, and anything else replaces the target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+
+	return targetObject
+}
+
+// jsonPatchOperation is a single RFC 6902 JSON Patch operation
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+func (operation jsonPatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parseJSONPointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.Op {
+	case "add":
+		return jsonPointerAdd(doc, path, operation.Value)
+	case "remove":
+		return jsonPointerRemove(doc, path)
+	case "replace":
+		if doc, err = jsonPointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(doc, path, operation.Value)
+	case "move", "copy":
+		from, err := parseJSONPointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := jsonPointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if operation.Op == "move" {
+			if operation.Path != operation.From && strings.HasPrefix(operation.Path, operation.From+"/") {
+				return nil, errors.New("cannot move a value into itself")
+			}
+			if doc, err = jsonPointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			// copy, so that later operations on either do not affect both
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = decodeJSONValue(raw); err != nil {
+				return nil, err
+			}
+		}
+
+		return jsonPointerAdd(doc, path, value)
+	case "test":
+		value, err := jsonPointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(value, operation.Value) {
+			return nil, fmt.Errorf("test failed, value is %v", value)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", operation.Op)
+	}
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer into unescaped tokens
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q, must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token, which must be within 0..max
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("%s does not exist", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("cannot index %s into a value", token)
+		}
+	}
+	return doc, nil
+}
+
+// jsonPointerUpdate calls update with the container holding the last token
+// of path, replacing it with the result. Arrays change length, so each
+// container is set back into its parent.
+func jsonPointerUpdate(doc interface{}, path []string, update func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return update(doc, path[0])
+	}
+
+	child, err := jsonPointerGet(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = jsonPointerUpdate(child, path[1:], update)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		container[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(container)-1)
+		container[i] = child
+	}
+	return doc, nil
+}
+
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return jsonPointerUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := arrayIndex(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("cannot add %s to a value", token)
+		}
+	})
+}
+
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole config")
+	}
+
+	return jsonPointerUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[token]; !ok {
+				return nil, fmt.Errorf("%s does not exist", token)
+			}
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %s from a value", token)
+		}
+	})
+}
+
+// jsonEqual compares generic JSON values, treating numbers by value
+func jsonEqual(a, b interface{}) bool {
+	aNumber, aOK := a.(json.Number)
+	bNumber, bOK := b.(json.Number)
+	if aOK && bOK {
+		aFloat, aErr := aNumber.Float64()
+		bFloat, bErr := bNumber.Float64()
+		return aErr == nil && bErr == nil && aFloat == bFloat
+	}
+
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if other, ok := b[key]; !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPatchConfig(t *testing.T) *DsnetConfig {
+	t.Helper()
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if err := conf.AddPeer(testLibPeer(t, "phone", "bob", net.IP{10, 0, 0, 3})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	return conf
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	conf := testPatchConfig(t)
+
+	patched, err := conf.ApplyPatch([]byte(`{"ListenPort": 51821, "DNS": "10.0.0.53", "PostUp": null}`), "merge")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// previously ignored, as JSON numbers are float64
+	if patched.ListenPort != 51821 {
+		t.Fatalf("expected ListenPort 51821, got %d", patched.ListenPort)
+	}
+	if !patched.DNS.Equal(net.IP{10, 0, 0, 53}) {
+		t.Fatalf("expected DNS 10.0.0.53, got %s", patched.DNS)
+	}
+	if len(patched.Peers) != 2 || patched.Peers[1].PresharedKey != conf.Peers[1].PresharedKey {
+		t.Fatal("expected peers to be unchanged")
+	}
+	if conf.ListenPort != 51820 {
+		t.Fatal("expected the original config to be unchanged")
+	}
+}
+
+func TestApplyPatchGeneratesMeshKey(t *testing.T) {
+	conf := testPatchConfig(t)
+
+	patched, err := conf.ApplyPatch([]byte(`{"Mesh": true}`), "merge")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patched.MeshKey == nil || len(ValidateConfig(patched)) != 0 {
+		t.Fatal("expected enabling mesh mode to generate a MeshKey")
+	}
+
+	// and keep it, so that the pair preshared keys stay the same
+	again, err := patched.ApplyPatch([]byte(`{"ListenPort": 51821}`), "merge")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again.MeshKey == nil || again.MeshKey.Key != patched.MeshKey.Key {
+		t.Fatal("expected the MeshKey to be kept")
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	conf := testPatchConfig(t)
+
+	patch := `[
+		{"op": "test", "path": "/Peers/1/Hostname", "value": "phone"},
+		{"op": "replace", "path": "/Peers/1/Description", "value": "bob's phone"},
+		{"op": "add", "path": "/Peers/0/Networks/-", "value": "192.168.1.0/24"},
+		{"op": "copy", "from": "/Peers/1/Owner", "path": "/Peers/0/Owner"},
+		{"op": "remove", "path": "/PostUp"}
+	]`
+
+	patched, err := conf.ApplyPatch([]byte(patch), "json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if patched.Peers[1].Description != "bob's phone" {
+		t.Fatalf("expected description to be replaced, got %q", patched.Peers[1].Description)
+	}
+	if patched.Peers[0].Owner != "bob" {
+		t.Fatalf("expected owner to be copied, got %q", patched.Peers[0].Owner)
+	}
+	if len(patched.Peers[0].Networks) != 1 || patched.Peers[0].Networks[0].IPNet.String() != "192.168.1.0/24" {
+		t.Fatalf("expected a network to be appended, got %v", patched.Peers[0].Networks)
+	}
+}
+
+func TestApplyJSONPatchFailedTest(t *testing.T) {
+	conf := testPatchConfig(t)
+
+	patch := `[
+		{"op": "test", "path": "/Peers/1/Hostname", "value": "laptop"},
+		{"op": "remove", "path": "/Peers/1"}
+	]`
+
+	if _, err := conf.ApplyPatch([]byte(patch), "json"); err == nil || !strings.Contains(err.Error(), "operation 0") {
+		t.Fatalf("expected the test operation to fail, got %v", err)
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	conf := testPatchConfig(t)
+
+	for _, tc := range []struct {
+		name, patch, patchType string
+	}{
+		{"unknown key", `{"ListenPrt": 1}`, "merge"},
+		{"unknown peer key", `[{"op": "add", "path": "/Peers/0/Colour", "value": "red"}]`, "json"},
+		{"type mismatch", `{"ListenPort": "51821"}`, "merge"},
+		{"index out of range", `[{"op": "remove", "path": "/Peers/2"}]`, "json"},
+		{"missing key", `[{"op": "replace", "path": "/Nope", "value": 1}]`, "json"},
+		{"unknown op", `[{"op": "frobnicate", "path": "/Domain"}]`, "json"},
+		{"not an array", `{"op": "remove", "path": "/Domain"}`, "json"},
+		{"unknown type", `{}`, "strategic"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := conf.ApplyPatch([]byte(tc.patch), tc.patchType); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	tokens, err := parseJSONPointer("/a~1b/m~0n/0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"a/b", "m~n", "0"}; !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("expected %v, got %v", expected, tokens)
+	}
+
+	if _, err := parseJSONPointer("Peers"); err == nil {
+		t.Fatal("expected an error for a pointer without a leading /")
+	}
+}
+
+func TestPatchValidatesBeforeSave(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+	if err := testPatchConfig(t).Save(); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+
+	// duplicate IP
+	patch := `[{"op": "copy", "from": "/Peers/0/IP", "path": "/Peers/1/IP"}]`
+	if err := Patch([]byte(patch), "json", true); err == nil {
+		t.Fatal("expected a dry run to report the invalid result")
+	}
+	if err := Patch([]byte(patch), "json", false); err == nil {
+		t.Fatal("expected the invalid result not to be saved")
+	}
+
+	if err := Patch([]byte(`{"Domain": "example"}`), "merge", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if loaded.Domain != "example" {
+		t.Fatalf("expected Domain to be patched, got %q", loaded.Domain)
+	}
+}
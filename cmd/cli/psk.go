@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+// PresharedKeyAge returns how long the peer has used its current preshared key
+func (p PeerConfig) PresharedKeyAge(now time.Time) time.Duration {
+	if p.PresharedKeyCreated.IsZero() {
+		return now.Sub(p.Added)
+	}
+	return now.Sub(p.PresharedKeyCreated)
+}
+
+// RotatePresharedKey gives the peer a new preshared key, unique among peers
+func (conf *DsnetConfig) RotatePresharedKey(hostname string) error {
+	for i := range conf.Peers {
+		if conf.Peers[i].Hostname != hostname {
+			continue
+		}
+
+		presharedKey, err := lib.GenerateJSONKey()
+		if err != nil {
+			return fmt.Errorf("%w - failed to generate preshared key", err)
+		}
+
+		for _, p := range conf.Peers {
+			if p.PresharedKey.Key == presharedKey.Key {
+				return fmt.Errorf("%s is not an unique preshared key", hostname)
+			}
+		}
+
+		conf.Peers[i].PresharedKey = presharedKey
+		conf.Peers[i].PresharedKeyCreated = time.Now()
+		return nil
+	}
+
+	return fmt.Errorf("unknown hostname: %s", hostname)
+}
+
+// ExpiredPresharedKeys returns the hostnames of peers with a preshared key
+// older than PSKMaxAge
+func (conf *DsnetConfig) ExpiredPresharedKeys(now time.Time) []string {
+	hostnames := make([]string, 0)
+	if conf.PSKMaxAge.Duration <= 0 {
+		return hostnames
+	}
+
+	for _, peer := range conf.Peers {
+		if peer.PresharedKeyAge(now) > conf.PSKMaxAge.Duration {
+			hostnames = append(hostnames, peer.Hostname)
+		}
+	}
+	return hostnames
+}
+
+// rotatePresharedKeys rotates the preshared keys of the given peers and
+// writes their new configs to outputDir, returning their paths. conf is not
+// saved.
+func rotatePresharedKeys(conf *DsnetConfig, hostnames []string, outputDir string) ([]string, error) {
+	for _, hostname := range hostnames {
+		if err := conf.RotatePresharedKey(hostname); err != nil {
+			return nil, err
+		}
+	}
+
+	server := GetServer(conf)
+	peers := make([]lib.Peer, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		for _, peer := range server.Peers {
+			if peer.Hostname == hostname {
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	return WritePeerConfigs(outputDir, peers, server)
+}
+
+// RotatePSK rotates only the preshared key of one or all peers, leaving
+// their private keys alone. New configs are written to outputDir; a single
+// peer's config is printed to stdout if no outputDir is given.
+func RotatePSK(hostname string, all bool, outputDir string, confirm bool) error {
+	conf, err := LoadConfigFileForUpdate()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	hostnames := []string{hostname}
+	if all {
+		hostnames = make([]string, 0, len(conf.Peers))
+		for _, peer := range conf.Peers {
+			hostnames = append(hostnames, peer.Hostname)
+		}
+	}
+
+	if outputDir == "" && len(hostnames) != 1 {
+		return errors.New("--output-dir is required with --all")
+	}
+
+	if !confirm {
+		if err = ConfirmOrAbort("This will invalidate current configuration until it is updated. Rotate preshared key for %d peers?", len(hostnames)); err != nil {
+			return err
+		}
+	}
+
+	written := make([]string, 0)
+	if outputDir != "" {
+		if written, err = rotatePresharedKeys(conf, hostnames, outputDir); err != nil {
+			return err
+		}
+	} else if err = conf.RotatePresharedKey(hostname); err != nil {
+		return err
+	}
+
+	message := "Rotate the preshared key of peer " + hostname
+	if all {
+		message = "Rotate the preshared keys of all peers"
+	}
+	if err = conf.SaveChange(message); err != nil {
+		discardPeerConfigs(written, err)
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	if outputDir != "" {
+		fmt.Fprintf(os.Stderr, "New configs written to %s. %s\n", outputDir, privateKeyNotice)
+	} else {
+		// printed once saved, so that the config shown is the one in use
+		server := GetServer(conf)
+		for _, peer := range server.Peers {
+			if peer.Hostname == hostname {
+				if err = PrintPeerConfig(peer, server, false); err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Fprintln(os.Stderr, privateKeyNotice)
+	}
+
+	if err = WarnUnresolvedEndpoints(GetServer(conf).ConfigureDevice()); err != nil {
+		return fmt.Errorf("%w - failed to configure device", err)
+	}
+	return nil
+}
+
+// enforcePSKMaxAge rotates expired preshared keys during sync. Configs are
+// written to a timestamped directory under the output_dir setting
+// (--output-dir or DSNET_OUTPUT_DIR) so that repeated runs do not collide.
+// Without an output directory the new keys could not be distributed, so
+// nothing is rotated. Returns whether conf was changed.
+func enforcePSKMaxAge(conf *DsnetConfig, now time.Time) (bool, error) {
+	hostnames := conf.ExpiredPresharedKeys(now)
+	if len(hostnames) == 0 {
+		return false, nil
+	}
+
+	outputDir := viper.GetString("output_dir")
+	if outputDir == "" {
+		fmt.Fprintf(os.Stderr, "Warning: %d peers have a preshared key older than PSKMaxAge (%s), not rotating as no --output-dir or DSNET_OUTPUT_DIR is set\n", len(hostnames), conf.PSKMaxAge)
+		return false, nil
+	}
+
+	dir := filepath.Join(outputDir, "psk-"+now.Format("20060102T150405"))
+	if _, err := rotatePresharedKeys(conf, hostnames, dir); err != nil {
+		return false, fmt.Errorf("%w - failed to rotate expired preshared keys", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Rotated %d expired preshared keys, new configs written to %s. %s\n", len(hostnames), dir, privateKeyNotice)
+	return true, nil
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+func TestPresharedKeyAgeFallsBackToAdded(t *testing.T) {
+	now := time.Now()
+	peer := PeerConfig{Added: now.Add(-48 * time.Hour)}
+
+	if peer.PresharedKeyAge(now) != 48*time.Hour {
+		t.Fatalf("expected age from Added, got %s", peer.PresharedKeyAge(now))
+	}
+
+	peer.PresharedKeyCreated = now.Add(-time.Hour)
+	if peer.PresharedKeyAge(now) != time.Hour {
+		t.Fatalf("expected age from PresharedKeyCreated, got %s", peer.PresharedKeyAge(now))
+	}
+}
+
+func TestRotatePresharedKey(t *testing.T) {
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	before := conf.Peers[0]
+
+	if err := conf.RotatePresharedKey("laptop"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after := conf.Peers[0]
+	if after.PresharedKey.Key == before.PresharedKey.Key {
+		t.Fatal("preshared key should change")
+	}
+	if after.PublicKey.Key != before.PublicKey.Key {
+		t.Fatal("public key should not change")
+	}
+	if after.PresharedKeyAge(time.Now()) > time.Minute {
+		t.Fatal("PresharedKeyCreated should be updated")
+	}
+
+	if err := conf.RotatePresharedKey("unknown"); err == nil {
+		t.Fatal("expected error for unknown hostname")
+	}
+}
+
+func TestExpiredPresharedKeys(t *testing.T) {
+	conf := testDsnetConfig(t)
+	now := time.Now()
+
+	old := testLibPeer(t, "old", "alice", net.IP{10, 0, 0, 2})
+	old.PresharedKeyCreated = now.Add(-100 * 24 * time.Hour)
+	fresh := testLibPeer(t, "fresh", "alice", net.IP{10, 0, 0, 3})
+	fresh.PresharedKeyCreated = now.Add(-time.Hour)
+
+	for _, peer := range []lib.Peer{old, fresh} {
+		if err := conf.AddPeer(peer); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+	}
+
+	if len(conf.ExpiredPresharedKeys(now)) != 0 {
+		t.Fatal("nothing should expire without PSKMaxAge")
+	}
+
+	conf.PSKMaxAge = lib.JSONDuration{Duration: 90 * 24 * time.Hour}
+	expired := conf.ExpiredPresharedKeys(now)
+	if len(expired) != 1 || expired[0] != "old" {
+		t.Fatalf("expected only old to expire, got %v", expired)
+	}
+}
+
+func TestEnforcePSKMaxAge(t *testing.T) {
+	conf := testDsnetConfig(t)
+	now := time.Now()
+	conf.PSKMaxAge = lib.JSONDuration{Duration: 24 * time.Hour}
+
+	peer := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
+	peer.PresharedKeyCreated = now.Add(-48 * time.Hour)
+	if err := conf.AddPeer(peer); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	// as loaded from the config file
+	conf.Peers[0].PrivateKey = lib.JSONKey{}
+	viper.Set("output", "wg-quick")
+	t.Cleanup(func() { viper.Set("output", "") })
+
+	// without an output dir nothing is rotated
+	rotated, err := enforcePSKMaxAge(conf, now)
+	if err != nil || rotated {
+		t.Fatalf("expected no rotation without output dir, got %v, %v", rotated, err)
+	}
+
+	outputDir := t.TempDir()
+	viper.Set("output_dir", outputDir)
+	t.Cleanup(func() { viper.Set("output_dir", "") })
+
+	rotated, err = enforcePSKMaxAge(conf, now)
+	if err != nil || !rotated {
+		t.Fatalf("expected rotation, got %v, %v", rotated, err)
+	}
+	if conf.Peers[0].PresharedKey.Key == peer.PresharedKey.Key {
+		t.Fatal("expired preshared key should be rotated")
+	}
+
+	configPath := filepath.Join(outputDir, "psk-"+now.Format("20060102T150405"), "laptop.conf")
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("expected new config at %s: %v", configPath, err)
+	}
+	if !strings.Contains(string(config), "PrivateKey="+lib.PrivateKeyPlaceholder) {
+		t.Fatalf("expected the unknown private key to be a placeholder, got:\n%s", config)
+	}
+}
@@ -2,71 +2,75 @@ package cli
 
 import (
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/naggie/dsnet/lib"
-	"github.com/spf13/viper"
 )
 
-func Regenerate(hostname string, confirm bool) error {
-	config, err := LoadConfigFile()
-	if err != nil {
-		return fmt.Errorf("%w - failure to load config file", err)
-	}
-	server := GetServer(config)
-
-	found := false
-
-	if !confirm {
-		ConfirmOrAbort("This will invalidate current configuration. Regenerate config for %s?", hostname)
-	}
+// RegeneratePeer replaces the keys of a peer, returning it with its new
+// private key
+func (conf *DsnetConfig) RegeneratePeer(hostname string) (lib.Peer, error) {
+	for _, peer := range GetServer(conf).Peers {
+		if peer.Hostname != hostname {
+			continue
+		}
 
-	for _, peer := range server.Peers {
-		if peer.Hostname == hostname {
-			privateKey, err := lib.GenerateJSONPrivateKey()
-			if err != nil {
-				return fmt.Errorf("%w - failed to generate private key", err)
-			}
+		privateKey, err := lib.GenerateJSONPrivateKey()
+		if err != nil {
+			return lib.Peer{}, fmt.Errorf("%w - failed to generate private key", err)
+		}
 
-			preshareKey, err := lib.GenerateJSONKey()
-			if err != nil {
-				return fmt.Errorf("%w - failed to generate preshared key", err)
-			}
+		preshareKey, err := lib.GenerateJSONKey()
+		if err != nil {
+			return lib.Peer{}, fmt.Errorf("%w - failed to generate preshared key", err)
+		}
 
-			peer.PrivateKey = privateKey
-			peer.PublicKey = privateKey.PublicKey()
-			peer.PresharedKey = preshareKey
+		peer.PrivateKey = privateKey
+		peer.PublicKey = privateKey.PublicKey()
+		peer.PresharedKey = preshareKey
+		peer.PresharedKeyCreated = time.Now()
 
-			err = config.RemovePeer(hostname)
-			if err != nil {
-				return fmt.Errorf("%w - failed to regenerate peer", err)
-			}
+		if err = conf.RemovePeer(hostname); err != nil {
+			return lib.Peer{}, fmt.Errorf("%w - failed to regenerate peer", err)
+		}
+		if err = conf.AddPeer(peer); err != nil {
+			return lib.Peer{}, fmt.Errorf("%w - failure to add peer", err)
+		}
+		return peer, nil
+	}
 
-			peerType := viper.GetString("output")
+	return lib.Peer{}, fmt.Errorf("unknown hostname: %s", hostname)
+}
 
-			peerConfigBytes, err := lib.AsciiPeerConfig(peer, peerType, *server)
-			if err != nil {
-				return fmt.Errorf("%w - failed to get peer configuration", err)
-			}
-			os.Stdout.Write(peerConfigBytes.Bytes())
-			found = true
-			if err = config.AddPeer(peer); err != nil {
-				return fmt.Errorf("%w - failure to add peer", err)
-			}
+func Regenerate(hostname string, confirm, asJSON bool) error {
+	config, err := LoadConfigFileForUpdate()
+	if err != nil {
+		return fmt.Errorf("%w - failure to load config file", err)
+	}
 
-			break
+	if !confirm {
+		if err = ConfirmOrAbort("This will invalidate current configuration. Regenerate config for %s?", hostname); err != nil {
+			return err
 		}
 	}
 
-	if !found {
-		return fmt.Errorf("unknown hostname: %s", hostname)
+	// the mesh partners link to the old public key
+	partners := meshPartnersOf(config, hostname)
+	peer, err := config.RegeneratePeer(hostname)
+	if err != nil {
+		return err
 	}
 
 	// Get a new server configuration so we can update the wg interface with the new peer details
-	server = GetServer(config)
-	if err = config.Save(); err != nil {
+	server := GetServer(config)
+	if err = PrintPeerConfig(peer, server, asJSON); err != nil {
+		return err
+	}
+
+	if err = config.SaveChange("Regenerate keys of peer " + hostname); err != nil {
 		return fmt.Errorf("%w - failure saving config", err)
 	}
-	server.ConfigureDevice()
+	WarnUnresolvedEndpoints(server.ConfigureDevice())
+	printMeshNotice(hostname, partners)
 	return nil
 }
@@ -0,0 +1,50 @@
+package cli
+
+import "testing"
+
+func TestRegeneratePeerMesh(t *testing.T) {
+	conf := testEditConfig(t)
+	conf.Mesh = true
+	if err := conf.ensureMeshKey(); err != nil {
+		t.Fatal(err)
+	}
+	conf.Peers[0].Endpoint = "192.0.2.2:51820"
+	conf.Peers[1].Endpoint = "192.0.2.3:51820"
+	oldKey := conf.Peers[0].PublicKey
+
+	// phone has the public key of laptop in its config
+	if partners := meshPartnersOf(conf, "laptop"); len(partners) != 1 || partners[0] != "phone" {
+		t.Fatalf("expected phone to be told, got %v", partners)
+	}
+
+	peer, err := conf.RegeneratePeer("laptop")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peer.PublicKey == oldKey || peer.PublicKey != peer.PrivateKey.PublicKey() {
+		t.Fatal("expected a new key pair")
+	}
+
+	server := GetServer(conf)
+	for _, p := range server.Peers {
+		if p.Hostname != "phone" {
+			continue
+		}
+		meshPeers := server.GetMeshPeers(p)
+		if len(meshPeers) != 1 || meshPeers[0].Peer.PublicKey != peer.PublicKey {
+			t.Fatal("expected phone to link to the new key of laptop")
+		}
+	}
+
+	// the regenerated peer does not link to itself
+	if partners := meshPartners(server, peer); len(partners) != 1 || partners[0] != "phone" {
+		t.Fatalf("expected laptop to link to phone only, got %v", partners)
+	}
+}
+
+func TestRegeneratePeerUnknown(t *testing.T) {
+	conf := testEditConfig(t)
+	if _, err := conf.RegeneratePeer("tablet"); err == nil {
+		t.Fatal("expected an unknown hostname to be refused")
+	}
+}
@@ -3,26 +3,31 @@ package cli
 import "fmt"
 
 func Remove(hostname string, confirm bool) error {
-	conf, err := LoadConfigFile()
+	conf, err := LoadConfigFileForUpdate()
 	if err != nil {
 		return fmt.Errorf("%w - failed to load config", err)
 	}
 
+	partners := meshPartnersOf(conf, hostname)
 	if err = conf.RemovePeer(hostname); err != nil {
 		return fmt.Errorf("%w - failed to update config", err)
 	}
 
 	if !confirm {
-		ConfirmOrAbort("Do you really want to remove %s?", hostname)
+		if err = ConfirmOrAbort("Do you really want to remove %s?", hostname); err != nil {
+			return err
+		}
 	}
 
-	if err = conf.Save(); err != nil {
+	if err = conf.SaveChange("Remove peer " + hostname); err != nil {
 		return fmt.Errorf("%w - failure to save config", err)
 	}
 	server := GetServer(conf)
 
-	if err = server.ConfigureDevice(); err != nil {
+	if err = WarnUnresolvedEndpoints(server.ConfigureDevice()); err != nil {
 		return fmt.Errorf("%w - failed to sync server config to wg interface: %s", err, server.InterfaceName)
 	}
+
+	printMeshNotice(hostname, partners)
 	return nil
 }
@@ -0,0 +1,446 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+const (
+	RolePrimary   = "primary"
+	RoleSecondary = "secondary"
+)
+
+// ReplicationConfig makes the server one of several with the same peers, for
+// failover. The primary is changed as usual; secondaries pull its peers and
+// keys with `dsnet replicate pull` and refuse changes of their own.
+type ReplicationConfig struct {
+	Role string `validate:"required,oneof=primary secondary"`
+	// file containing the key shared by the primary and secondaries, in the
+	// same format as DSNET_SECRET_KEY_FILE. Snapshots of the primary config
+	// are encrypted and authenticated with it.
+	KeyFile string `validate:"required"`
+	// primary: address `dsnet replicate serve` listens on, e.g. :51821
+	Listen string `json:",omitempty"`
+	// secondary: where to pull snapshots from, the http:// URL served by the
+	// primary or a file written by `dsnet replicate export`
+	Primary string `json:",omitempty"`
+	// secondary: endpoint host of the primary, used in generated client
+	// configs. Set by pull.
+	PrimaryEndpoint string `json:",omitempty"`
+	// secondary: when the primary took the last snapshot pulled
+	Replicated *time.Time `json:",omitempty"`
+}
+
+// ReplicaSnapshot is the part of the config shared by every server, as
+// served by the primary. Everything else, such as ExternalIP, IP and
+// InterfaceName, is local to each server.
+type ReplicaSnapshot struct {
+	// when the primary took the snapshot
+	Generated       time.Time
+	PrimaryEndpoint string
+	// interface IPs of the primary, which a secondary may share
+	PrimaryIP  net.IP `json:",omitempty"`
+	PrimaryIP6 net.IP `json:",omitempty"`
+	ListenPort int
+	Domain     string
+	Network    lib.JSONIPNet
+	Network6   lib.JSONIPNet
+	DNS        net.IP
+	Networks   []lib.JSONIPNet
+	Reserved   []lib.IPRange
+	Pools      map[string][]lib.IPRange
+	// empty if the primary loads its key from PrivateKeyFile or
+	// PrivateKeyCommand, which the secondaries must then do too
+	PrivateKey          lib.JSONKey
+	PrivateKeyActivated *time.Time         `json:",omitempty"`
+	KeyRotation         *ServerKeyRotation `json:",omitempty"`
+	Peers               []PeerConfig
+	PersistentKeepalive int
+	MTU                 int
+	PSKMaxAge           lib.JSONDuration
+	Mesh                bool
+	MeshKey             *lib.JSONKey `json:",omitempty"`
+}
+
+func (conf *DsnetConfig) isSecondary() bool {
+	return conf.Replication != nil && conf.Replication.Role == RoleSecondary
+}
+
+// LoadConfigFileForUpdate loads the config to change it, which is refused on
+// replication secondaries as the change would be overwritten by the next pull.
+// With DSNET_GIT, it also fails early if the change could not be committed.
+func LoadConfigFileForUpdate() (*DsnetConfig, error) {
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.isSecondary() {
+		return nil, fmt.Errorf("this server is a replication secondary, change the config on the primary (%s) instead", conf.Replication.Primary)
+	}
+
+	if gitEnabled() {
+		if err = checkGitStore(conf); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// Snapshot returns the replicated part of the config
+func (conf *DsnetConfig) Snapshot(now time.Time) (*ReplicaSnapshot, error) {
+	endpoint, err := GetServer(conf).GetEndpointHost()
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &ReplicaSnapshot{
+		Generated:           now,
+		PrimaryEndpoint:     endpoint,
+		PrimaryIP:           conf.IP,
+		PrimaryIP6:          conf.IP6,
+		ListenPort:          conf.ListenPort,
+		Domain:              conf.Domain,
+		Network:             conf.Network,
+		Network6:            conf.Network6,
+		DNS:                 conf.DNS,
+		Networks:            conf.Networks,
+		Reserved:            conf.Reserved,
+		Pools:               conf.Pools,
+		PrivateKey:          conf.PrivateKey,
+		PrivateKeyActivated: conf.PrivateKeyActivated,
+		KeyRotation:         conf.KeyRotation,
+		Peers:               conf.Peers,
+		PersistentKeepalive: conf.PersistentKeepalive,
+		MTU:                 conf.MTU,
+		PSKMaxAge:           conf.PSKMaxAge,
+		Mesh:                conf.Mesh,
+		MeshKey:             conf.MeshKey,
+	}
+	// a key managed outside the config stays there
+	if conf.externalPrivateKey() {
+		snap.PrivateKey = lib.JSONKey{}
+	}
+	return snap, nil
+}
+
+// ApplySnapshot replaces the replicated part of the config with snap. Snapshots
+// older than the last one applied are refused, so that an old snapshot
+// cannot be replayed to roll back peers or keys.
+func (conf *DsnetConfig) ApplySnapshot(snap *ReplicaSnapshot) error {
+	if !conf.isSecondary() {
+		return errors.New("only a replication secondary can apply a snapshot")
+	}
+
+	if replicated := conf.Replication.Replicated; replicated != nil && snap.Generated.Before(*replicated) {
+		return fmt.Errorf("snapshot from %s is older than the current one from %s", snap.Generated.Format(time.RFC3339), replicated.Format(time.RFC3339))
+	}
+
+	candidate := *conf
+	replication := *conf.Replication
+	candidate.Replication = &replication
+
+	candidate.ListenPort = snap.ListenPort
+	candidate.Domain = snap.Domain
+	candidate.Network = snap.Network
+	candidate.Network6 = snap.Network6
+	candidate.DNS = snap.DNS
+	candidate.Networks = snap.Networks
+	candidate.Reserved = snap.Reserved
+	candidate.Pools = snap.Pools
+	candidate.Peers = snap.Peers
+	candidate.PersistentKeepalive = snap.PersistentKeepalive
+	candidate.MTU = snap.MTU
+	candidate.PSKMaxAge = snap.PSKMaxAge
+	candidate.Mesh = snap.Mesh
+	candidate.MeshKey = snap.MeshKey
+
+	// a key managed outside the config is kept in step by other means
+	if !conf.externalPrivateKey() {
+		if snap.PrivateKey == (lib.JSONKey{}) {
+			return errors.New("the primary loads its key from PrivateKeyFile or PrivateKeyCommand, set one of them on this secondary too")
+		}
+		candidate.PrivateKey = snap.PrivateKey
+		candidate.PrivateKeyActivated = snap.PrivateKeyActivated
+		candidate.KeyRotation = snap.KeyRotation
+	}
+
+	generated := snap.Generated
+	candidate.Replication.PrimaryEndpoint = snap.PrimaryEndpoint
+	candidate.Replication.Replicated = &generated
+
+	if err := candidate.Validate(); err != nil {
+		return fmt.Errorf("%w - snapshot not applied", err)
+	}
+	if err := candidate.checkSecondaryIPs(snap); err != nil {
+		return fmt.Errorf("%w - snapshot not applied", err)
+	}
+
+	*conf = candidate
+	return nil
+}
+
+// checkSecondaryIPs ensures that the primary cannot allocate the interface
+// IPs of this secondary to a peer, as every later snapshot would then be
+// refused. Each must be an interface IP of the primary, or in Reserved.
+func (conf *DsnetConfig) checkSecondaryIPs(snap *ReplicaSnapshot) error {
+	server := GetServer(conf)
+	for _, ip := range []net.IP{conf.IP, conf.IP6} {
+		if len(ip) == 0 || ip.Equal(snap.PrimaryIP) || ip.Equal(snap.PrimaryIP6) || server.IPReserved(ip) {
+			continue
+		}
+		return fmt.Errorf("IP %s of this secondary is not in Reserved on the primary, add it there so that it is not allocated to a peer", ip)
+	}
+	return nil
+}
+
+// replicationBox returns the SecretBox for snapshots, from KeyFile
+func (conf *DsnetConfig) replicationBox() (*lib.SecretBox, error) {
+	if conf.Replication == nil {
+		return nil, errors.New("replication is not configured, see Replication in CONFIG.md")
+	}
+
+	keyMaterial, err := ioutil.ReadFile(conf.Replication.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w - failed to read replication KeyFile", err)
+	}
+
+	box, err := lib.NewSecretBox(keyMaterial)
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", err, conf.Replication.KeyFile)
+	}
+	return box, nil
+}
+
+// SealedSnapshot returns a snapshot of the config, encrypted with the
+// replication key. Secrets are in plaintext inside, regardless of whether
+// they are encrypted at rest, as each server has its own secret key.
+func (conf *DsnetConfig) SealedSnapshot(now time.Time) (string, error) {
+	box, err := conf.replicationBox()
+	if err != nil {
+		return "", err
+	}
+
+	snap, err := conf.Snapshot(now)
+	if err != nil {
+		return "", err
+	}
+
+	var plaintext []byte
+	err = lib.WithoutSecretBox(func() error {
+		plaintext, err = json.Marshal(snap)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return box.Seal(plaintext)
+}
+
+// OpenSnapshot decrypts and authenticates a snapshot from SealedSnapshot
+func (conf *DsnetConfig) OpenSnapshot(sealed string) (*ReplicaSnapshot, error) {
+	box, err := conf.replicationBox()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := box.Open(strings.TrimSpace(sealed))
+	if err != nil {
+		return nil, fmt.Errorf("%w - is KeyFile the same as on the primary?", err)
+	}
+
+	snap := &ReplicaSnapshot{}
+	if err = json.Unmarshal(plaintext, snap); err != nil {
+		return nil, fmt.Errorf("%w - invalid snapshot", err)
+	}
+	return snap, nil
+}
+
+func loadPrimaryConfig() (*DsnetConfig, error) {
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("%w - failed to load configuration file", err)
+	}
+	if conf.Replication == nil || conf.Replication.Role != RolePrimary {
+		return nil, errors.New("this server is not a replication primary, see Replication in CONFIG.md")
+	}
+	return conf, nil
+}
+
+// ReplicateExport writes a sealed snapshot to stdout, for secondaries to pull
+// from a shared file
+func ReplicateExport() error {
+	conf, err := loadPrimaryConfig()
+	if err != nil {
+		return err
+	}
+
+	sealed, err := conf.SealedSnapshot(time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(sealed)
+	return nil
+}
+
+// ReplicateServe serves sealed snapshots of the config over HTTP until
+// killed. The config is loaded for each request, so changes are served as
+// soon as they are saved. listen overrides Replication.Listen.
+func ReplicateServe(listen string) error {
+	conf, err := loadPrimaryConfig()
+	if err != nil {
+		return err
+	}
+
+	if listen == "" {
+		listen = conf.Replication.Listen
+	}
+	if listen == "" {
+		return errors.New("Replication.Listen or --listen is required")
+	}
+
+	// WithoutSecretBox changes global state
+	var mu sync.Mutex
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		conf, err := loadPrimaryConfig()
+		var sealed string
+		if err == nil {
+			sealed, err = conf.SealedSnapshot(time.Now())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to serve snapshot to %s: %s\n", r.RemoteAddr, err)
+			http.Error(w, "failed to take snapshot", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(w, sealed)
+	})
+
+	server := &http.Server{
+		Addr:         listen,
+		Handler:      handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	fmt.Fprintf(os.Stderr, "Serving snapshots of %s on %s\n", viper.GetString("config_file"), listen)
+	return server.ListenAndServe()
+}
+
+// replicatedJSON returns the config in plaintext without the time of the last
+// pull, to tell whether a pull changed anything
+func (conf DsnetConfig) replicatedJSON() ([]byte, error) {
+	replication := *conf.Replication
+	replication.Replicated = nil
+	conf.Replication = &replication
+
+	var raw []byte
+	err := lib.WithoutSecretBox(func() error {
+		var err error
+		raw, err = json.Marshal(conf)
+		return err
+	})
+	return raw, err
+}
+
+// fetchSnapshot reads a sealed snapshot from a URL or file
+func fetchSnapshot(primary string) (string, error) {
+	if !strings.HasPrefix(primary, "http://") && !strings.HasPrefix(primary, "https://") {
+		raw, err := ioutil.ReadFile(primary)
+		return string(raw), err
+	}
+
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := client.Get(primary)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", primary, resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	return string(raw), err
+}
+
+// ReplicatePull fetches a snapshot from the primary, applies it, saves and
+// syncs the interface
+func ReplicatePull() error {
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+	if !conf.isSecondary() {
+		return errors.New("this server is not a replication secondary, see Replication in CONFIG.md")
+	}
+	if conf.Replication.Primary == "" {
+		return errors.New("Replication.Primary is required to pull")
+	}
+
+	sealed, err := fetchSnapshot(conf.Replication.Primary)
+	if err != nil {
+		return fmt.Errorf("%w - failed to fetch snapshot", err)
+	}
+
+	snap, err := conf.OpenSnapshot(sealed)
+	if err != nil {
+		return err
+	}
+
+	before, err := conf.replicatedJSON()
+	if err != nil {
+		return err
+	}
+
+	if err = conf.ApplySnapshot(snap); err != nil {
+		return err
+	}
+
+	after, err := conf.replicatedJSON()
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(before, after) {
+		// only the time of the pull changed, not worth a commit. Secrets are
+		// saved as loaded, so that is the only difference from the last one.
+		err = conf.Save()
+	} else {
+		err = conf.SaveChange(fmt.Sprintf("Replicate snapshot of %s from %s", conf.Replication.Primary, snap.Generated.Format(time.RFC3339)))
+	}
+	if err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	if err = WarnUnresolvedEndpoints(GetServer(conf).ConfigureDevice()); err != nil {
+		return fmt.Errorf("%w - failed to configure device", err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naggie/dsnet/lib"
+)
+
+func testReplicationKeyFile(t *testing.T, key string) string {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "replication.key")
+	if err := os.WriteFile(keyFile, []byte(key), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	return keyFile
+}
+
+// testReplicas returns a primary with a peer, and a secondary with its own
+// external and interface IPs, the latter reserved on the primary
+func testReplicas(t *testing.T) (*DsnetConfig, *DsnetConfig) {
+	t.Helper()
+	keyFile := testReplicationKeyFile(t, "shared passphrase")
+
+	secondaryIP, err := lib.ParseIPRange("10.0.3.254")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := testDsnetConfig(t)
+	primary.Reserved = []lib.IPRange{secondaryIP}
+	primary.Replication = &ReplicationConfig{Role: RolePrimary, KeyFile: keyFile}
+	if err := primary.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	secondary := testDsnetConfig(t)
+	secondary.ExternalHostname = "vpn2.example.com"
+	secondary.IP = net.IP{10, 0, 3, 254}
+	secondary.InterfaceName = "dsnet2"
+	secondary.Replication = &ReplicationConfig{Role: RoleSecondary, KeyFile: keyFile, Primary: "http://vpn.example.com:51821"}
+
+	return primary, secondary
+}
+
+func TestReplicateSnapshot(t *testing.T) {
+	primary, secondary := testReplicas(t)
+	// secrets at rest on the primary are not sealed in the snapshot
+	setSecretKeyFileForTest(t, "primary passphrase")
+
+	now := time.Now()
+	sealed, err := primary.SealedSnapshot(now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(sealed, primary.Peers[0].PublicKey.Key.String()) {
+		t.Fatal("expected the snapshot to be encrypted")
+	}
+
+	lib.SetSecretBox(nil)
+	snap, err := secondary.OpenSnapshot(sealed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = secondary.ApplySnapshot(snap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(secondary.Peers) != 1 || secondary.Peers[0].PresharedKey != primary.Peers[0].PresharedKey {
+		t.Fatalf("expected the peers of the primary, got %+v", secondary.Peers)
+	}
+	if secondary.PrivateKey != primary.PrivateKey {
+		t.Fatal("expected the server key of the primary")
+	}
+	if secondary.ExternalHostname != "vpn2.example.com" || !secondary.IP.Equal(net.IP{10, 0, 3, 254}) || secondary.InterfaceName != "dsnet2" {
+		t.Fatal("expected the local settings of the secondary to be kept")
+	}
+	if secondary.Replication.Replicated == nil || !secondary.Replication.Replicated.Equal(now) {
+		t.Fatalf("expected the snapshot time to be recorded, got %v", secondary.Replication.Replicated)
+	}
+
+	// client configs rendered on either server point at the primary
+	if endpoint, _ := GetServer(secondary).GetEndpointHost(); endpoint != "vpn.example.com" {
+		t.Fatalf("expected the primary endpoint, got %s", endpoint)
+	}
+
+	// replaying an older snapshot is refused
+	older, err := primary.SealedSnapshot(now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if snap, err = secondary.OpenSnapshot(older); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = secondary.ApplySnapshot(snap); err == nil {
+		t.Fatal("expected an older snapshot to be refused")
+	}
+}
+
+func TestReplicateSnapshotWrongKey(t *testing.T) {
+	primary, secondary := testReplicas(t)
+	secondary.Replication.KeyFile = testReplicationKeyFile(t, "another passphrase")
+
+	sealed, err := primary.SealedSnapshot(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = secondary.OpenSnapshot(sealed); err == nil {
+		t.Fatal("expected a snapshot sealed with another key to be refused")
+	}
+}
+
+func TestReplicateSnapshotInvalid(t *testing.T) {
+	primary, secondary := testReplicas(t)
+	// the primary gave a peer the interface IP of the secondary
+	if err := primary.AddPeer(testLibPeer(t, "phone", "bob", net.IP{10, 0, 3, 254})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	snap, err := primary.Snapshot(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = secondary.ApplySnapshot(snap); err == nil {
+		t.Fatal("expected an invalid snapshot to be refused")
+	}
+	if len(secondary.Peers) != 0 {
+		t.Fatal("expected the config to be unchanged")
+	}
+}
+
+func TestReplicateSnapshotExternalKey(t *testing.T) {
+	primary, secondary := testReplicas(t)
+	primary.PrivateKeyFile = "/etc/dsnet/server.key"
+
+	snap, err := primary.Snapshot(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if snap.PrivateKey != (lib.JSONKey{}) {
+		t.Fatal("expected a key from PrivateKeyFile to be left out of the snapshot")
+	}
+
+	if err = secondary.ApplySnapshot(snap); err == nil {
+		t.Fatal("expected a snapshot without a key to be refused by a secondary without PrivateKeyFile")
+	}
+
+	secondary.PrivateKeyFile = "/etc/dsnet/server.key"
+	key := secondary.PrivateKey
+	if err = secondary.ApplySnapshot(snap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secondary.PrivateKey != key {
+		t.Fatal("expected the key of the secondary to be kept")
+	}
+}
+
+func TestReplicateSnapshotUnreservedIP(t *testing.T) {
+	primary, secondary := testReplicas(t)
+	primary.Reserved = nil
+
+	snap, err := primary.Snapshot(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = secondary.ApplySnapshot(snap); err == nil || !strings.Contains(err.Error(), "10.0.3.254") {
+		t.Fatalf("expected the unreserved IP of the secondary to be refused, got %v", err)
+	}
+	if len(secondary.Peers) != 0 {
+		t.Fatal("expected the config to be unchanged")
+	}
+
+	// sharing the interface IP of the primary is fine, as it is never allocated
+	secondary.IP = primary.IP
+	if err = secondary.ApplySnapshot(snap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfigFileForUpdateSecondary(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+
+	_, secondary := testReplicas(t)
+	if err := secondary.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if _, err := LoadConfigFileForUpdate(); err == nil {
+		t.Fatal("expected changes to a secondary to be refused")
+	}
+	if _, err := LoadConfigFile(); err != nil {
+		t.Fatalf("expected the secondary to load, got %v", err)
+	}
+}
+
+func TestFetchSnapshotFile(t *testing.T) {
+	primary, secondary := testReplicas(t)
+	sealed, err := primary.SealedSnapshot(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot")
+	if err = os.WriteFile(snapshotFile, []byte(sealed+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := fetchSnapshot(snapshotFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = secondary.OpenSnapshot(fetched); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReplicationReport(t *testing.T) {
+	primary, secondary := testReplicas(t)
+	now := time.Now()
+
+	if report := getReplicationReport(primary, now); report.Role != RolePrimary || report.Lag != nil {
+		t.Fatalf("unexpected primary report %+v", report)
+	}
+
+	replicated := now.Add(-90 * time.Second)
+	secondary.Replication.Replicated = &replicated
+	if report := getReplicationReport(secondary, now); report.Lag == nil || report.Lag.Duration != 90*time.Second {
+		t.Fatalf("expected a lag of 90s, got %+v", report)
+	}
+
+	if report := getReplicationReport(testDsnetConfig(t), now); report != nil {
+		t.Fatalf("expected no replication report, got %+v", report)
+	}
+}
+
+func TestValidateConfigReplication(t *testing.T) {
+	_, secondary := testReplicas(t)
+	secondary.Replication.Primary = ""
+
+	if paths := problemPaths(ValidateConfig(secondary)); len(paths) != 1 || paths[0] != "$.Replication.Primary" {
+		t.Fatalf("expected a problem with Primary, got %v", paths)
+	}
+
+	secondary.Replication.Role = "tertiary"
+	secondary.Replication.Primary = "http://vpn.example.com:51821"
+	if paths := problemPaths(ValidateConfig(secondary)); len(paths) != 1 || paths[0] != "$.Replication.Role" {
+		t.Fatalf("expected a problem with Role, got %v", paths)
+	}
+}
@@ -36,10 +36,25 @@ type DsnetReport struct {
 	TransmitBytes   uint64
 	ReceiveBytesSI  string
 	TransmitBytesSI string
+	// when the server key was last rotated, if ever
+	ServerKeyActivated *time.Time `json:",omitempty"`
+	// cutover time of a staged server key rotation
+	ServerKeyCutover *time.Time `json:",omitempty"`
+	// role of the server and, on secondaries, how far behind the primary
+	Replication *ReplicationReport `json:",omitempty"`
 	// when the report was made
 	Timestamp time.Time
 }
 
+type ReplicationReport struct {
+	Role    string
+	Primary string `json:",omitempty"`
+	// when the primary took the snapshot last pulled by a secondary
+	Replicated *time.Time `json:",omitempty"`
+	// time since Replicated, i.e. how out of date the secondary may be
+	Lag *lib.JSONDuration `json:",omitempty"`
+}
+
 type PeerReport struct {
 	// Used to update DNS
 	Hostname string
@@ -58,8 +73,11 @@ type PeerReport struct {
 	IP6 net.IP
 	// Last known external IP
 	ExternalIP net.IP
-	// TODO ExternalIP support (Endpoint)
-	//ExternalIP     net.UDPAddr `validate:"required,udp4_addr"`
+	// static endpoint from the config, if any
+	Endpoint string `json:",omitempty"`
+	// endpoint the last packets came from, which may differ from Endpoint
+	// if the peer has roamed
+	ObservedEndpoint string
 	// TODO support routing additional networks (AllowedIPs)
 	Networks          []lib.JSONIPNet
 	LastHandshakeTime time.Time
@@ -67,32 +85,60 @@ type PeerReport struct {
 	TransmitBytes     uint64
 	ReceiveBytesSI    string
 	TransmitBytesSI   string
+	// how long the current preshared key has been in use
+	PresharedKeyAge lib.JSONDuration
+	// Has the peer handshaken since the server key was last rotated?
+	OnCurrentServerKey bool
 }
 
 func GenerateReport() error {
+	report, err := ReadReport()
+	if err != nil {
+		return err
+	}
+	report.Print()
+	return nil
+}
+
+// GenerateReports prints the reports of every instance as a JSON object by
+// instance name. Instances that fail are left out and reported afterwards.
+func GenerateReports() error {
+	reports := make(map[string]DsnetReport)
+	err := ForEachInstance(func(name string) error {
+		report, err := ReadReport()
+		if err != nil {
+			return err
+		}
+		reports[name] = report
+		return nil
+	})
+
+	_json, _ := json.MarshalIndent(reports, "", "    ")
+	_json = append(_json, '\n')
+	fmt.Print(string(_json))
+	return err
+}
+
+// ReadReport loads the config and reads the interface to report on it
+func ReadReport() (DsnetReport, error) {
 	conf, err := LoadConfigFile()
 	if err != nil {
-		return fmt.Errorf("%w - failure to load config", err)
+		return DsnetReport{}, fmt.Errorf("%w - failure to load config", err)
 	}
 
 	wg, err := wgctrl.New()
 	if err != nil {
-		return fmt.Errorf("%w - failure to create new client", err)
+		return DsnetReport{}, fmt.Errorf("%w - failure to create new client", err)
 	}
 	defer wg.Close()
 
 	dev, err := wg.Device(conf.InterfaceName)
 
 	if err != nil {
-		return fmt.Errorf("%w - Could not retrieve device '%s'", err, conf.InterfaceName)
+		return DsnetReport{}, fmt.Errorf("%w - Could not retrieve device '%s'", err, conf.InterfaceName)
 	}
 
-	report, err := GetReport(dev, conf)
-	if err != nil {
-		return err
-	}
-	report.Print()
-	return nil
+	return GetReport(dev, conf)
 }
 
 func GetReport(dev *wgtypes.Device, conf *DsnetConfig) (DsnetReport, error) {
@@ -130,55 +176,85 @@ func GetReport(dev *wgtypes.Device, conf *DsnetConfig) (DsnetReport, error) {
 		}
 
 		externalIP := net.IP{}
+		observedEndpoint := ""
 		if wgPeer.Endpoint != nil {
 			externalIP = wgPeer.Endpoint.IP
+			observedEndpoint = wgPeer.Endpoint.String()
 		}
 
 		uReceiveBytes := uint64(wgPeer.ReceiveBytes)
 		uTransmitBytes := uint64(wgPeer.TransmitBytes)
 
 		peerReports = append(peerReports, PeerReport{
-			Hostname:          peer.Hostname,
-			Online:            online,
-			Dormant:           dormant,
-			Owner:             peer.Owner,
-			Description:       peer.Description,
-			Added:             peer.Added,
-			IP:                peer.IP,
-			IP6:               peer.IP6,
-			ExternalIP:        externalIP,
-			Networks:          peer.Networks,
-			LastHandshakeTime: wgPeer.LastHandshakeTime,
-			ReceiveBytes:      uReceiveBytes,
-			TransmitBytes:     uTransmitBytes,
-			ReceiveBytesSI:    BytesToSI(uReceiveBytes),
-			TransmitBytesSI:   BytesToSI(uTransmitBytes),
+			Hostname:           peer.Hostname,
+			Online:             online,
+			Dormant:            dormant,
+			Owner:              peer.Owner,
+			Description:        peer.Description,
+			Added:              peer.Added,
+			IP:                 peer.IP,
+			IP6:                peer.IP6,
+			ExternalIP:         externalIP,
+			Endpoint:           peer.Endpoint,
+			ObservedEndpoint:   observedEndpoint,
+			Networks:           peer.Networks,
+			LastHandshakeTime:  wgPeer.LastHandshakeTime,
+			ReceiveBytes:       uReceiveBytes,
+			TransmitBytes:      uTransmitBytes,
+			ReceiveBytesSI:     BytesToSI(uReceiveBytes),
+			TransmitBytesSI:    BytesToSI(uTransmitBytes),
+			PresharedKeyAge:    lib.JSONDuration{Duration: peer.PresharedKeyAge(time.Now()).Truncate(time.Second)},
+			OnCurrentServerKey: onCurrentServerKey(conf, wgPeer.LastHandshakeTime),
 		})
 	}
 
+	var cutover *time.Time
+	if conf.KeyRotation != nil {
+		cutover = &conf.KeyRotation.Cutover
+	}
+
 	return DsnetReport{
-		ExternalIP:       conf.ExternalIP,
-		ExternalIP6:      conf.ExternalIP6,
-		ExternalHostname: conf.ExternalHostname,
-		InterfaceName:    conf.InterfaceName,
-		ListenPort:       conf.ListenPort,
-		Domain:           conf.Domain,
-		IP:               conf.IP,
-		IP6:              conf.IP6,
-		Network:          conf.Network,
-		Network6:         conf.Network6,
-		DNS:              conf.DNS,
-		Peers:            peerReports,
-		PeersOnline:      peersOnline,
-		PeersTotal:       len(peerReports),
-		ReceiveBytes:     stats.RxBytes,
-		TransmitBytes:    stats.TxBytes,
-		ReceiveBytesSI:   BytesToSI(stats.RxBytes),
-		TransmitBytesSI:  BytesToSI(stats.TxBytes),
-		Timestamp:        time.Now(),
+		ExternalIP:         conf.ExternalIP,
+		ExternalIP6:        conf.ExternalIP6,
+		ExternalHostname:   conf.ExternalHostname,
+		InterfaceName:      conf.InterfaceName,
+		ListenPort:         conf.ListenPort,
+		Domain:             conf.Domain,
+		IP:                 conf.IP,
+		IP6:                conf.IP6,
+		Network:            conf.Network,
+		Network6:           conf.Network6,
+		DNS:                conf.DNS,
+		Peers:              peerReports,
+		PeersOnline:        peersOnline,
+		PeersTotal:         len(peerReports),
+		ReceiveBytes:       stats.RxBytes,
+		TransmitBytes:      stats.TxBytes,
+		ReceiveBytesSI:     BytesToSI(stats.RxBytes),
+		TransmitBytesSI:    BytesToSI(stats.TxBytes),
+		ServerKeyActivated: conf.PrivateKeyActivated,
+		ServerKeyCutover:   cutover,
+		Replication:        getReplicationReport(conf, time.Now()),
+		Timestamp:          time.Now(),
 	}, nil
 }
 
+func getReplicationReport(conf *DsnetConfig, now time.Time) *ReplicationReport {
+	if conf.Replication == nil {
+		return nil
+	}
+
+	report := &ReplicationReport{
+		Role:       conf.Replication.Role,
+		Primary:    conf.Replication.Primary,
+		Replicated: conf.Replication.Replicated,
+	}
+	if conf.isSecondary() && conf.Replication.Replicated != nil {
+		report.Lag = &lib.JSONDuration{Duration: now.Sub(*conf.Replication.Replicated)}
+	}
+	return report
+}
+
 func (report *DsnetReport) Print() {
 	_json, _ := json.MarshalIndent(report, "", "    ")
 	_json = append(_json, '\n')
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/naggie/dsnet/lib"
+)
+
+// ServerKeyRotation is a staged change of the server private key. A
+// WireGuard interface has a single private key, so the old key stays live
+// until Cutover, giving peers time to receive their new configs. sync
+// performs the cutover once it is due.
+type ServerKeyRotation struct {
+	// the new key, not used by the interface until Cutover
+	PrivateKey lib.JSONKey `validate:"required,len=44"`
+	Cutover    time.Time   `validate:"required"`
+}
+
+// StageServerKeyRotation generates a new server private key to take over
+// from the current one after grace
+func (conf *DsnetConfig) StageServerKeyRotation(grace time.Duration) error {
+	if conf.externalPrivateKey() {
+		return errors.New("the server key is loaded from PrivateKeyFile or PrivateKeyCommand, rotate it there")
+	}
+
+	if conf.KeyRotation != nil {
+		return fmt.Errorf("a server key rotation is already pending, cutover at %s. Use --commit or --abort", conf.KeyRotation.Cutover.Format(time.RFC3339))
+	}
+
+	privateKey, err := lib.GenerateJSONPrivateKey()
+	if err != nil {
+		return fmt.Errorf("%w - failed to generate private key", err)
+	}
+
+	conf.KeyRotation = &ServerKeyRotation{
+		PrivateKey: privateKey,
+		Cutover:    time.Now().Add(grace).Truncate(time.Second),
+	}
+	return nil
+}
+
+// CommitServerKeyRotation makes the staged key the server key. It returns
+// false if there is no rotation pending, or if it is not yet due and force
+// is not set.
+func (conf *DsnetConfig) CommitServerKeyRotation(now time.Time, force bool) bool {
+	if conf.KeyRotation == nil || (!force && now.Before(conf.KeyRotation.Cutover)) {
+		return false
+	}
+
+	activated := now.Truncate(time.Second)
+	conf.PrivateKey = conf.KeyRotation.PrivateKey
+	conf.PrivateKeyActivated = &activated
+	conf.KeyRotation = nil
+	return true
+}
+
+// onCurrentServerKey reports whether a peer handshake proves the peer uses
+// the current server key
+func onCurrentServerKey(conf *DsnetConfig, lastHandshake time.Time) bool {
+	if lastHandshake.IsZero() {
+		return false
+	}
+	return conf.PrivateKeyActivated == nil || lastHandshake.After(*conf.PrivateKeyActivated)
+}
+
+// RotateServerKey stages a new server key and writes every peer's config,
+// using the new server public key, to outputDir. The interface keeps the
+// current key until the grace period has passed and sync runs.
+func RotateServerKey(outputDir string, grace time.Duration, confirm bool) error {
+	conf, err := LoadConfigFileForUpdate()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	if outputDir == "" {
+		return errors.New("--output-dir is required to write the new peer configs")
+	}
+
+	if err = conf.StageServerKeyRotation(grace); err != nil {
+		return err
+	}
+
+	if !confirm {
+		if err = ConfirmOrAbort("Rotate the server key for %d peers, cutting over at %s?", len(conf.Peers), conf.KeyRotation.Cutover.Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+
+	// peer configs as they will be after cutover
+	server := GetServer(conf)
+	server.PrivateKey = conf.KeyRotation.PrivateKey
+
+	written, err := WritePeerConfigs(outputDir, server.Peers, server)
+	if err != nil {
+		return fmt.Errorf("%w - server key not rotated", err)
+	}
+
+	if err = conf.SaveChange("Stage a new server key, cutting over at " + conf.KeyRotation.Cutover.Format(time.RFC3339)); err != nil {
+		discardPeerConfigs(written, err)
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "New peer configs written to %s. %s\n", outputDir, privateKeyNotice)
+	fmt.Fprintf(os.Stderr, "The server switches to the new key at the first sync after %s, or run `dsnet rotate-server-key --commit`.\n", conf.KeyRotation.Cutover.Format(time.RFC3339))
+	return nil
+}
+
+// CommitRotateServerKey switches the interface to the staged key now,
+// without waiting for the cutover time
+func CommitRotateServerKey() error {
+	conf, err := LoadConfigFileForUpdate()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	if !conf.CommitServerKeyRotation(time.Now(), true) {
+		return errors.New("no server key rotation pending")
+	}
+
+	if err = conf.SaveChange("Cutover to the new server key"); err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	if err = WarnUnresolvedEndpoints(GetServer(conf).ConfigureDevice()); err != nil {
+		return fmt.Errorf("%w - failed to configure device", err)
+	}
+	return nil
+}
+
+// AbortRotateServerKey discards the staged key. Peer configs already
+// distributed with the new server public key will not work.
+func AbortRotateServerKey() error {
+	conf, err := LoadConfigFileForUpdate()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	if conf.KeyRotation == nil {
+		return errors.New("no server key rotation pending")
+	}
+	conf.KeyRotation = nil
+
+	if err = conf.SaveChange("Abort the server key rotation"); err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestStageServerKeyRotation(t *testing.T) {
+	conf := testDsnetConfig(t)
+	oldKey := conf.PrivateKey
+
+	if err := conf.StageServerKeyRotation(time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.KeyRotation == nil {
+		t.Fatal("expected a pending rotation")
+	}
+	if conf.KeyRotation.PrivateKey.Key == oldKey.Key {
+		t.Fatal("staged key should differ from the current key")
+	}
+	if conf.PrivateKey.Key != oldKey.Key {
+		t.Fatal("current key should be unchanged until cutover")
+	}
+
+	if err := conf.StageServerKeyRotation(time.Hour); err == nil {
+		t.Fatal("expected error staging a second rotation")
+	}
+}
+
+func TestCommitServerKeyRotation(t *testing.T) {
+	conf := testDsnetConfig(t)
+
+	if conf.CommitServerKeyRotation(time.Now(), true) {
+		t.Fatal("commit should do nothing without a pending rotation")
+	}
+
+	if err := conf.StageServerKeyRotation(time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newKey := conf.KeyRotation.PrivateKey
+
+	if conf.CommitServerKeyRotation(time.Now(), false) {
+		t.Fatal("commit should wait for cutover unless forced")
+	}
+
+	now := time.Now().Add(2 * time.Hour)
+	if !conf.CommitServerKeyRotation(now, false) {
+		t.Fatal("commit should happen once cutover has passed")
+	}
+	if conf.PrivateKey.Key != newKey.Key {
+		t.Fatal("staged key should now be the server key")
+	}
+	if conf.KeyRotation != nil || conf.PrivateKeyActivated == nil {
+		t.Fatal("rotation should be cleared and activation recorded")
+	}
+}
+
+func TestOnCurrentServerKey(t *testing.T) {
+	conf := testDsnetConfig(t)
+	now := time.Now()
+
+	if onCurrentServerKey(conf, time.Time{}) {
+		t.Fatal("a peer that never handshook is not known to use the key")
+	}
+	if !onCurrentServerKey(conf, now) {
+		t.Fatal("without a rotation any handshake uses the current key")
+	}
+
+	conf.PrivateKeyActivated = &now
+	if onCurrentServerKey(conf, now.Add(-time.Minute)) {
+		t.Fatal("handshake before activation should not count")
+	}
+	if !onCurrentServerKey(conf, now.Add(time.Minute)) {
+		t.Fatal("handshake after activation should count")
+	}
+}
+
+func TestRotateServerKeyWritesPeerConfigs(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "dsnetconfig.json")
+	outputDir := filepath.Join(tmpDir, "peers")
+	setupViperForTest(t, configPath)
+	viper.Set("output", "wg-quick")
+	t.Cleanup(func() { viper.Set("output", "") })
+
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	writeTestConfig(t, configPath, conf)
+
+	if err := RotateServerKey(outputDir, time.Hour, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if loaded.KeyRotation == nil {
+		t.Fatal("expected rotation to be saved")
+	}
+	if loaded.PrivateKey.Key != conf.PrivateKey.Key {
+		t.Fatal("current key should be unchanged")
+	}
+
+	peerConf, err := os.ReadFile(filepath.Join(outputDir, "laptop.conf"))
+	if err != nil {
+		t.Fatalf("expected peer config to be written: %v", err)
+	}
+	if !strings.Contains(string(peerConf), loaded.KeyRotation.PrivateKey.PublicKey().Key.String()) {
+		t.Fatal("peer config should contain the new server public key")
+	}
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naggie/dsnet/lib"
+)
+
+// schemaStringTypes are types marshalled as a string, with a description
+// for the schema
+var schemaStringTypes = map[reflect.Type]string{
+	reflect.TypeOf(net.IP{}):           "IPv4 or IPv6 address",
+	reflect.TypeOf(lib.JSONIPNet{}):    "CIDR, e.g. 10.0.0.0/22",
+	reflect.TypeOf(lib.IPRange{}):      "CIDR, range or single IP, e.g. 10.0.0.1-10.0.0.20",
+	reflect.TypeOf(lib.JSONKey{}):      "base64 WireGuard key, or an encrypted value (enc:v1:...)",
+	reflect.TypeOf(lib.JSONDuration{}): "duration, e.g. 2160h",
+	reflect.TypeOf(time.Time{}):        "RFC 3339 timestamp",
+}
+
+// ConfigSchema returns a JSON Schema (draft 7) describing DsnetConfig,
+// derived from the struct and its validator tags
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(DsnetConfig{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "dsnet config"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		return typeSchema(t.Elem())
+	}
+
+	if description, ok := schemaStringTypes[t]; ok {
+		schema := map[string]interface{}{"type": "string", "description": description}
+		if t == reflect.TypeOf(time.Time{}) {
+			schema["format"] = "date-time"
+		}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if jsonName := strings.Split(tag, ",")[0]; jsonName != "" {
+				name = jsonName
+			}
+		}
+
+		schema := typeSchema(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			applyValidateRule(schema, field.Type, rule, name, &required)
+		}
+		properties[name] = schema
+	}
+
+	// PrivateKey may instead come from PrivateKeyFile or PrivateKeyCommand
+	if t == reflect.TypeOf(DsnetConfig{}) {
+		for i, name := range required {
+			if name == "PrivateKey" {
+				required = append(required[:i], required[i+1:]...)
+				break
+			}
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyValidateRule translates the validator rules used in this package to
+// JSON Schema keywords
+func applyValidateRule(schema map[string]interface{}, t reflect.Type, rule, name string, required *[]string) {
+	parts := strings.SplitN(rule, "=", 2)
+	if parts[0] == "required" {
+		*required = append(*required, name)
+		return
+	}
+	if len(parts) != 2 {
+		return
+	}
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return
+	}
+
+	var min, max string
+	switch schema["type"] {
+	case "integer":
+		min, max = "minimum", "maximum"
+	case "string":
+		min, max = "minLength", "maxLength"
+	case "array":
+		min, max = "minItems", "maxItems"
+	default:
+		return
+	}
+
+	switch parts[0] {
+	case "gte":
+		schema[min] = n
+	case "lte":
+		schema[max] = n
+	case "len":
+		// keys may also be encrypted, which are longer
+		if t != reflect.TypeOf(lib.JSONKey{}) {
+			schema[min] = n
+			schema[max] = n
+		}
+	}
+}
+
+// PrintSchema writes the JSON Schema of the config file to stdout
+func PrintSchema() error {
+	_json, err := json.MarshalIndent(ConfigSchema(), "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(_json))
+	return nil
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+// SetupSecrets enables encryption of secrets at rest if a key file is
+// configured with DSNET_SECRET_KEY_FILE. Encrypted values in the config are
+// then decrypted on load, and all secrets are encrypted on save.
+func SetupSecrets() error {
+	keyFile := viper.GetString("secret_key_file")
+	if keyFile == "" {
+		lib.SetSecretBox(nil)
+		return nil
+	}
+
+	keyMaterial, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("%w - failed to read secret key file", err)
+	}
+
+	box, err := lib.NewSecretBox(keyMaterial)
+	if err != nil {
+		return fmt.Errorf("%w - %s", err, keyFile)
+	}
+
+	lib.SetSecretBox(box)
+	return nil
+}
+
+// MarshalJSON encrypts PrivateKey and MeshKey if secrets are encrypted at
+// rest, and omits PrivateKey if it is loaded from PrivateKeyFile or
+// PrivateKeyCommand
+func (conf DsnetConfig) MarshalJSON() ([]byte, error) {
+	// no methods, so no recursion
+	type dsnetConfig DsnetConfig
+
+	if !lib.SecretsEncrypted() && !conf.externalPrivateKey() {
+		return json.Marshal(dsnetConfig(conf))
+	}
+
+	var privateKey *string
+	if !conf.externalPrivateKey() {
+		sealed, err := lib.SealKey(conf.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = &sealed
+	}
+
+	var meshKey *string
+	if conf.MeshKey != nil {
+		value := conf.MeshKey.Key.String()
+		if lib.SecretsEncrypted() {
+			sealed, err := lib.SealKey(*conf.MeshKey)
+			if err != nil {
+				return nil, err
+			}
+			value = sealed
+		}
+		meshKey = &value
+	}
+
+	return json.Marshal(struct {
+		dsnetConfig
+		PrivateKey *string `json:",omitempty"`
+		MeshKey    *string `json:",omitempty"`
+	}{dsnetConfig(conf), privateKey, meshKey})
+}
+
+// MarshalJSON encrypts PresharedKey if secrets are encrypted at rest
+func (peer PeerConfig) MarshalJSON() ([]byte, error) {
+	type peerConfig PeerConfig
+
+	if !lib.SecretsEncrypted() {
+		return json.Marshal(peerConfig(peer))
+	}
+
+	presharedKey, err := lib.SealKey(peer.PresharedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		peerConfig
+		PresharedKey string
+	}{peerConfig(peer), presharedKey})
+}
+
+// MarshalJSON encrypts PrivateKey if secrets are encrypted at rest
+func (rotation ServerKeyRotation) MarshalJSON() ([]byte, error) {
+	type serverKeyRotation ServerKeyRotation
+
+	if !lib.SecretsEncrypted() {
+		return json.Marshal(serverKeyRotation(rotation))
+	}
+
+	privateKey, err := lib.SealKey(rotation.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		serverKeyRotation
+		PrivateKey string
+	}{serverKeyRotation(rotation), privateKey})
+}
+
+// EncryptSecrets rewrites the config with all secrets encrypted using the key
+// from DSNET_SECRET_KEY_FILE
+func EncryptSecrets() error {
+	if !lib.SecretsEncrypted() {
+		return errors.New("DSNET_SECRET_KEY_FILE must be set to encrypt secrets")
+	}
+
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	if err = conf.SaveChange("Encrypt secrets"); err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Secrets in %s are now encrypted. Keep %s safe; without it the config cannot be used.\n", viper.GetString("config_file"), viper.GetString("secret_key_file"))
+	return nil
+}
+
+// DecryptSecrets rewrites the config with all secrets in plaintext
+func DecryptSecrets() error {
+	conf, err := LoadConfigFile()
+	if err != nil {
+		return fmt.Errorf("%w - failed to load configuration file", err)
+	}
+
+	lib.SetSecretBox(nil)
+
+	if err = conf.SaveChange("Decrypt secrets"); err != nil {
+		return fmt.Errorf("%w - failed to save config file", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Secrets in %s are now stored in plaintext. Unset DSNET_SECRET_KEY_FILE, or they will be encrypted again on the next change.\n", viper.GetString("config_file"))
+	return nil
+}
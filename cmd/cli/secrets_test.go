@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+func setSecretKeyFileForTest(t *testing.T, passphrase string) {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "secret.key")
+	if err := os.WriteFile(keyFile, []byte(passphrase), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	viper.Set("secret_key_file", keyFile)
+	t.Cleanup(func() {
+		viper.Set("secret_key_file", "")
+		lib.SetSecretBox(nil)
+	})
+	if err := SetupSecrets(); err != nil {
+		t.Fatalf("failed to set up secrets: %v", err)
+	}
+}
+
+func TestSaveEncryptsSecrets(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+	setSecretKeyFileForTest(t, "passphrase")
+
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if err := conf.StageServerKeyRotation(0); err != nil {
+		t.Fatalf("failed to stage rotation: %v", err)
+	}
+	conf.Mesh = true
+	if err := conf.ensureMeshKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conf.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	for _, secret := range []lib.JSONKey{conf.PrivateKey, conf.Peers[0].PresharedKey, conf.KeyRotation.PrivateKey, *conf.MeshKey} {
+		if strings.Contains(string(raw), secret.Key.String()) {
+			t.Fatal("saved config should not contain plaintext secrets")
+		}
+	}
+	if !strings.Contains(string(raw), conf.Peers[0].PublicKey.Key.String()) {
+		t.Fatal("public keys should not be encrypted")
+	}
+
+	loaded, err := LoadConfigFile()
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if loaded.PrivateKey.Key != conf.PrivateKey.Key ||
+		loaded.Peers[0].PresharedKey.Key != conf.Peers[0].PresharedKey.Key ||
+		loaded.KeyRotation.PrivateKey.Key != conf.KeyRotation.PrivateKey.Key ||
+		loaded.MeshKey.Key != conf.MeshKey.Key {
+		t.Fatal("secrets did not round trip")
+	}
+
+	// without the key the config cannot be loaded
+	lib.SetSecretBox(nil)
+	if _, err := LoadConfigFile(); err == nil {
+		t.Fatal("expected error loading encrypted config without a key")
+	}
+}
+
+func TestDecryptSecrets(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+	setSecretKeyFileForTest(t, "passphrase")
+
+	conf := testDsnetConfig(t)
+	if err := conf.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if err := DecryptSecrets(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if !strings.Contains(string(raw), conf.PrivateKey.Key.String()) {
+		t.Fatal("decrypted config should contain the plaintext private key")
+	}
+}
@@ -1,14 +1,45 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
+	"os"
+
 	"github.com/naggie/dsnet/lib"
 	"github.com/spf13/viper"
 )
 
+// WarnUnresolvedEndpoints reports peers whose Endpoint failed to resolve
+// while configuring the device, returning any other error. The interface
+// keeps their last endpoint, and the next sync tries again.
+func WarnUnresolvedEndpoints(err error) error {
+	var unresolved lib.UnresolvedEndpointsError
+	if !errors.As(err, &unresolved) {
+		return err
+	}
+
+	for _, peer := range unresolved {
+		fmt.Fprintf(os.Stderr, "Warning: failed to resolve endpoint %s of %s: %s\n", peer.Endpoint, peer.Hostname, peer.Err)
+	}
+	return nil
+}
+
 func GetServer(config *DsnetConfig) *lib.Server {
 	fallbackWGBin := viper.GetString("fallback_wg_bin")
+
+	// clients connect to the primary, wherever the config is rendered
+	externalHostname := config.ExternalHostname
+	if config.isSecondary() && config.Replication.PrimaryEndpoint != "" {
+		externalHostname = config.Replication.PrimaryEndpoint
+	}
+
+	var meshKey lib.JSONKey
+	if config.MeshKey != nil {
+		meshKey = *config.MeshKey
+	}
+
 	return &lib.Server{
-		ExternalHostname:    config.ExternalHostname,
+		ExternalHostname:    externalHostname,
 		ExternalIP:          config.ExternalIP,
 		ExternalIP6:         config.ExternalIP6,
 		ListenPort:          config.ListenPort,
@@ -27,5 +58,9 @@ func GetServer(config *DsnetConfig) *lib.Server {
 		Networks:            config.Networks,
 		PersistentKeepalive: config.PersistentKeepalive,
 		MTU:                 config.MTU,
+		Mesh:                config.Mesh,
+		MeshKey:             meshKey,
+		Reserved:            config.Reserved,
+		Pools:               config.Pools,
 	}
 }
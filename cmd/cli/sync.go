@@ -1,23 +1,105 @@
 package cli
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
-func Sync() error {
-	// TODO check device settings first
-	conf, err := LoadConfigFile()
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+)
+
+// Sync applies the config to the interface. With dryRun, nothing is changed;
+// instead what would change is printed, as with Diff. If fromGit is given,
+// the config file is first replaced by that git revision of it.
+func Sync(dryRun bool, fromGit string) error {
+	var conf *DsnetConfig
+	var err error
+	if fromGit != "" {
+		conf, err = checkoutConfigRevision(fromGit, dryRun)
+	} else {
+		conf, err = LoadConfigFile()
+	}
 	if err != nil {
 		return fmt.Errorf("%w - failed to load configuration file", err)
 	}
+
+	if dryRun {
+		return syncDryRun(conf)
+	}
+
+	if err = CheckInstanceConflicts(); err != nil {
+		return err
+	}
+
+	changes := make([]string, 0)
+	// secondaries get rotated keys from the primary instead
+	if !conf.isSecondary() {
+		now := time.Now()
+		if conf.CommitServerKeyRotation(now, false) {
+			fmt.Fprintln(os.Stderr, "Cutover to the new server key")
+			changes = append(changes, "Cutover to the new server key")
+		}
+
+		expired := conf.ExpiredPresharedKeys(now)
+		rotated, err := enforcePSKMaxAge(conf, now)
+		if err != nil {
+			return err
+		}
+		if rotated {
+			changes = append(changes, "Rotate the expired preshared keys of "+strings.Join(expired, ", "))
+		}
+	}
+
+	if len(changes) > 0 {
+		if err = conf.SaveChange(strings.Join(changes, "; ")); err != nil {
+			return fmt.Errorf("%w - failed to save config file", err)
+		}
+	}
+
 	server := GetServer(conf)
 
-	err = server.ConfigureDevice()
+	err = WarnUnresolvedEndpoints(server.ConfigureDevice())
 	if err != nil {
 		return fmt.Errorf("%w - failed to sync device configuration", err)
 	}
 
-	err = server.Up() // set IPs, interface must be up by this point
-	if err != nil {
+	// set IPs, interface must be up by this point. Unresolved endpoints have
+	// been reported already.
+	err = server.Up()
+	var unresolved lib.UnresolvedEndpointsError
+	if err != nil && !errors.As(err, &unresolved) {
 		return fmt.Errorf("%w - failed to bring up the interface", err)
 	}
 	return nil
 }
+
+// SyncEvery runs sync, e.g. Sync or a sync of every instance, at the given
+// interval until killed, so that endpoint hostnames of peers with dynamic DNS
+// are re-resolved. Failures are reported and retried at the next interval
+// rather than stopping.
+func SyncEvery(interval time.Duration, sync func() error) error {
+	for {
+		if err := sync(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed, retrying in %s: %s\n", interval, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func syncDryRun(conf *DsnetConfig) error {
+	now := time.Now()
+
+	// in memory only, so the diff shows the new key
+	if !conf.isSecondary() && conf.CommitServerKeyRotation(now, false) {
+		fmt.Fprintln(os.Stderr, "Would cutover to the new server key")
+	}
+
+	if hostnames := conf.ExpiredPresharedKeys(now); len(hostnames) > 0 && viper.GetString("output_dir") != "" && !conf.isSecondary() {
+		fmt.Fprintf(os.Stderr, "Would rotate the expired preshared keys of %s\n", strings.Join(hostnames, ", "))
+	}
+
+	return diffDevice(conf)
+}
@@ -4,40 +4,98 @@ package cli
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+	"golang.org/x/sys/unix"
 )
 
 func jsonPeerToDsnetPeer(peers []PeerConfig) []lib.Peer {
 	libPeers := make([]lib.Peer, 0, len(peers))
 	for _, p := range peers {
 		libPeers = append(libPeers, lib.Peer{
-			Hostname:     p.Hostname,
-			Owner:        p.Owner,
-			Description:  p.Description,
-			IP:           p.IP,
-			IP6:          p.IP6,
-			Added:        p.Added,
-			PublicKey:    p.PublicKey,
-			PrivateKey:   p.PrivateKey,
-			PresharedKey: p.PresharedKey,
-			Networks:     p.Networks,
+			Hostname:            p.Hostname,
+			Owner:               p.Owner,
+			Description:         p.Description,
+			IP:                  p.IP,
+			IP6:                 p.IP6,
+			Added:               p.Added,
+			PublicKey:           p.PublicKey,
+			PrivateKey:          p.PrivateKey,
+			PresharedKey:        p.PresharedKey,
+			PresharedKeyCreated: p.PresharedKeyCreated,
+			Networks:            p.Networks,
+			PersistentKeepalive: p.PersistentKeepalive,
+			MTU:                 p.MTU,
+			Endpoint:            p.Endpoint,
 		})
 	}
 	return libPeers
 }
 
-func PromptString(prompt string, required bool) (string, error) {
-	reader := bufio.NewReader(os.Stdin)
+// stdin is shared by everything reading from os.Stdin, so that consecutive
+// reads from a pipe don't lose data buffered by a previous reader
+var stdin = bufio.NewReader(os.Stdin)
+
+// ErrNonInteractive is returned (wrapped) by prompts when stdin is not a
+// terminal or --non-interactive is set
+var ErrNonInteractive = errors.New("cannot prompt in non-interactive mode")
+
+// ErrAborted is returned when the user declines a confirmation prompt
+var ErrAborted = errors.New("aborted")
+
+// ExitError is an error that should end dsnet with a specific exit code
+// rather than 1, e.g. 2 when diff finds drift
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// stdinIsTerminal reports whether stdin is a terminal. A variable so that
+// tests can pretend it is.
+var stdinIsTerminal = func() bool {
+	_, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// Interactive reports whether prompts may read from stdin: it must be a
+// terminal, and --non-interactive (DSNET_NON_INTERACTIVE) must not be set
+func Interactive() bool {
+	if viper.GetBool("non_interactive") {
+		return false
+	}
+	return stdinIsTerminal()
+}
+
+// PromptString reads a line from stdin. flag names the command line option
+// that would have supplied the value, for the error in non-interactive mode.
+func PromptString(prompt, flag string, required bool) (string, error) {
+	if !Interactive() {
+		if flag == "" {
+			return "", fmt.Errorf("%w - %s required", ErrNonInteractive, prompt)
+		}
+		return "", fmt.Errorf("%w - %s required, use %s", ErrNonInteractive, prompt, flag)
+	}
+
 	var text string
 	var err error
 
 	for text == "" {
 		fmt.Fprintf(os.Stderr, "%s: ", prompt)
-		text, err = reader.ReadString('\n')
+		text, err = stdin.ReadString('\n')
 		if err != nil {
 			return "", fmt.Errorf("%w - error getting input", err)
 		}
@@ -46,23 +104,53 @@ func PromptString(prompt string, required bool) (string, error) {
 	return text, nil
 }
 
-// FIXME is it critical for this to panic, or can we cascade the errors?
-func ConfirmOrAbort(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, format+" [y/n] ", a...)
+// ConfirmOrAbort asks a yes/no question, returning ErrAborted unless the
+// answer is yes. In non-interactive mode --confirm is required instead.
+func ConfirmOrAbort(format string, a ...interface{}) error {
+	if !Interactive() {
+		return fmt.Errorf("%w - confirmation required, use --confirm", ErrNonInteractive)
+	}
 
-	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprintf(os.Stderr, format+" [y/n] ", a...)
 
-	input, err := reader.ReadString('\n')
+	input, err := stdin.ReadString('\n')
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%w - error getting input", err)
+	}
+
+	if input != "y\n" {
+		return ErrAborted
 	}
+	return nil
+}
+
+// ReadKeyFile reads a base64 key from path, or from stdin if path is "-".
+// Stdin is prompted for if interactive, otherwise a single line is read so
+// that keys can be piped in, e.g. from `wg genkey`.
+func ReadKeyFile(name, path string) (string, error) {
+	var key string
 
-	if input == "y\n" {
-		return
+	if path != "-" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%w - failed to read %s", err, name)
+		}
+		key = string(raw)
+	} else if Interactive() {
+		return PromptString(name, "", true)
 	} else {
-		fmt.Fprintf(os.Stderr, "\033[31mAborted.\033[0m\n")
-		os.Exit(1)
+		line, err := stdin.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("%w - failed to read %s from stdin", err, name)
+		}
+		key = line
+	}
+
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("no %s found in %s", name, path)
 	}
+	return key, nil
 }
 
 func BytesToSI(b uint64) string {
@@ -1,11 +1,16 @@
 package cli
 
 import (
+	"errors"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -137,3 +142,99 @@ func TestJsonPeerToDsnetPeerMultiple(t *testing.T) {
 		t.Fatalf("expected 'laptop2', got '%s'", result[1].Hostname)
 	}
 }
+
+// setNonInteractiveForTest sets --non-interactive on a pretend terminal, so
+// that the flag alone disables prompts
+func setNonInteractiveForTest(t *testing.T) {
+	t.Helper()
+	setTerminalForTest(t, true)
+	viper.Set("non_interactive", true)
+	t.Cleanup(func() { viper.Set("non_interactive", false) })
+}
+
+// setTerminalForTest pretends stdin is, or is not, a terminal
+func setTerminalForTest(t *testing.T, isTerminal bool) {
+	t.Helper()
+	original := stdinIsTerminal
+	stdinIsTerminal = func() bool { return isTerminal }
+	t.Cleanup(func() { stdinIsTerminal = original })
+}
+
+func TestInteractive(t *testing.T) {
+	setTerminalForTest(t, true)
+	if !Interactive() {
+		t.Fatal("expected interactive on a terminal")
+	}
+
+	setTerminalForTest(t, false)
+	if Interactive() {
+		t.Fatal("expected non-interactive when stdin is not a terminal")
+	}
+}
+
+func TestInteractiveDisabledByFlag(t *testing.T) {
+	setNonInteractiveForTest(t)
+
+	if Interactive() {
+		t.Fatal("expected non-interactive on a terminal when non_interactive is set")
+	}
+}
+
+func TestPromptStringNonInteractive(t *testing.T) {
+	setNonInteractiveForTest(t)
+
+	_, err := PromptString("owner", "--owner", true)
+	if !errors.Is(err, ErrNonInteractive) {
+		t.Fatalf("expected ErrNonInteractive, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "--owner") {
+		t.Fatalf("error should name the missing flag, got %q", err)
+	}
+}
+
+func TestConfirmOrAbortNonInteractive(t *testing.T) {
+	setNonInteractiveForTest(t)
+
+	err := ConfirmOrAbort("Really remove %s?", "laptop")
+	if !errors.Is(err, ErrNonInteractive) {
+		t.Fatalf("expected ErrNonInteractive, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "--confirm") {
+		t.Fatalf("error should name --confirm, got %q", err)
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	privKey, _ := wgtypes.GeneratePrivateKey()
+	path := filepath.Join(t.TempDir(), "private.key")
+	if err := os.WriteFile(path, []byte(privKey.String()+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	key, err := ReadKeyFile("private key", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key != privKey.String() {
+		t.Fatalf("expected %s, got %q", privKey.String(), key)
+	}
+}
+
+func TestReadKeyFileEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.key")
+	if err := os.WriteFile(path, []byte("\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	if _, err := ReadKeyFile("public key", path); err == nil {
+		t.Fatal("expected error for empty key file")
+	}
+}
+
+func TestReadKeyFileNotExist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.key")
+
+	if _, err := ReadKeyFile("public key", path); err == nil {
+		t.Fatal("expected error for missing key file")
+	}
+}
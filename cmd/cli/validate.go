@@ -0,0 +1,300 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator"
+	"github.com/naggie/dsnet/lib"
+	"github.com/spf13/viper"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ConfigProblem is a single problem found by ValidateConfig. Path is a JSON
+// path to the offending field, e.g. $.Peers[2].IP
+type ConfigProblem struct {
+	Path    string
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// ValidateConfig checks the config against the validator tags and the rules
+// dsnet relies on but cannot express as tags, returning every problem found
+func ValidateConfig(conf *DsnetConfig) []ConfigProblem {
+	problems := make([]ConfigProblem, 0)
+	add := func(path, format string, a ...interface{}) {
+		problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if err := validator.New().Struct(conf); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			add("$", "%s", err)
+		}
+		for _, fieldErr := range validationErrors {
+			add(validatorPath(fieldErr.Namespace()), "failed %s validation", validatorRule(fieldErr))
+		}
+	}
+
+	if conf.ExternalHostname == "" && len(conf.ExternalIP) == 0 && len(conf.ExternalIP6) == 0 {
+		add("$", "one of ExternalIP, ExternalIP6 or ExternalHostname is required")
+	}
+
+	if conf.isSecondary() && conf.Replication.Primary == "" {
+		add("$.Replication.Primary", "is required for a secondary")
+	}
+
+	if conf.Mesh && conf.MeshKey == nil {
+		add("$.MeshKey", "is required with Mesh, generate one with wg genkey")
+	} else if conf.MeshKey != nil && conf.MeshKey.Key == (wgtypes.Key{}) {
+		add("$.MeshKey", "is all zeros")
+	}
+
+	if len(conf.IP) > 0 && !conf.Network.IPNet.Contains(conf.IP) {
+		add("$.IP", "%s is outside Network %s", conf.IP, conf.Network.IPNet.String())
+	}
+	if len(conf.IP6) > 0 && !conf.Network6.IPNet.Contains(conf.IP6) {
+		add("$.IP6", "%s is outside Network6 %s", conf.IP6, conf.Network6.IPNet.String())
+	}
+
+	checkRange := func(path string, r lib.IPRange) {
+		network, field := conf.Network, "Network"
+		if r.Is6() {
+			network, field = conf.Network6, "Network6"
+		}
+		if !r.Within(network.IPNet) {
+			add(path, "%s is outside %s %s", r, field, network.IPNet.String())
+		}
+	}
+
+	for i, r := range conf.Reserved {
+		checkRange(fmt.Sprintf("$.Reserved[%d]", i), r)
+	}
+
+	// an IP in more than one pool could be allocated from either
+	poolNames := make([]string, 0, len(conf.Pools))
+	for name := range conf.Pools {
+		poolNames = append(poolNames, name)
+	}
+	sort.Strings(poolNames)
+	pooled := make([]lib.IPRange, 0)
+	pooledPaths := make([]string, 0)
+	for _, name := range poolNames {
+		if name == "" {
+			add("$.Pools", "pool names must not be empty")
+		}
+		for i, r := range conf.Pools[name] {
+			path := fmt.Sprintf("$.Pools.%s[%d]", name, i)
+			checkRange(path, r)
+			for k, other := range pooled {
+				if r.Overlaps(other) {
+					add(path, "%s overlaps %s of %s", r, other, pooledPaths[k])
+				}
+			}
+			pooled = append(pooled, r)
+			pooledPaths = append(pooledPaths, path)
+		}
+	}
+
+	// first path each unique value was seen at
+	hostnames := make(map[string]string)
+	IPs := make(map[string]string)
+	publicKeys := make(map[wgtypes.Key]string)
+	presharedKeys := make(map[wgtypes.Key]string)
+	routed := make([]lib.JSONIPNet, 0)
+	routedPaths := make([]string, 0)
+
+	if len(conf.IP) > 0 {
+		IPs[conf.IP.String()] = "$.IP"
+	}
+	if len(conf.IP6) > 0 {
+		IPs[conf.IP6.String()] = "$.IP6"
+	}
+
+	for i, peer := range conf.Peers {
+		path := fmt.Sprintf("$.Peers[%d]", i)
+
+		if first, ok := hostnames[peer.Hostname]; ok {
+			add(path+".Hostname", "duplicate of %s.Hostname", first)
+		} else {
+			hostnames[peer.Hostname] = path
+		}
+
+		if len(peer.IP) == 0 && len(peer.IP6) == 0 {
+			add(path, "peer has neither IP nor IP6")
+		}
+
+		if peer.Endpoint != "" {
+			if err := checkEndpoint(peer.Endpoint); err != nil {
+				add(path+".Endpoint", "%s", err)
+			}
+		}
+
+		for _, field := range []struct {
+			name    string
+			IP      net.IP
+			network lib.JSONIPNet
+		}{{"IP", peer.IP, conf.Network}, {"IP6", peer.IP6, conf.Network6}} {
+			if len(field.IP) == 0 {
+				continue
+			}
+			if !field.network.IPNet.Contains(field.IP) {
+				add(path+"."+field.name, "%s is outside the network %s", field.IP, field.network.IPNet.String())
+			}
+			if first, ok := IPs[field.IP.String()]; ok {
+				add(path+"."+field.name, "%s is already used by %s", field.IP, first)
+			} else {
+				IPs[field.IP.String()] = path + "." + field.name
+			}
+		}
+
+		if peer.PublicKey.Key == (wgtypes.Key{}) {
+			add(path+".PublicKey", "is all zeros")
+		} else if first, ok := publicKeys[peer.PublicKey.Key]; ok {
+			add(path+".PublicKey", "duplicate of %s.PublicKey", first)
+		} else {
+			publicKeys[peer.PublicKey.Key] = path
+		}
+
+		// adopted peers may have no preshared key, which is all zeros
+		if peer.PresharedKey.Key != (wgtypes.Key{}) {
+			if first, ok := presharedKeys[peer.PresharedKey.Key]; ok {
+				add(path+".PresharedKey", "duplicate of %s.PresharedKey", first)
+			} else {
+				presharedKeys[peer.PresharedKey.Key] = path
+			}
+		}
+
+		for j, network := range peer.Networks {
+			networkPath := fmt.Sprintf("%s.Networks[%d]", path, j)
+
+			for _, vpnNetwork := range []lib.JSONIPNet{conf.Network, conf.Network6} {
+				if networksOverlap(network, vpnNetwork) {
+					add(networkPath, "%s overlaps the VPN network %s", network.IPNet.String(), vpnNetwork.IPNet.String())
+				}
+			}
+
+			for k, other := range routed {
+				if networksOverlap(network, other) {
+					add(networkPath, "%s overlaps %s routed by %s", network.IPNet.String(), other.IPNet.String(), routedPaths[k])
+				}
+			}
+			routed = append(routed, network)
+			routedPaths = append(routedPaths, networkPath)
+		}
+	}
+
+	return problems
+}
+
+// InvalidConfigError is returned when a config fails ValidateConfig
+type InvalidConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *InvalidConfigError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		lines = append(lines, problem.String())
+	}
+	return fmt.Sprintf("config has %d problems:\n  %s", len(e.Problems), strings.Join(lines, "\n  "))
+}
+
+// Validate checks the invariants dsnet relies on, so that an inconsistent
+// config is never loaded, saved or synced to the interface. The error is an
+// *InvalidConfigError listing every problem.
+func (conf *DsnetConfig) Validate() error {
+	if problems := ValidateConfig(conf); len(problems) > 0 {
+		return &InvalidConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// validatorPath converts a validator namespace such as
+// DsnetConfig.Peers[0].Hostname to a JSON path
+func validatorPath(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return "$" + namespace[i:]
+	}
+	return "$"
+}
+
+func validatorRule(fieldErr validator.FieldError) string {
+	if fieldErr.Param() != "" {
+		return fieldErr.Tag() + "=" + fieldErr.Param()
+	}
+	return fieldErr.Tag()
+}
+
+func networksOverlap(a, b lib.JSONIPNet) bool {
+	if len(a.IPNet.IP) == 0 || len(b.IPNet.IP) == 0 {
+		return false
+	}
+	return a.IPNet.Contains(b.IPNet.IP.Mask(b.IPNet.Mask)) || b.IPNet.Contains(a.IPNet.IP.Mask(a.IPNet.Mask))
+}
+
+// Validate checks a config file, by default the configured one, printing
+// every problem found
+func Validate(configFile string) error {
+	if configFile == "" {
+		configFile = viper.GetString("config_file")
+	}
+
+	conf, err := ReadConfigFile(configFile)
+	if err != nil {
+		return fmt.Errorf("%w - failed to parse %s", err, configFile)
+	}
+
+	problems := ValidateConfig(conf)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problems", configFile, len(problems))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s is valid\n", configFile)
+	return nil
+}
+
+// ValidateInstances validates the config of every instance in config_dir,
+// then checks that they do not conflict with each other
+func ValidateInstances() error {
+	err := ForEachInstance(func(name string) error {
+		return Validate("")
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = checkInstanceConflicts(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "instances in %s do not conflict\n", viper.GetString("config_dir"))
+	return nil
+}
+
+// checkEndpoint checks that endpoint is a host:port, without resolving it
+func checkEndpoint(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return fmt.Errorf("%s is not a host:port", endpoint)
+	}
+	if host == "" {
+		return fmt.Errorf("%s has no host", endpoint)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s has an invalid port", endpoint)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/naggie/dsnet/lib"
+)
+
+func problemPaths(problems []ConfigProblem) []string {
+	paths := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		paths = append(paths, problem.Path)
+	}
+	return paths
+}
+
+func TestValidateConfigValid(t *testing.T) {
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	if problems := ValidateConfig(conf); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+func TestValidateConfigReportsAllProblems(t *testing.T) {
+	conf := testDsnetConfig(t)
+	conf.Domain = ""
+	conf.IP = net.IP{192, 168, 0, 1}
+
+	a := testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})
+	_, routed, _ := net.ParseCIDR("192.168.1.0/24")
+	a.Networks = []lib.JSONIPNet{{IPNet: *routed}}
+
+	b := testLibPeer(t, "laptop", "bob", net.IP{10, 0, 0, 2})
+	b.PublicKey = a.PublicKey
+	_, overlapping, _ := net.ParseCIDR("192.168.0.0/16")
+	_, vpnOverlap, _ := net.ParseCIDR("10.0.0.0/24")
+	b.Networks = []lib.JSONIPNet{{IPNet: *overlapping}, {IPNet: *vpnOverlap}}
+
+	c := testLibPeer(t, "outside", "carol", net.IP{10, 9, 0, 3})
+
+	conf.Peers = []PeerConfig{}
+	for _, peer := range []lib.Peer{a, b, c} {
+		conf.Peers = append(conf.Peers, PeerConfig{
+			Hostname:     peer.Hostname,
+			Owner:        peer.Owner,
+			Description:  peer.Description,
+			IP:           peer.IP,
+			IP6:          peer.IP6,
+			Added:        peer.Added,
+			Networks:     peer.Networks,
+			PublicKey:    peer.PublicKey,
+			PresharedKey: peer.PresharedKey,
+		})
+	}
+
+	expected := []string{
+		"$.Domain",
+		"$.IP",
+		"$.Peers[1].Hostname",
+		"$.Peers[1].IP",
+		"$.Peers[1].IP6",
+		"$.Peers[1].PublicKey",
+		"$.Peers[1].Networks[0]",
+		"$.Peers[1].Networks[1]",
+		"$.Peers[2].IP",
+	}
+
+	paths := problemPaths(ValidateConfig(conf))
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected problems at %v, got %v", expected, paths)
+	}
+}
+
+func TestValidateConfigNoExternalAddress(t *testing.T) {
+	conf := testDsnetConfig(t)
+	conf.ExternalHostname = ""
+
+	problems := ValidateConfig(conf)
+	if len(problems) != 1 || problems[0].Path != "$" {
+		t.Fatalf("expected a single problem at $, got %v", problems)
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "other.json")
+	conf := testDsnetConfig(t)
+	writeTestConfig(t, configPath, conf)
+
+	if err := Validate(configPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conf.ListenPort = 0
+	writeTestConfig(t, configPath, conf)
+	if err := Validate(configPath); err == nil || !strings.Contains(err.Error(), "1 problems") {
+		t.Fatalf("expected 1 problem, got %v", err)
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+
+	properties := schema["properties"].(map[string]interface{})
+	listenPort := properties["ListenPort"].(map[string]interface{})
+	if listenPort["type"] != "integer" || listenPort["minimum"] != 1 || listenPort["maximum"] != 65535 {
+		t.Fatalf("unexpected ListenPort schema %v", listenPort)
+	}
+
+	required := schema["required"].([]string)
+	for _, name := range required {
+		if name == "PrivateKey" {
+			t.Fatal("PrivateKey should not be required, it may come from PrivateKeyFile")
+		}
+	}
+
+	peer := properties["Peers"].(map[string]interface{})["items"].(map[string]interface{})
+	peerProperties := peer["properties"].(map[string]interface{})
+	if _, ok := peerProperties["PrivateKey"]; ok {
+		t.Fatal("fields omitted from JSON should not be in the schema")
+	}
+	if peerProperties["Added"].(map[string]interface{})["format"] != "date-time" {
+		t.Fatal("expected Added to be a date-time")
+	}
+}
+
+func TestValidateConfigZeroKeys(t *testing.T) {
+	conf := testDsnetConfig(t)
+	for _, hostname := range []string{"a", "b"} {
+		peer := testLibPeer(t, hostname, "alice", net.IP{10, 0, 0, byte(len(conf.Peers) + 2)})
+		if err := conf.AddPeer(peer); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+		// as adopted peers without a preshared key
+		conf.Peers[len(conf.Peers)-1].PresharedKey = lib.JSONKey{}
+	}
+	conf.Peers[1].PublicKey = lib.JSONKey{}
+
+	expected := []string{"$.Peers[1].PublicKey"}
+	if paths := problemPaths(ValidateConfig(conf)); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected problems at %v, got %v", expected, paths)
+	}
+}
+
+func TestLoadConfigFileRejectsInconsistentConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "dsnetconfig.json")
+	setupViperForTest(t, configPath)
+
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if err := conf.AddPeer(testLibPeer(t, "phone", "alice", net.IP{10, 0, 0, 3})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	// as if hand edited
+	conf.Peers[1].IP = conf.Peers[0].IP
+	writeTestConfig(t, configPath, conf)
+
+	_, err := LoadConfigFile()
+	if err == nil || !strings.Contains(err.Error(), "$.Peers[1].IP: 10.0.0.2 is already used by $.Peers[0].IP") {
+		t.Fatalf("expected duplicate IP error, got %v", err)
+	}
+
+	if err := conf.Save(); err == nil {
+		t.Fatal("expected Save to refuse an invalid config")
+	}
+}
+
+func TestAddPeerDuplicateIP(t *testing.T) {
+	conf := testDsnetConfig(t)
+	if err := conf.AddPeer(testLibPeer(t, "laptop", "alice", net.IP{10, 0, 0, 2})); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	err := conf.AddPeer(testLibPeer(t, "phone", "alice", net.IP{10, 0, 0, 2}))
+	var invalid *InvalidConfigError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected an InvalidConfigError, got %v", err)
+	}
+	if len(conf.Peers) != 1 {
+		t.Fatalf("expected the peer not to be added, got %d peers", len(conf.Peers))
+	}
+
+	// the server IP is taken too
+	if err := conf.AddPeer(testLibPeer(t, "tablet", "alice", net.IP{10, 0, 0, 1})); err == nil {
+		t.Fatal("expected an error for the server IP")
+	}
+}
+
+func TestValidateConfigEndpoint(t *testing.T) {
+	for endpoint, valid := range map[string]bool{
+		"192.0.2.1:51820":          true,
+		"[2001:db8::1]:51820":      true,
+		"router.example.com:51820": true,
+		"192.0.2.1":                false,
+		":51820":                   false,
+		"router.example.com:0":     false,
+		"router.example.com:wg":    false,
+	} {
+		conf := testDsnetConfig(t)
+		if err := conf.AddPeer(testLibPeer(t, "router", "alice", net.IP{10, 0, 0, 2})); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+		conf.Peers[0].Endpoint = endpoint
+
+		paths := problemPaths(ValidateConfig(conf))
+		if valid && len(paths) != 0 {
+			t.Fatalf("%s: expected no problems, got %v", endpoint, paths)
+		}
+		if !valid && !reflect.DeepEqual(paths, []string{"$.Peers[0].Endpoint"}) {
+			t.Fatalf("%s: expected a problem with the endpoint, got %v", endpoint, paths)
+		}
+	}
+}
+
+func TestValidateConfigMeshKey(t *testing.T) {
+	conf := testDsnetConfig(t)
+	conf.Mesh = true
+
+	if paths := problemPaths(ValidateConfig(conf)); !reflect.DeepEqual(paths, []string{"$.MeshKey"}) {
+		t.Fatalf("expected mesh mode to require a MeshKey, got %v", paths)
+	}
+
+	conf.MeshKey = &lib.JSONKey{}
+	if paths := problemPaths(ValidateConfig(conf)); !reflect.DeepEqual(paths, []string{"$.MeshKey"}) {
+		t.Fatalf("expected an all zero MeshKey to be rejected, got %v", paths)
+	}
+}
+
+func TestValidateConfigIPRanges(t *testing.T) {
+	conf := testDsnetConfig(t)
+	parse := func(s string) lib.IPRange {
+		r, err := lib.ParseIPRange(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	conf.Reserved = []lib.IPRange{parse("10.0.0.1-10.0.0.20"), parse("fd00::/120")}
+	conf.Pools = map[string][]lib.IPRange{
+		"iot":   {parse("10.0.0.16/28")},
+		"staff": {parse("10.0.0.32/27"), parse("fd00::1:0/112")},
+	}
+	if problems := ValidateConfig(conf); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+
+	conf.Reserved = append(conf.Reserved, parse("192.168.0.0/24"))
+	conf.Pools["staff"] = append(conf.Pools["staff"], parse("10.0.0.24-10.0.0.40"))
+	expected := []string{"$.Reserved[2]", "$.Pools.staff[2]", "$.Pools.staff[2]"}
+	if paths := problemPaths(ValidateConfig(conf)); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
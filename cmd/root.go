@@ -1,9 +1,9 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -20,9 +20,19 @@ var (
 	owner       string
 	description string
 	confirm     bool
+	jsonOutput  bool
 
 	// Commands.
-	rootCmd = &cobra.Command{}
+	rootCmd = &cobra.Command{
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if instance := viper.GetString("instance"); instance != "" {
+				if err := cli.UseInstance(instance); err != nil {
+					return err
+				}
+			}
+			return cli.SetupSecrets()
+		},
+	}
 
 	initCmd = &cobra.Command{
 		Use: "init",
@@ -35,22 +45,52 @@ var (
 		},
 	}
 
-	upCmd = &cobra.Command{
-		Use:   "up",
-		Short: "Create the interface, run pre/post up, sync",
+	adoptCmd = &cobra.Command{
+		Use: "adopt",
+		Short: fmt.Sprintf(
+			"Create %s from an existing wg-quick config file or WireGuard interface, keeping its keys and peers",
+			viper.GetString("config_file"),
+		),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("Too many arguments")
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			config, err := cli.LoadConfigFile()
+			from, err := cmd.Flags().GetString("from")
 			if err != nil {
-				return fmt.Errorf("%w - failure to load config file", err)
-			}
-			server := cli.GetServer(config)
-			if e := server.Up(); e != nil {
-				return e
+				return err
 			}
-			if e := utils.ShellOut(config.PostUp, "PostUp"); e != nil {
-				return e
+			fromInterface, err := cmd.Flags().GetString("from-interface")
+			if err != nil {
+				return err
 			}
-			return nil
+			return cli.Adopt(from, fromInterface, owner, description)
+		},
+	}
+
+	upCmd = &cobra.Command{
+		Use:   "up",
+		Short: "Create the interface, run pre/post up, sync",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return forInstances(cmd, func() error {
+				config, err := cli.LoadConfigFile()
+				if err != nil {
+					return fmt.Errorf("%w - failure to load config file", err)
+				}
+				if err = cli.CheckInstanceConflicts(); err != nil {
+					return err
+				}
+				server := cli.GetServer(config)
+				if e := cli.WarnUnresolvedEndpoints(server.Up()); e != nil {
+					return e
+				}
+				if e := utils.ShellOut(config.PostUp, "PostUp"); e != nil {
+					return e
+				}
+				return nil
+			})
 		},
 	}
 
@@ -58,18 +98,20 @@ var (
 		Use:   "down",
 		Short: "Destroy the interface, run pre/post down",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			config, err := cli.LoadConfigFile()
-			if err != nil {
-				return fmt.Errorf("%w - failure to load config file", err)
-			}
-			server := cli.GetServer(config)
-			if e := server.DeleteLink(); e != nil {
-				return e
-			}
-			if e := utils.ShellOut(config.PostDown, "PostDown"); e != nil {
-				return e
-			}
-			return nil
+			return forInstances(cmd, func() error {
+				config, err := cli.LoadConfigFile()
+				if err != nil {
+					return fmt.Errorf("%w - failure to load config file", err)
+				}
+				server := cli.GetServer(config)
+				if e := server.DeleteLink(); e != nil {
+					return e
+				}
+				if e := utils.ShellOut(config.PostDown, "PostDown"); e != nil {
+					return e
+				}
+				return nil
+			})
 		},
 	}
 
@@ -88,11 +130,64 @@ var (
 			if err != nil {
 				return err
 			}
-			pubKey, err := cmd.PersistentFlags().GetBool("public-key")
+			privKeyFile, err := cmd.PersistentFlags().GetString("private-key-file")
+			if err != nil {
+				return err
+			}
+			pubKey, err := cmd.PersistentFlags().GetString("public-key")
+			if err != nil {
+				return err
+			}
+			pubKeyFile, err := cmd.PersistentFlags().GetString("public-key-file")
+			if err != nil {
+				return err
+			}
+
+			// -r reads the key from stdin, prompting if interactive
+			if privKey && privKeyFile == "" {
+				privKeyFile = "-"
+			}
+
+			keepalive, err := cmd.Flags().GetInt("keepalive")
+			if err != nil {
+				return err
+			}
+			mtu, err := cmd.Flags().GetInt("mtu")
+			if err != nil {
+				return err
+			}
+			endpoint, err := cmd.Flags().GetString("endpoint")
 			if err != nil {
 				return err
 			}
-			return cli.Add(args[0], privKey, pubKey, owner, description, confirm)
+			ip, err := cmd.Flags().GetString("ip")
+			if err != nil {
+				return err
+			}
+			ip6, err := cmd.Flags().GetString("ip6")
+			if err != nil {
+				return err
+			}
+			pool, err := cmd.Flags().GetString("pool")
+			if err != nil {
+				return err
+			}
+
+			return cli.Add(args[0], cli.AddOptions{
+				Owner:          owner,
+				Description:    description,
+				PrivateKeyFile: privKeyFile,
+				PublicKey:      pubKey,
+				PublicKeyFile:  pubKeyFile,
+				Keepalive:      keepalive,
+				MTU:            mtu,
+				Endpoint:       endpoint,
+				IP:             ip,
+				IP6:            ip6,
+				Pool:           pool,
+				Confirm:        confirm,
+				JSON:           jsonOutput,
+			})
 		},
 	}
 
@@ -106,7 +201,7 @@ var (
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return cli.Regenerate(args[0], confirm)
+			return cli.Regenerate(args[0], confirm, jsonOutput)
 		},
 	}
 
@@ -114,7 +209,42 @@ var (
 		Use:   "sync",
 		Short: fmt.Sprintf("Update wireguard configuration from %s after validating", viper.GetString("config_file")),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return cli.Sync()
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
+			}
+			interval, err := cmd.Flags().GetDuration("interval")
+			if err != nil {
+				return err
+			}
+			fromGit, err := cmd.Flags().GetString("from-git")
+			if err != nil {
+				return err
+			}
+			sync := func() error {
+				return forInstances(cmd, func() error {
+					return cli.Sync(dryRun, fromGit)
+				})
+			}
+			if interval > 0 {
+				if dryRun {
+					return errors.New("--interval cannot be used with --dry-run")
+				}
+				// the revision would be reapplied every time, reverting later changes
+				if fromGit != "" {
+					return errors.New("--interval cannot be used with --from-git, sync the revision once then run sync --interval")
+				}
+				return cli.SyncEvery(interval, sync)
+			}
+			return sync()
+		},
+	}
+
+	diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Show what sync would change on the interface. Exits 2 if the interface differs from the config.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cli.Diff()
 		},
 	}
 
@@ -122,6 +252,16 @@ var (
 		Use:   "report",
 		Short: "Generate a JSON status report to stdout",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			all, err := cmd.Flags().GetBool("all")
+			if err != nil {
+				return err
+			}
+			if all {
+				if err = checkAll(); err != nil {
+					return err
+				}
+				return cli.GenerateReports()
+			}
 			return cli.GenerateReport()
 		},
 	}
@@ -142,6 +282,249 @@ var (
 		},
 	}
 
+	editCmd = &cobra.Command{
+		Use:   "edit <hostname>",
+		Short: "Change the owner, description, hostname, IPs, keepalive, MTU or endpoint of a peer, keeping its keys + sync",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// Make sure we have the hostname
+			if len(args) != 1 {
+				return errors.New("Missing hostname argument")
+			}
+
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := cli.EditOptions{
+				Owner:       owner,
+				Description: description,
+				JSON:        jsonOutput,
+			}
+
+			var err error
+			if opts.Rename, err = cmd.Flags().GetString("rename"); err != nil {
+				return err
+			}
+			if opts.IP, err = cmd.Flags().GetString("ip"); err != nil {
+				return err
+			}
+			if opts.IP6, err = cmd.Flags().GetString("ip6"); err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("keepalive") {
+				keepalive, err := cmd.Flags().GetInt("keepalive")
+				if err != nil {
+					return err
+				}
+				opts.Keepalive = &keepalive
+			}
+			if cmd.Flags().Changed("mtu") {
+				mtu, err := cmd.Flags().GetInt("mtu")
+				if err != nil {
+					return err
+				}
+				opts.MTU = &mtu
+			}
+			if cmd.Flags().Changed("endpoint") {
+				endpoint, err := cmd.Flags().GetString("endpoint")
+				if err != nil {
+					return err
+				}
+				opts.Endpoint = &endpoint
+			}
+
+			return cli.Edit(args[0], opts)
+		},
+	}
+
+	importCmd = &cobra.Command{
+		Use:   "import <peers.csv|peers.json>",
+		Short: "Add peers in bulk from CSV (hostname, owner, description[, public key[, networks]]) or JSON + sync. All or nothing.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("Missing file argument")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputDir, err := cmd.Flags().GetString("output-dir")
+			if err != nil {
+				return err
+			}
+			return cli.Import(args[0], outputDir, confirm)
+		},
+	}
+
+	rotatePSKCmd = &cobra.Command{
+		Use:   "rotate-psk [hostname]",
+		Short: "Rotate the preshared key of a peer, or all peers with --all, keeping private keys + sync",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			all, err := cmd.Flags().GetBool("all")
+			if err != nil {
+				return err
+			}
+			if all && len(args) > 0 {
+				return errors.New("Give either a hostname or --all, not both")
+			}
+			if !all && len(args) != 1 {
+				return errors.New("Missing hostname argument")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, err := cmd.Flags().GetBool("all")
+			if err != nil {
+				return err
+			}
+			outputDir, err := cmd.Flags().GetString("output-dir")
+			if err != nil {
+				return err
+			}
+			hostname := ""
+			if len(args) > 0 {
+				hostname = args[0]
+			}
+			return cli.RotatePSK(hostname, all, outputDir, confirm)
+		},
+	}
+
+	rotateServerKeyCmd = &cobra.Command{
+		Use:   "rotate-server-key",
+		Short: "Stage a new server key, writing all peer configs with the new server public key to --output-dir. sync switches key after --grace.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("Too many arguments")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			commit, err := cmd.Flags().GetBool("commit")
+			if err != nil {
+				return err
+			}
+			abort, err := cmd.Flags().GetBool("abort")
+			if err != nil {
+				return err
+			}
+			outputDir, err := cmd.Flags().GetString("output-dir")
+			if err != nil {
+				return err
+			}
+			grace, err := cmd.Flags().GetDuration("grace")
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case commit && abort:
+				return errors.New("only one of --commit or --abort may be given")
+			case commit:
+				return cli.CommitRotateServerKey()
+			case abort:
+				return cli.AbortRotateServerKey()
+			default:
+				return cli.RotateServerKey(outputDir, grace, confirm)
+			}
+		},
+	}
+
+	secretsCmd = &cobra.Command{
+		Use:   "secrets",
+		Short: "Encrypt or decrypt secrets at rest in the config file, using the key in DSNET_SECRET_KEY_FILE",
+	}
+
+	secretsEncryptCmd = &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt the server private key and preshared keys in the config file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cli.EncryptSecrets()
+		},
+	}
+
+	secretsDecryptCmd = &cobra.Command{
+		Use:   "decrypt",
+		Short: "Store the server private key and preshared keys in plaintext again",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cli.DecryptSecrets()
+		},
+	}
+
+	validateCmd = &cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check a config file, by default the current one, and list every problem found",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("Too many arguments")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, err := cmd.Flags().GetBool("all")
+			if err != nil {
+				return err
+			}
+			if all {
+				if len(args) > 0 {
+					return errors.New("--all cannot be used with a file")
+				}
+				if err = checkAll(); err != nil {
+					return err
+				}
+				return cli.ValidateInstances()
+			}
+
+			configFile := ""
+			if len(args) == 1 {
+				configFile = args[0]
+			}
+			return cli.Validate(configFile)
+		},
+	}
+
+	schemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "Print a JSON Schema of the config file, for editor integration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cli.PrintSchema()
+		},
+	}
+
+	migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the config file to the current schema version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
+			}
+			return cli.Migrate(dryRun)
+		},
+	}
+
+	convertCmd = &cobra.Command{
+		Use:   "convert",
+		Short: "Write the config file in another format (--to json/yaml/toml) beside the current one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			to, err := cmd.Flags().GetString("to")
+			if err != nil {
+				return err
+			}
+			return cli.Convert(to)
+		},
+	}
+
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export configuration for use without dsnet",
+	}
+
+	exportServerCmd = &cobra.Command{
+		Use:   "server",
+		Short: "Print the server interface and all peers as a server-side config to stdout. --output: wg-quick/nixos/networkd",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cli.ExportServer()
+		},
+	}
+
 	versionCmd = &cobra.Command{
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Printf("dsnet version %s\ncommit %s\nbuilt %s", dsnet.VERSION, dsnet.GIT_COMMIT, dsnet.BUILD_DATE)
@@ -150,11 +533,50 @@ var (
 		Short: "Print version",
 	}
 
+	replicateCmd = &cobra.Command{
+		Use:   "replicate",
+		Short: "Replicate peers and keys from a primary server to secondaries, for failover",
+	}
+
+	replicateServeCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "On the primary, serve encrypted snapshots of the config over HTTP for secondaries to pull",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listen, err := cmd.Flags().GetString("listen")
+			if err != nil {
+				return err
+			}
+			return cli.ReplicateServe(listen)
+		},
+	}
+
+	replicateExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "On the primary, write an encrypted snapshot of the config to stdout, for secondaries to pull from a shared file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cli.ReplicateExport()
+		},
+	}
+
+	replicatePullCmd = &cobra.Command{
+		Use:   "pull",
+		Short: "On a secondary, apply the latest snapshot from the primary + sync",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			interval, err := cmd.Flags().GetDuration("interval")
+			if err != nil {
+				return err
+			}
+			if interval > 0 {
+				return cli.SyncEvery(interval, cli.ReplicatePull)
+			}
+			return cli.ReplicatePull()
+		},
+	}
+
 	patchCmd = &cobra.Command{
 		Use:   "patch",
-		Short: "Pipe in JSON to patch the config file. Top level keys are replaced, not merged! Does not sync with interface. Run dsnet sync to apply.",
+		Short: "Pipe in a JSON merge patch (RFC 7396) or, with --type json, a JSON patch (RFC 6902) to change the config file. Does not sync with interface. Run dsnet sync to apply.",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			// Make sure we have the hostname
 			if len(args) > 0 {
 				return errors.New("Too many arguments")
 			}
@@ -162,36 +584,108 @@ var (
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Read the JSON from stdin
-			jsonData, err := os.ReadFile("/dev/stdin")
+			patchType, err := cmd.Flags().GetString("type")
 			if err != nil {
-				return fmt.Errorf("failed to read from stdin: %w", err)
+				return err
 			}
-			// Unmarshal the JSON into a DsnetConfig struct
-			var patch map[string]interface{}
-			if err := json.Unmarshal(jsonData, &patch); err != nil {
-				return fmt.Errorf("failed to unmarshal JSON: %w", err)
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
 			}
-			err = cli.Patch(patch)
 
+			patch, err := io.ReadAll(os.Stdin)
 			if err != nil {
-				return fmt.Errorf("failed to apply patch: %w", err)
+				return fmt.Errorf("failed to read from stdin: %w", err)
 			}
-			return nil
+
+			return cli.Patch(patch, patchType, dryRun)
 		},
 	}
 )
 
+// forInstances runs fn once, or with --all for every instance in config_dir
+func forInstances(cmd *cobra.Command, fn func() error) error {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+	if !all {
+		return fn()
+	}
+
+	if err = checkAll(); err != nil {
+		return err
+	}
+	return cli.ForEachInstance(func(name string) error {
+		return fn()
+	})
+}
+
+func checkAll() error {
+	if viper.GetString("instance") != "" {
+		return errors.New("--all cannot be used with --instance")
+	}
+	return nil
+}
+
 func init() {
 	// Flags.
-	rootCmd.PersistentFlags().String("output", "wg-quick", "config file format: vyatta/wg-quick/nixos")
+	rootCmd.PersistentFlags().String("instance", "", "name of the instance to use, configured in config_dir (/etc/dsnet) as <instance>.json")
+	rootCmd.PersistentFlags().String("output", "wg-quick", "config file format: wg-quick/vyatta/nixos/routeros/k8s-secret/compose (export server: wg-quick/nixos/networkd)")
+	rootCmd.PersistentFlags().Bool("non-interactive", false, "never prompt; fail naming the missing flag instead. Implied if stdin is not a terminal")
 	addCmd.Flags().StringVar(&owner, "owner", "", "owner of the new peer")
 	addCmd.Flags().StringVar(&description, "description", "", "description of the new peer")
 	addCmd.Flags().BoolVar(&confirm, "confirm", false, "confirm")
-	addCmd.PersistentFlags().BoolP("private-key", "r", false, "Accept user-supplied private key. If supplied, dsnet will generate a public key.")
-	addCmd.PersistentFlags().BoolP("public-key", "u", false, "Accept user-supplied public key. If supplied, the user must add the private key to the generated config (or provide it with --private-key).")
+	addCmd.PersistentFlags().BoolP("private-key", "r", false, "Accept user-supplied private key from stdin. If supplied, dsnet will generate a public key.")
+	addCmd.PersistentFlags().String("private-key-file", "", "Read user-supplied private key from a file, or - for stdin")
+	addCmd.PersistentFlags().StringP("public-key", "u", "", "Accept user-supplied public key, given as base64. If supplied, the user must add the private key to the generated config (or provide it with --private-key).")
+	addCmd.PersistentFlags().String("public-key-file", "", "Read user-supplied public key from a file, or - for stdin")
+	addCmd.Flags().Int("keepalive", 0, "PersistentKeepalive of the peer in seconds, if not the server setting")
+	addCmd.Flags().Int("mtu", 0, "MTU of the peer interface, if not the client default")
+	addCmd.Flags().String("endpoint", "", "static host:port of the peer for the server to connect to, e.g. a site-to-site router")
+	addCmd.Flags().String("ip", "", "IPv4 address of the peer, instead of allocating one. May be in a reserved range or pool.")
+	addCmd.Flags().String("ip6", "", "IPv6 address of the peer, instead of allocating one. May be in a reserved range or pool.")
+	addCmd.Flags().String("pool", "", "allocate the IPs of the peer from this pool, see Pools in CONFIG.md")
+	addCmd.Flags().BoolVar(&jsonOutput, "json", false, "output a JSON object with the peer details and rendered config")
+	adoptCmd.Flags().String("from", "", "wg-quick config file to adopt, e.g. /etc/wireguard/wg0.conf")
+	adoptCmd.Flags().String("from-interface", "", "live WireGuard interface to adopt, e.g. wg0")
+	adoptCmd.Flags().StringVar(&owner, "owner", "", "owner of the adopted peers")
+	adoptCmd.Flags().StringVar(&description, "description", "", "description of the adopted peers (default \"Adopted from <interface>\")")
+	importCmd.Flags().BoolVar(&confirm, "confirm", false, "confirm")
+	importCmd.Flags().String("output-dir", ".", "directory to write the generated peer configs to")
 	removeCmd.Flags().BoolVar(&confirm, "confirm", false, "confirm")
+	migrateCmd.Flags().Bool("dry-run", false, "show the changes as a diff without saving")
+	patchCmd.Flags().String("type", "merge", "patch type: merge (RFC 7396) or json (RFC 6902)")
+	patchCmd.Flags().Bool("dry-run", false, "show the changes as a diff and validate them without saving")
+	convertCmd.Flags().String("to", "yaml", "format to convert to: json/yaml/toml")
+	rotatePSKCmd.Flags().BoolVar(&confirm, "confirm", false, "confirm")
+	rotatePSKCmd.Flags().Bool("all", false, "rotate the preshared key of every peer")
+	rotatePSKCmd.Flags().String("output-dir", "", "directory to write the new peer configs to. Required with --all, otherwise the config is printed")
+	syncCmd.Flags().Bool("dry-run", false, "show what would change on the interface without changing it. Exits 2 if anything would change.")
+	for _, cmd := range []*cobra.Command{upCmd, downCmd, syncCmd, reportCmd, validateCmd} {
+		cmd.Flags().Bool("all", false, "operate on every instance in config_dir (/etc/dsnet)")
+	}
+	replicateServeCmd.Flags().String("listen", "", "address to listen on, e.g. :51821, instead of Replication.Listen")
+	replicatePullCmd.Flags().Duration("interval", 0, "keep running, pulling at this interval, e.g. 1m")
+	syncCmd.Flags().Duration("interval", 0, "keep running, syncing at this interval, e.g. 5m, so that peer endpoint hostnames are re-resolved")
+	syncCmd.Flags().String("output-dir", "", "directory to write configs of peers whose preshared key was rotated due to PSKMaxAge")
+	syncCmd.Flags().String("from-git", "", "replace the config file with this git revision of it, e.g. a tag or HEAD~1, then sync")
+	rotateServerKeyCmd.Flags().BoolVar(&confirm, "confirm", false, "confirm")
+	rotateServerKeyCmd.Flags().String("output-dir", "", "directory to write the new peer configs to")
+	rotateServerKeyCmd.Flags().Duration("grace", 72*time.Hour, "time until sync switches the interface to the new key")
+	rotateServerKeyCmd.Flags().Bool("commit", false, "switch to the staged key now")
+	rotateServerKeyCmd.Flags().Bool("abort", false, "discard the staged key")
 	regenerateCmd.Flags().BoolVar(&confirm, "confirm", false, "confirm")
+	editCmd.Flags().StringVar(&owner, "owner", "", "new owner of the peer")
+	editCmd.Flags().StringVar(&description, "description", "", "new description of the peer")
+	editCmd.Flags().String("rename", "", "new hostname of the peer")
+	editCmd.Flags().String("ip", "", "new IPv4 address of the peer")
+	editCmd.Flags().String("ip6", "", "new IPv6 address of the peer")
+	editCmd.Flags().Int("keepalive", 0, "PersistentKeepalive of the peer in seconds, 0 to use the server setting")
+	editCmd.Flags().Int("mtu", 0, "MTU of the peer interface, 0 to leave it to the client")
+	editCmd.Flags().String("endpoint", "", "static host:port of the peer, empty to remove it")
+	editCmd.Flags().BoolVar(&jsonOutput, "json", false, "output a JSON object with the peer details and rendered config, if it must be reissued")
+	regenerateCmd.Flags().BoolVar(&jsonOutput, "json", false, "output a JSON object with the peer details and rendered config")
 
 	// Environment variable handling.
 	viper.AutomaticEnv()
@@ -203,12 +697,31 @@ func init() {
 		os.Exit(1)
 	}
 
+	if err := viper.BindPFlag("instance", rootCmd.PersistentFlags().Lookup("instance")); err != nil {
+		fmt.Fprintf(os.Stderr, "\033[31m%s\033[0m\n", err.Error())
+		os.Exit(1)
+	}
+
+	if err := viper.BindPFlag("non_interactive", rootCmd.PersistentFlags().Lookup("non-interactive")); err != nil {
+		fmt.Fprintf(os.Stderr, "\033[31m%s\033[0m\n", err.Error())
+		os.Exit(1)
+	}
+
+	if err := viper.BindPFlag("output_dir", syncCmd.Flags().Lookup("output-dir")); err != nil {
+		fmt.Fprintf(os.Stderr, "\033[31m%s\033[0m\n", err.Error())
+		os.Exit(1)
+	}
+
 	viper.SetDefault("config_file", "/etc/dsnetconfig.json")
+	viper.SetDefault("config_dir", "/etc/dsnet")
 	viper.SetDefault("fallback_wg_bing", "wireguard-go")
 	viper.SetDefault("listen_port", 51820)
 	viper.SetDefault("MTU", 1420)
 	viper.SetDefault("interface_name", "dsnet")
 
+	// commit each change to the git repo containing the config file
+	viper.SetDefault("git", false)
+
 	// if last handshake (different from keepalive, see https://www.wireguard.com/protocol/)
 	viper.SetDefault("peer_timeout", 3*time.Minute)
 
@@ -217,15 +730,34 @@ func init() {
 
 	// Adds subcommands.
 	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(adoptCmd)
 	rootCmd.AddCommand(addCmd)
+	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(regenerateCmd)
 	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(reportCmd)
 	rootCmd.AddCommand(removeCmd)
+	rootCmd.AddCommand(rotatePSKCmd)
+	rootCmd.AddCommand(rotateServerKeyCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(schemaCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(convertCmd)
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsEncryptCmd)
+	secretsCmd.AddCommand(secretsDecryptCmd)
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportServerCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(upCmd)
 	rootCmd.AddCommand(downCmd)
 	rootCmd.AddCommand(patchCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(editCmd)
+	rootCmd.AddCommand(replicateCmd)
+	replicateCmd.AddCommand(replicateServeCmd)
+	replicateCmd.AddCommand(replicateExportCmd)
+	replicateCmd.AddCommand(replicatePullCmd)
 }
 
 func main() {
@@ -237,6 +769,11 @@ func main() {
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "\033[31m%s\033[0m\n", err.Error())
+
+		var exitErr *cli.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		os.Exit(1)
 	}
 	os.Exit(0)
@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DeviceChange is a difference between the server config and the live
+// interface, i.e. something ConfigureDevice or CreateLink would change
+type DeviceChange struct {
+	// + to be added, - to be removed, ~ to be changed
+	Op          byte
+	Description string
+}
+
+func (c DeviceChange) String() string {
+	return string(c.Op) + " " + c.Description
+}
+
+// LinkState is the state of the network interface relevant to CreateLink
+type LinkState struct {
+	Addrs []net.IPNet
+	MTU   int
+	Up    bool
+}
+
+// ReadLinkState reads the interface state with netlink. It returns nil
+// without an error if the interface does not exist.
+func ReadLinkState(name string) (*LinkState, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get interface %s: %v", name, err)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses for interface: %v", err)
+	}
+
+	state := &LinkState{
+		MTU: link.Attrs().MTU,
+		Up:  link.Attrs().Flags&net.FlagUp != 0,
+	}
+	for _, addr := range addrs {
+		state.Addrs = append(state.Addrs, *addr.IPNet)
+	}
+	return state, nil
+}
+
+// Diff reads the WireGuard device and interface and returns what sync would
+// change to match the config
+func (s *Server) Diff() ([]DeviceChange, error) {
+	link, err := ReadLinkState(s.InterfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if link == nil {
+		return []DeviceChange{{'+', "interface " + s.InterfaceName}}, nil
+	}
+
+	wg, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer wg.Close()
+
+	dev, err := wg.Device(s.InterfaceName)
+	if os.IsNotExist(err) {
+		dev = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not retrieve device '%s' (%v)", s.InterfaceName, err)
+	}
+
+	return append(s.DiffDevice(dev), s.DiffLink(link)...), nil
+}
+
+// DiffDevice compares the WireGuard device with the config, as
+// ConfigureDevice would apply it. dev is nil if the interface is not a
+// WireGuard device.
+func (s *Server) DiffDevice(dev *wgtypes.Device) []DeviceChange {
+	changes := make([]DeviceChange, 0)
+
+	if dev == nil {
+		return append(changes, DeviceChange{'~', s.InterfaceName + " is not a WireGuard interface"})
+	}
+
+	if dev.PrivateKey != s.PrivateKey.Key {
+		changes = append(changes, DeviceChange{'~', "private key differs, public key " + dev.PublicKey.String() + " -> " + s.PrivateKey.PublicKey().Key.String()})
+	}
+
+	if dev.ListenPort != s.ListenPort {
+		changes = append(changes, DeviceChange{'~', fmt.Sprintf("listen port %d -> %d", dev.ListenPort, s.ListenPort)})
+	}
+
+	devPeers := make(map[wgtypes.Key]wgtypes.Peer)
+	for _, peer := range dev.Peers {
+		devPeers[peer.PublicKey] = peer
+	}
+
+	known := make(map[wgtypes.Key]bool)
+	for _, peer := range s.Peers {
+		known[peer.PublicKey.Key] = true
+		allowedIPs := formatIPNets(peer.GetAllowedIPs())
+
+		devPeer, ok := devPeers[peer.PublicKey.Key]
+		if !ok {
+			changes = append(changes, DeviceChange{'+', fmt.Sprintf("peer %s (%s) AllowedIPs %s", peer.Hostname, peer.PublicKey.Key, allowedIPs)})
+			continue
+		}
+
+		if devAllowedIPs := formatIPNets(devPeer.AllowedIPs); devAllowedIPs != allowedIPs {
+			changes = append(changes, DeviceChange{'~', fmt.Sprintf("peer %s AllowedIPs %s -> %s", peer.Hostname, devAllowedIPs, allowedIPs)})
+		}
+
+		if devPeer.PresharedKey != peer.PresharedKey.Key {
+			changes = append(changes, DeviceChange{'~', fmt.Sprintf("peer %s preshared key differs", peer.Hostname)})
+		}
+
+		if peer.Endpoint != "" {
+			endpoint, err := peer.ResolveEndpoint()
+			devEndpoint := "none"
+			if devPeer.Endpoint != nil {
+				devEndpoint = devPeer.Endpoint.String()
+			}
+
+			if err != nil {
+				changes = append(changes, DeviceChange{'~', fmt.Sprintf("peer %s endpoint %s does not resolve: %s", peer.Hostname, peer.Endpoint, err)})
+			} else if endpoint.String() != devEndpoint {
+				changes = append(changes, DeviceChange{'~', fmt.Sprintf("peer %s endpoint %s -> %s", peer.Hostname, devEndpoint, endpoint)})
+			}
+		}
+
+		if keepalive := s.ServerKeepalive(peer); devPeer.PersistentKeepaliveInterval != keepalive {
+			changes = append(changes, DeviceChange{'~', fmt.Sprintf("peer %s keepalive %s -> %s", peer.Hostname, devPeer.PersistentKeepaliveInterval, keepalive)})
+		}
+	}
+
+	for _, peer := range dev.Peers {
+		if !known[peer.PublicKey] {
+			changes = append(changes, DeviceChange{'-', fmt.Sprintf("peer %s AllowedIPs %s, not in config", peer.PublicKey, formatIPNets(peer.AllowedIPs))})
+		}
+	}
+
+	return changes
+}
+
+// DiffLink compares the interface with the config, as CreateLink would
+// apply it
+func (s *Server) DiffLink(link *LinkState) []DeviceChange {
+	changes := make([]DeviceChange, 0)
+
+	want := make([]net.IPNet, 0, 2)
+	if len(s.IP) > 0 {
+		want = append(want, net.IPNet{IP: s.IP, Mask: s.Network.IPNet.Mask})
+	}
+	if len(s.IP6) > 0 {
+		want = append(want, net.IPNet{IP: s.IP6, Mask: s.Network6.IPNet.Mask})
+	}
+
+	have := make(map[string]bool)
+	for _, addr := range link.Addrs {
+		have[addr.String()] = true
+	}
+
+	wanted := make(map[string]bool)
+	for _, addr := range want {
+		wanted[addr.String()] = true
+		if !have[addr.String()] {
+			changes = append(changes, DeviceChange{'+', "address " + addr.String()})
+		}
+	}
+
+	// CreateLink only removes addresses of the families it sets
+	for _, addr := range link.Addrs {
+		managed := len(s.IP6) > 0
+		if addr.IP.To4() != nil {
+			managed = len(s.IP) > 0
+		}
+		if managed && !wanted[addr.String()] {
+			changes = append(changes, DeviceChange{'-', "address " + addr.String()})
+		}
+	}
+
+	if s.MTU > 0 && link.MTU != s.MTU {
+		changes = append(changes, DeviceChange{'~', fmt.Sprintf("MTU %d -> %d", link.MTU, s.MTU)})
+	}
+
+	if !link.Up {
+		changes = append(changes, DeviceChange{'~', "interface down -> up"})
+	}
+
+	return changes
+}
+
+// formatIPNets formats networks as a sorted, comma separated list so they
+// can be compared regardless of order
+func formatIPNets(ipNets []net.IPNet) string {
+	strs := make([]string, 0, len(ipNets))
+	for _, ipNet := range ipNets {
+		strs = append(strs, ipNet.String())
+	}
+	sort.Strings(strs)
+	return strings.Join(strs, ", ")
+}
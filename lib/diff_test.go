@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func changeStrings(changes []DeviceChange) []string {
+	strs := make([]string, 0, len(changes))
+	for _, change := range changes {
+		strs = append(strs, change.String())
+	}
+	return strs
+}
+
+// testDevice returns a device matching the server config
+func testDevice(s *Server) *wgtypes.Device {
+	dev := &wgtypes.Device{
+		Name:       s.InterfaceName,
+		PrivateKey: s.PrivateKey.Key,
+		PublicKey:  s.PrivateKey.PublicKey().Key,
+		ListenPort: s.ListenPort,
+	}
+	for _, peer := range s.Peers {
+		dev.Peers = append(dev.Peers, wgtypes.Peer{
+			PublicKey:    peer.PublicKey.Key,
+			PresharedKey: peer.PresharedKey.Key,
+			AllowedIPs:   peer.GetAllowedIPs(),
+		})
+	}
+	return dev
+}
+
+func TestDiffDeviceInSync(t *testing.T) {
+	s := testServer(t)
+	for _, hostname := range []string{"laptop", "phone"} {
+		peer, err := NewPeer(s, "", "", "alice", hostname, "test")
+		if err != nil {
+			t.Fatalf("failed to create peer: %v", err)
+		}
+		s.Peers = append(s.Peers, peer)
+	}
+
+	dev := testDevice(s)
+	// order of AllowedIPs does not matter
+	allowedIPs := dev.Peers[0].AllowedIPs
+	allowedIPs[0], allowedIPs[1] = allowedIPs[1], allowedIPs[0]
+
+	if changes := s.DiffDevice(dev); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changeStrings(changes))
+	}
+}
+
+func TestDiffDevice(t *testing.T) {
+	s := testServer(t)
+	laptop, err := NewPeer(s, "", "", "alice", "laptop", "test")
+	if err != nil {
+		t.Fatalf("failed to create peer: %v", err)
+	}
+	s.Peers = append(s.Peers, laptop)
+	phone, err := NewPeer(s, "", "", "bob", "phone", "test")
+	if err != nil {
+		t.Fatalf("failed to create peer: %v", err)
+	}
+	s.Peers = append(s.Peers, phone)
+	dev := testDevice(s)
+
+	// as if changed with wg set
+	dev.ListenPort = 51821
+	_, routed, _ := net.ParseCIDR("192.168.1.0/24")
+	dev.Peers[0].AllowedIPs = append(dev.Peers[0].AllowedIPs, *routed)
+	dev.Peers[0].PresharedKey = wgtypes.Key{}
+	stranger, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	dev.Peers[1].PublicKey = stranger.PublicKey()
+
+	expected := []string{
+		"~ listen port 51821 -> 51820",
+		"~ peer laptop AllowedIPs 10.0.0.2/32, 192.168.1.0/24, " + laptop.IP6.String() + "/128 -> 10.0.0.2/32, " + laptop.IP6.String() + "/128",
+		"~ peer laptop preshared key differs",
+		"+ peer phone (" + phone.PublicKey.Key.String() + ") AllowedIPs 10.0.0.3/32, " + phone.IP6.String() + "/128",
+		"- peer " + stranger.PublicKey().String() + " AllowedIPs 10.0.0.3/32, " + phone.IP6.String() + "/128, not in config",
+	}
+
+	if changes := changeStrings(s.DiffDevice(dev)); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %q, got %q", expected, changes)
+	}
+}
+
+func TestDiffDevicePrivateKey(t *testing.T) {
+	s := testServer(t)
+	dev := testDevice(s)
+
+	other, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	dev.PrivateKey = other
+	dev.PublicKey = other.PublicKey()
+
+	changes := s.DiffDevice(dev)
+	if len(changes) != 1 || changes[0].Op != '~' {
+		t.Fatalf("expected the private key to differ, got %v", changeStrings(changes))
+	}
+}
+
+func TestDiffLink(t *testing.T) {
+	s := testServer(t)
+	s.MTU = 1420
+
+	link := &LinkState{
+		Addrs: []net.IPNet{
+			{IP: net.IP{10, 0, 0, 1}, Mask: net.IPMask{255, 255, 252, 0}},
+			{IP: net.IP{192, 168, 9, 1}, Mask: net.IPMask{255, 255, 255, 0}},
+			{IP: net.ParseIP("fd00::99"), Mask: s.Network6.IPNet.Mask},
+		},
+		MTU: 1500,
+		Up:  false,
+	}
+
+	expected := []string{
+		"+ address fd00::1/64",
+		"- address 192.168.9.1/24",
+		"- address fd00::99/64",
+		"~ MTU 1500 -> 1420",
+		"~ interface down -> up",
+	}
+
+	if changes := changeStrings(s.DiffLink(link)); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %q, got %q", expected, changes)
+	}
+
+	link.Addrs = []net.IPNet{
+		{IP: net.IP{10, 0, 0, 1}, Mask: net.IPMask{255, 255, 252, 0}},
+		{IP: s.IP6, Mask: s.Network6.IPNet.Mask},
+	}
+	link.MTU = 1420
+	link.Up = true
+	if changes := s.DiffLink(link); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changeStrings(changes))
+	}
+
+	// addresses of a family without a server IP are left alone by sync
+	s.IP6 = nil
+	link.Addrs = append(link.Addrs, net.IPNet{IP: net.ParseIP("fd00::99"), Mask: s.Network6.IPNet.Mask})
+	if changes := s.DiffLink(link); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changeStrings(changes))
+	}
+}
+
+func TestDiffDeviceEndpoint(t *testing.T) {
+	s := testServer(t)
+	peer, err := NewPeer(s, "", "", "alice", "router", "test")
+	if err != nil {
+		t.Fatalf("failed to create peer: %v", err)
+	}
+	peer.Endpoint = "192.0.2.1:51820"
+	s.Peers = append(s.Peers, peer)
+	dev := testDevice(s)
+
+	expected := []string{"~ peer router endpoint none -> 192.0.2.1:51820"}
+	if changes := changeStrings(s.DiffDevice(dev)); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+
+	dev.Peers[0].Endpoint = &net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 51820}
+	if changes := s.DiffDevice(dev); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changeStrings(changes))
+	}
+}
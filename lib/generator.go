@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 	"text/template"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 func getPeerConfTplString(peerType PeerType) (string, error) {
@@ -17,11 +20,28 @@ func getPeerConfTplString(peerType PeerType) (string, error) {
 		return nixosPeerConf, nil
 	case RouterOS:
 		return routerosPeerConf, nil
+	case K8sSecret:
+		return k8sSecretPeerConf, nil
+	case DockerCompose:
+		return composePeerConf, nil
 	default:
 		return "", fmt.Errorf("unrecognized peer type")
 	}
 }
 
+func getServerConfTplString(peerType PeerType) (string, error) {
+	switch peerType {
+	case WGQuick:
+		return wgQuickServerConf, nil
+	case NixOS:
+		return nixosServerConf, nil
+	case Networkd:
+		return networkdServerConf, nil
+	default:
+		return "", fmt.Errorf("unrecognized server config type")
+	}
+}
+
 func (p *Peer) getIfName() string {
 	// derive deterministic interface name
 	wgifSeed := 0
@@ -35,6 +55,71 @@ func (p *Peer) getIfName() string {
 	return fmt.Sprintf("wg%d", wgifSeed%999)
 }
 
+// getResourceName derives a name valid as a Kubernetes object name (RFC 1123
+// label) or compose config name from the peer hostname
+func (p *Peer) getResourceName() string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '-'
+		}
+	}, p.Hostname)
+
+	name = strings.Trim("dsnet-"+name, "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+// indent prefixes every non-empty line of s with n spaces, for embedding a
+// rendered config in a YAML block scalar
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = pad + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GetEndpointHost returns the host peers should use to reach the server
+func (s *Server) GetEndpointHost() (string, error) {
+	// See DsnetConfig type for explanation
+	if s.ExternalHostname != "" {
+		return s.ExternalHostname, nil
+	} else if len(s.ExternalIP) > 0 {
+		return s.ExternalIP.String(), nil
+	} else if len(s.ExternalIP6) > 0 {
+		return s.ExternalIP6.String(), nil
+	}
+	return "", errors.New("server config requires at least one of ExternalIP, ExternalIP6 or ExternalHostname")
+}
+
+// GetClientAllowedIPs returns the networks peers should route via the server
+func (s *Server) GetClientAllowedIPs() []JSONIPNet {
+	allowedIPs := make([]JSONIPNet, 0, len(s.Networks)+2)
+	if len(s.Network.IPNet.IP) > 0 {
+		allowedIPs = append(allowedIPs, s.Network)
+	}
+	if len(s.Network6.IPNet.IP) > 0 {
+		allowedIPs = append(allowedIPs, s.Network6)
+	}
+	return append(allowedIPs, s.Networks...)
+}
+
+// PrivateKeyPlaceholder stands in for the private key of a peer in its
+// rendered config when dsnet does not have it. Peer private keys are not
+// stored, so a reissued config must keep the key of the existing one, and a
+// peer added with only its public key has its own.
+const PrivateKeyPlaceholder = "<existing private key of this peer>"
+
 // GetWGPeerTemplate returns a template string to be used when
 // configuring a peer
 func GetWGPeerTemplate(peer Peer, peerType PeerType, server Server) (*bytes.Buffer, error) {
@@ -43,35 +128,54 @@ func GetWGPeerTemplate(peer Peer, peerType PeerType, server Server) (*bytes.Buff
 		return nil, fmt.Errorf("failed to get wg template: %s", err)
 	}
 
-	// See DsnetConfig type for explanation
-	var endpoint string
-
-	if server.ExternalHostname != "" {
-		endpoint = server.ExternalHostname
-	} else if len(server.ExternalIP) > 0 {
-		endpoint = server.ExternalIP.String()
-	} else if len(server.ExternalIP6) > 0 {
-		endpoint = server.ExternalIP6.String()
-	} else {
-		return nil, errors.New("server config requires at least one of ExternalIP, ExternalIP6 or ExternalHostname")
+	endpoint, err := server.GetEndpointHost()
+	if err != nil {
+		return nil, err
 	}
 
-	t := template.Must(template.New("peerConf").Parse(peerConf))
+	t := template.Must(template.New("peerConf").Funcs(template.FuncMap{
+		"indent": indent,
+	}).Parse(peerConf))
 	cidrSize, _ := server.Network.IPNet.Mask.Size()
 	cidrSize6, _ := server.Network6.IPNet.Mask.Size()
 
-	var templateBuff bytes.Buffer
-	err = t.Execute(&templateBuff, map[string]interface{}{
-		"Peer":      peer,
-		"Server":    server,
-		"CidrSize":  cidrSize,
-		"CidrSize6": cidrSize6,
+	// an all-zero key would look valid but break the tunnel
+	privateKey := PrivateKeyPlaceholder
+	if peer.PrivateKey.Key != (wgtypes.Key{}) {
+		privateKey = peer.PrivateKey.Key.String()
+	}
+
+	data := map[string]interface{}{
+		"Peer":       peer,
+		"Server":     server,
+		"PrivateKey": privateKey,
+		"CidrSize":   cidrSize,
+		"CidrSize6":  cidrSize6,
 		// vyatta requires an interface in range/format wg0-wg999
 		// deterministically choosing one in this range will probably allow use
 		// of the config without a colliding interface name
-		"Wgif":     peer.getIfName(),
-		"Endpoint": endpoint,
-	})
+		"Wgif":         peer.getIfName(),
+		"Endpoint":     endpoint,
+		"ResourceName": peer.getResourceName(),
+		"Keepalive":    peer.GetPersistentKeepalive(server),
+		"MTU":          peer.MTU,
+		"MeshPeers":    server.GetMeshPeers(peer),
+		// a peer with an Endpoint must listen on its port, for the server
+		// and mesh peers to connect to
+		"ListenPort": peer.endpointPort(),
+	}
+
+	// container formats embed the wg-quick config verbatim
+	if peerType == K8sSecret || peerType == DockerCompose {
+		wgQuickConf, err := GetWGPeerTemplate(peer, WGQuick, server)
+		if err != nil {
+			return nil, err
+		}
+		data["WGQuickConf"] = wgQuickConf.String()
+	}
+
+	var templateBuff bytes.Buffer
+	err = t.Execute(&templateBuff, data)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +192,72 @@ func AsciiPeerConfig(peer Peer, peerType string, server Server) (*bytes.Buffer,
 		return GetWGPeerTemplate(peer, NixOS, server)
 	case "routeros":
 		return GetWGPeerTemplate(peer, RouterOS, server)
+	case "k8s-secret":
+		return GetWGPeerTemplate(peer, K8sSecret, server)
+	case "compose":
+		return GetWGPeerTemplate(peer, DockerCompose, server)
 	default:
 		return nil, errors.New("unrecognised OUTPUT type")
 	}
 }
+
+// serverTemplatePeer is a peer as seen from the server side
+type serverTemplatePeer struct {
+	Peer       Peer
+	AllowedIPs []string
+	// keepalive sent by the server in seconds, if any
+	Keepalive int
+}
+
+// GetWGServerTemplate renders the server side of the network, the server
+// interface and a peer section for each peer, as used by `dsnet export`
+func GetWGServerTemplate(server Server, peerType PeerType) (*bytes.Buffer, error) {
+	serverConf, err := getServerConfTplString(peerType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wg template: %s", err)
+	}
+
+	t := template.Must(template.New("serverConf").Parse(serverConf))
+	cidrSize, _ := server.Network.IPNet.Mask.Size()
+	cidrSize6, _ := server.Network6.IPNet.Mask.Size()
+
+	peers := make([]serverTemplatePeer, 0, len(server.Peers))
+	for _, peer := range server.Peers {
+		allowedIPs := make([]string, 0)
+		for _, allowedIP := range peer.GetAllowedIPs() {
+			allowedIPs = append(allowedIPs, allowedIP.String())
+		}
+		peers = append(peers, serverTemplatePeer{
+			Peer:       peer,
+			AllowedIPs: allowedIPs,
+			Keepalive:  int(server.ServerKeepalive(peer).Seconds()),
+		})
+	}
+
+	data := map[string]interface{}{
+		"Server":    server,
+		"Peers":     peers,
+		"CidrSize":  cidrSize,
+		"CidrSize6": cidrSize6,
+	}
+
+	var templateBuff bytes.Buffer
+	err = t.Execute(&templateBuff, data)
+	if err != nil {
+		return nil, err
+	}
+	return &templateBuff, nil
+}
+
+func AsciiServerConfig(server Server, outputType string) (*bytes.Buffer, error) {
+	switch outputType {
+	case "wg-quick":
+		return GetWGServerTemplate(server, WGQuick)
+	case "nixos":
+		return GetWGServerTemplate(server, NixOS)
+	case "networkd":
+		return GetWGServerTemplate(server, Networkd)
+	default:
+		return nil, errors.New("unrecognised OUTPUT type for server config, must be wg-quick, nixos or networkd")
+	}
+}
@@ -160,6 +160,67 @@ func TestGetWGPeerTemplateRouterOS(t *testing.T) {
 	}
 }
 
+func TestGetWGPeerTemplateK8sSecret(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+	peer.Hostname = "Build_Runner.01"
+
+	buf, err := GetWGPeerTemplate(peer, K8sSecret, server)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+
+	if !strings.Contains(output, "name: dsnet-build-runner-01\n") {
+		t.Fatalf("secret name should be derived from hostname, got:\n%s", output)
+	}
+	if !strings.Contains(output, "  wg0.conf: |\n    [Interface]\n") {
+		t.Fatal("secret should embed the wg-quick config as an indented block")
+	}
+	if !strings.Contains(output, "    PrivateKey="+peer.PrivateKey.Key.String()) {
+		t.Fatal("secret should contain the peer private key")
+	}
+	if !strings.Contains(output, "    Endpoint=vpn.example.com:51820") {
+		t.Fatal("secret should contain the server endpoint")
+	}
+}
+
+func TestGetWGPeerTemplateDockerCompose(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+
+	buf, err := GetWGPeerTemplate(peer, DockerCompose, server)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+
+	if !strings.Contains(output, "target: /config/wg_confs/wg0.conf") {
+		t.Fatal("compose snippet should mount the config for linuxserver/wireguard")
+	}
+	if !strings.Contains(output, "    content: |\n      [Interface]\n") {
+		t.Fatal("compose snippet should embed the wg-quick config as an indented block")
+	}
+	if strings.Contains(output, "\n      \n") {
+		t.Fatal("blank lines in the embedded config should not carry trailing whitespace")
+	}
+}
+
+func TestPeerGetResourceName(t *testing.T) {
+	tests := map[string]string{
+		"laptop":                "dsnet-laptop",
+		"Alice's Laptop":        "dsnet-alice-s-laptop",
+		"--edge--":              "dsnet---edge",
+		strings.Repeat("a", 80): "dsnet-" + strings.Repeat("a", 57),
+	}
+	for hostname, expected := range tests {
+		p := Peer{Hostname: hostname}
+		if name := p.getResourceName(); name != expected {
+			t.Fatalf("expected %q for hostname %q, got %q", expected, hostname, name)
+		}
+	}
+}
+
 func TestGetWGPeerTemplateInvalidType(t *testing.T) {
 	peer, server := testPeerAndServer(t)
 
@@ -179,6 +240,8 @@ func TestAsciiPeerConfig(t *testing.T) {
 		{"vyatta", "vyatta", "configure"},
 		{"nixos", "nixos", "networking.wireguard"},
 		{"routeros", "routeros", "/interface wireguard"},
+		{"k8s-secret", "k8s-secret", "kind: Secret"},
+		{"compose", "compose", "lscr.io/linuxserver/wireguard"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -302,3 +365,271 @@ func TestGetWGPeerTemplateNoDNS(t *testing.T) {
 		t.Fatal("should not contain DNS line when DNS is nil")
 	}
 }
+
+func TestGetClientAllowedIPs(t *testing.T) {
+	_, server := testPeerAndServer(t)
+	_, extraNet, _ := net.ParseCIDR("192.168.1.0/24")
+	server.Networks = []JSONIPNet{{IPNet: *extraNet}}
+
+	allowedIPs := server.GetClientAllowedIPs()
+	if len(allowedIPs) != 3 {
+		t.Fatalf("expected 3 allowed IPs, got %d", len(allowedIPs))
+	}
+	if allowedIPs[2].String() != "192.168.1.0/24" {
+		t.Fatalf("expected extra network last, got %s", allowedIPs[2].String())
+	}
+
+	server.Network6 = JSONIPNet{}
+	if len(server.GetClientAllowedIPs()) != 2 {
+		t.Fatal("unset Network6 should not be included")
+	}
+}
+
+func TestGetWGServerTemplateWGQuickRoundTrip(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+	_, extraNet, _ := net.ParseCIDR("192.168.1.0/24")
+	peer.Networks = []JSONIPNet{{IPNet: *extraNet}}
+	server.Peers = []Peer{peer}
+	server.MTU = 1420
+	server.PostUp = "iptables -A FORWARD -i dsnet -j ACCEPT"
+
+	buf, err := GetWGServerTemplate(server, WGQuick)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the export must be readable by wg-quick, and by dsnet adopt
+	conf, err := ParseWGQuickConfig(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("failed to parse exported config: %v\n%s", err, buf.String())
+	}
+
+	if conf.Interface.PrivateKey != server.PrivateKey.Key {
+		t.Fatal("exported config should contain server private key")
+	}
+	if conf.Interface.ListenPort != 51820 || conf.Interface.MTU != 1420 {
+		t.Fatalf("unexpected ListenPort/MTU %d/%d", conf.Interface.ListenPort, conf.Interface.MTU)
+	}
+	if len(conf.Interface.Addresses) != 2 || conf.Interface.Addresses[0].String() != "10.0.0.1/22" {
+		t.Fatalf("unexpected addresses %v", conf.Interface.Addresses)
+	}
+	if len(conf.Interface.PostUp) != 1 || conf.Interface.PostUp[0] != server.PostUp {
+		t.Fatalf("unexpected PostUp %v", conf.Interface.PostUp)
+	}
+
+	if len(conf.Peers) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(conf.Peers))
+	}
+	exported := conf.Peers[0]
+	if exported.Name != "test-peer" {
+		t.Fatalf("expected peer name test-peer, got %q", exported.Name)
+	}
+	if exported.PublicKey != peer.PublicKey.Key || exported.PresharedKey != peer.PresharedKey.Key {
+		t.Fatal("exported peer keys do not match")
+	}
+	if len(exported.AllowedIPs) != 3 ||
+		exported.AllowedIPs[0].String() != "10.0.0.2/32" ||
+		exported.AllowedIPs[1].String() != "fd00::2/128" ||
+		exported.AllowedIPs[2].String() != "192.168.1.0/24" {
+		t.Fatalf("unexpected AllowedIPs %v", exported.AllowedIPs)
+	}
+}
+
+func TestGetWGServerTemplateNixOS(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+	server.Peers = []Peer{peer}
+
+	buf, err := GetWGServerTemplate(server, NixOS)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "listenPort = 51820;") {
+		t.Fatal("nixos config should contain listen port")
+	}
+	if !strings.Contains(output, `"10.0.0.2/32"`) {
+		t.Fatal("nixos config should contain peer allowed IP")
+	}
+	if strings.Contains(output, "postSetup") {
+		t.Fatal("nixos config should not contain postSetup when PostUp is empty")
+	}
+}
+
+func TestGetWGServerTemplateNetworkd(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+	server.Peers = []Peer{peer}
+	server.PostUp = "echo up"
+
+	buf, err := GetWGServerTemplate(server, Networkd)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	for _, expected := range []string{"[NetDev]", "[WireGuardPeer]", "AllowedIPs=10.0.0.2/32", "Address=10.0.0.1/22", "# PostUp: echo up"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("networkd config should contain %q", expected)
+		}
+	}
+}
+
+func TestGetWGServerTemplateMTU(t *testing.T) {
+	_, server := testPeerAndServer(t)
+
+	for peerType, expected := range map[PeerType]string{
+		WGQuick:  "\nMTU=1420\n",
+		NixOS:    "\n    mtu = 1420;\n",
+		Networkd: "\nMTUBytes=1420\n",
+	} {
+		server.MTU = 0
+		buf, err := GetWGServerTemplate(server, peerType)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(strings.ToLower(buf.String()), "mtu") {
+			t.Fatalf("expected no MTU when 0, got:\n%s", buf)
+		}
+
+		server.MTU = 1420
+		if buf, err = GetWGServerTemplate(server, peerType); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("expected %q in:\n%s", expected, buf)
+		}
+	}
+}
+
+func TestAsciiServerConfigInvalid(t *testing.T) {
+	_, server := testPeerAndServer(t)
+
+	if _, err := AsciiServerConfig(server, "vyatta"); err == nil {
+		t.Fatal("expected error for output type not supported for server config")
+	}
+}
+
+func TestGetWGPeerTemplateKeepaliveAndMTU(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+
+	for _, tc := range []struct {
+		peerType  PeerType
+		keepalive string
+		mtu       string
+		anyMTU    string
+	}{
+		{WGQuick, "PersistentKeepalive=25\n", "MTU=1280\n", "MTU="},
+		{Vyatta, "persistent-keepalive 25\n", "wg0 mtu 1280\n", " mtu "},
+		{NixOS, "persistentKeepalive = 25;", "mtu = 1280;", "mtu ="},
+		{RouterOS, "persistent-keepalive=25s", "mtu=1280 ", "mtu="},
+		{K8sSecret, "PersistentKeepalive=25\n", "MTU=1280\n", "MTU="},
+	} {
+		// server setting, and no MTU
+		peer.PersistentKeepalive = 0
+		peer.MTU = 0
+		buf, err := GetWGPeerTemplate(peer, tc.peerType, server)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), tc.keepalive) {
+			t.Fatalf("type %d: expected %q in\n%s", tc.peerType, tc.keepalive, buf)
+		}
+		if strings.Contains(buf.String(), tc.anyMTU) {
+			t.Fatalf("type %d: expected no MTU in\n%s", tc.peerType, buf)
+		}
+
+		// peer overrides
+		peer.PersistentKeepalive = 10
+		peer.MTU = 1280
+		buf, err = GetWGPeerTemplate(peer, tc.peerType, server)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keepalive := strings.Replace(tc.keepalive, "25", "10", 1)
+		if !strings.Contains(buf.String(), keepalive) || !strings.Contains(buf.String(), tc.mtu) {
+			t.Fatalf("type %d: expected %q and %q in\n%s", tc.peerType, keepalive, tc.mtu, buf)
+		}
+	}
+}
+
+func TestGetWGServerTemplateKeepalive(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+	server.MTU = 1420
+
+	// the same as the server, so only sent by the peer
+	peer.PersistentKeepalive = 25
+	server.Peers = []Peer{peer}
+	buf, err := GetWGServerTemplate(server, WGQuick)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "PersistentKeepalive") {
+		t.Fatalf("expected no server side keepalive in\n%s", buf)
+	}
+
+	peer.PersistentKeepalive = 10
+	server.Peers = []Peer{peer}
+	for _, peerType := range []PeerType{WGQuick, Networkd} {
+		buf, err = GetWGServerTemplate(server, peerType)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), "PersistentKeepalive=10\n") {
+			t.Fatalf("expected server side keepalive in\n%s", buf)
+		}
+	}
+}
+
+func TestGetWGServerTemplateEndpoint(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+	peer.Endpoint = "router.example.com:51820"
+	server.Peers = []Peer{peer}
+
+	for peerType, expected := range map[PeerType]string{
+		WGQuick:  "Endpoint=router.example.com:51820\n",
+		Networkd: "Endpoint=router.example.com:51820\n",
+		NixOS:    `endpoint = "router.example.com:51820";`,
+	} {
+		buf, err := GetWGServerTemplate(server, peerType)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("expected %q in\n%s", expected, buf)
+		}
+	}
+
+	// and parsed back by adopt
+	buf, err := GetWGServerTemplate(server, WGQuick)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wgConf, err := ParseWGQuickConfig(buf)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if wgConf.Peers[0].Endpoint != peer.Endpoint {
+		t.Fatalf("expected endpoint %s, got %q", peer.Endpoint, wgConf.Peers[0].Endpoint)
+	}
+}
+
+func TestGetWGPeerTemplateUnknownPrivateKey(t *testing.T) {
+	peer, server := testPeerAndServer(t)
+	peer.PrivateKey = JSONKey{}
+	zeroKey := wgtypes.Key{}.String()
+
+	for _, peerType := range []PeerType{WGQuick, Vyatta, NixOS, RouterOS, K8sSecret, DockerCompose} {
+		buf, err := GetWGPeerTemplate(peer, peerType, server)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		output := buf.String()
+		if strings.Contains(output, zeroKey) {
+			t.Fatalf("config type %d should not contain the zero key:\n%s", peerType, output)
+		}
+		if !strings.Contains(output, PrivateKeyPlaceholder) {
+			t.Fatalf("config type %d should contain the private key placeholder:\n%s", peerType, output)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"strings"
+)
+
+// IPRange is an inclusive range of IPv4 or IPv6 addresses. In the config it
+// is a string: a CIDR such as 10.0.0.0/27, a range such as
+// 10.0.0.1-10.0.0.20, or a single IP.
+type IPRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+// normaliseIP returns IPv4 addresses in their 4 byte form, so that IPs of
+// the same family compare equal length
+func normaliseIP(IP net.IP) net.IP {
+	if IP4 := IP.To4(); IP4 != nil {
+		return IP4
+	}
+	return IP
+}
+
+func ParseIPRange(s string) (IPRange, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return IPRange{}, err
+		}
+		start := normaliseIP(network.IP)
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^network.Mask[i]
+		}
+		return IPRange{Start: start, End: end}, nil
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	start := net.ParseIP(strings.TrimSpace(parts[0]))
+	end := start
+	if len(parts) == 2 {
+		end = net.ParseIP(strings.TrimSpace(parts[1]))
+	}
+	if start == nil || end == nil {
+		return IPRange{}, fmt.Errorf("invalid IP range %q, expected a CIDR, start-end or a single IP", s)
+	}
+
+	r := IPRange{Start: normaliseIP(start), End: normaliseIP(end)}
+	if len(r.Start) != len(r.End) {
+		return IPRange{}, fmt.Errorf("IP range %q mixes IPv4 and IPv6", s)
+	}
+	if bytes.Compare(r.Start, r.End) > 0 {
+		return IPRange{}, fmt.Errorf("IP range %q ends before it starts", s)
+	}
+	return r, nil
+}
+
+func (r IPRange) String() string {
+	if r.Start.Equal(r.End) {
+		return r.Start.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
+
+func (r IPRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *IPRange) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseIPRange(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Is6 reports whether the range is of IPv6 addresses
+func (r IPRange) Is6() bool {
+	return len(r.Start) == net.IPv6len
+}
+
+func (r IPRange) Contains(IP net.IP) bool {
+	IP = normaliseIP(IP)
+	if len(IP) != len(r.Start) {
+		return false
+	}
+	return bytes.Compare(IP, r.Start) >= 0 && bytes.Compare(IP, r.End) <= 0
+}
+
+func (r IPRange) Overlaps(other IPRange) bool {
+	if len(r.Start) != len(other.Start) {
+		return false
+	}
+	return bytes.Compare(r.Start, other.End) <= 0 && bytes.Compare(other.Start, r.End) <= 0
+}
+
+// Within reports whether the whole range is inside network
+func (r IPRange) Within(network net.IPNet) bool {
+	return network.Contains(r.Start) && network.Contains(r.End)
+}
+
+// size returns the number of IPs in the range
+func (r IPRange) size() *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(r.End), new(big.Int).SetBytes(r.Start))
+	return size.Add(size, big.NewInt(1))
+}
+
+// nth returns the IP n places after Start
+func (r IPRange) nth(n *big.Int) net.IP {
+	i := new(big.Int).Add(new(big.Int).SetBytes(r.Start), n)
+	IP := make(net.IP, len(r.Start))
+	return i.FillBytes(IP)
+}
+
+// random returns a pseudorandom IP in the range
+func (r IPRange) random(rnd *rand.Rand) net.IP {
+	return r.nth(new(big.Int).Rand(rnd, r.size()))
+}
+
+// rangesContain reports whether any of ranges contains IP
+func rangesContain(ranges []IPRange, IP net.IP) bool {
+	for _, r := range ranges {
+		if r.Contains(IP) {
+			return true
+		}
+	}
+	return false
+}
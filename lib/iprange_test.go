@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func mustParseIPRange(t *testing.T, s string) IPRange {
+	t.Helper()
+	r, err := ParseIPRange(s)
+	if err != nil {
+		t.Fatalf("unexpected error parsing %s: %v", s, err)
+	}
+	return r
+}
+
+func TestParseIPRange(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10.0.0.1-10.0.0.20":  "10.0.0.1-10.0.0.20",
+		"10.0.0.1 - 10.0.0.1": "10.0.0.1",
+		"10.0.0.32/27":        "10.0.0.32-10.0.0.63",
+		"10.0.0.5":            "10.0.0.5",
+		"fd00::100/120":       "fd00::100-fd00::1ff",
+	} {
+		if r := mustParseIPRange(t, input); r.String() != expected {
+			t.Fatalf("expected %s for %s, got %s", expected, input, r)
+		}
+	}
+
+	for _, input := range []string{"", "10.0.0.20-10.0.0.1", "10.0.0.1-fd00::1", "10.0.0.0/33", "vpn.example.com"} {
+		if _, err := ParseIPRange(input); err == nil {
+			t.Fatalf("expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestIPRangeJSON(t *testing.T) {
+	ranges := []IPRange{}
+	if err := json.Unmarshal([]byte(`["10.0.0.0/28", "fd00::1-fd00::ff"]`), &ranges); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	raw, err := json.Marshal(ranges)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(raw) != `["10.0.0.0-10.0.0.15","fd00::1-fd00::ff"]` {
+		t.Fatalf("unexpected JSON %s", raw)
+	}
+
+	if err = json.Unmarshal([]byte(`["10.0.0.0/40"]`), &ranges); err == nil {
+		t.Fatal("expected an invalid range to be rejected")
+	}
+}
+
+func TestIPRangeContains(t *testing.T) {
+	r := mustParseIPRange(t, "10.0.0.1-10.0.0.20")
+
+	if !r.Contains(net.IP{10, 0, 0, 20}) || !r.Contains(net.ParseIP("10.0.0.1")) {
+		t.Fatal("expected the range to include both ends")
+	}
+	if r.Contains(net.IP{10, 0, 0, 21}) || r.Contains(net.ParseIP("fd00::a")) {
+		t.Fatal("expected IPs outside the range not to be contained")
+	}
+
+	if !r.Overlaps(mustParseIPRange(t, "10.0.0.16/28")) || r.Overlaps(mustParseIPRange(t, "10.0.0.32/27")) {
+		t.Fatal("unexpected overlap")
+	}
+	if r.Overlaps(mustParseIPRange(t, "fd00::/64")) {
+		t.Fatal("expected ranges of different families not to overlap")
+	}
+}
@@ -14,7 +14,9 @@ func (s *Server) Up() error {
 	return s.ConfigureDevice()
 }
 
-// ConfigureDevice sets up the WG interface
+// ConfigureDevice sets up the WG interface. Peers whose Endpoint failed to
+// resolve keep their last one, and are reported with an
+// UnresolvedEndpointsError once the rest is configured.
 func (s *Server) ConfigureDevice() error {
 	wg, err := wgctrl.New()
 	if err != nil {
@@ -28,7 +30,7 @@ func (s *Server) ConfigureDevice() error {
 		return fmt.Errorf("could not retrieve device '%s' (%v)", s.InterfaceName, err)
 	}
 
-	peers := s.GetPeers()
+	peers, resolveErr := s.GetPeers()
 
 	// compare peers to see if any exist on the device and not the config. If
 	// so, they should be removed by appending a dummy peer with Remove:true + pubkey.
@@ -65,5 +67,5 @@ func (s *Server) ConfigureDevice() error {
 	if err != nil {
 		return fmt.Errorf("could not configure device '%s' (%v)", s.InterfaceName, err)
 	}
-	return nil
+	return resolveErr
 }
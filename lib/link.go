@@ -9,6 +9,18 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
+// otherAddrs returns the addresses in addrs other than keep, which CreateLink
+// removes from the interface
+func otherAddrs(addrs []netlink.Addr, keep *net.IPNet) []netlink.Addr {
+	others := make([]netlink.Addr, 0)
+	for _, addr := range addrs {
+		if addr.IPNet.String() != keep.String() {
+			others = append(others, addr)
+		}
+	}
+	return others
+}
+
 // IPExists checks if the given IP address already exists on the specified link
 func IPExists(link netlink.Link, ipNet *net.IPNet, family int) (bool, error) {
 	addrs, err := netlink.AddrList(link, family)
@@ -72,12 +84,10 @@ func (s *Server) CreateLink() error {
 		if err != nil {
 			return fmt.Errorf("failed to list addresses for interface: %v", err)
 		}
-		for _, addr := range addrs {
-			if addr.IPNet.String() != addr.IPNet.String() {
-				err := netlink.AddrDel(link, &addr)
-				if err != nil {
-					return fmt.Errorf("failed to delete address %s from interface %s: %v", addr.IP, s.InterfaceName, err)
-				}
+		for _, other := range otherAddrs(addrs, addr.IPNet) {
+			err := netlink.AddrDel(link, &other)
+			if err != nil {
+				return fmt.Errorf("failed to delete address %s from interface %s: %v", other.IP, s.InterfaceName, err)
 			}
 		}
 	}
@@ -108,12 +118,10 @@ func (s *Server) CreateLink() error {
 			return fmt.Errorf("failed to list v6 addresses for interface: %v", err)
 		}
 
-		for _, addr := range addrs {
-			if addr.IPNet.String() != addr6.IPNet.String() {
-				err := netlink.AddrDel(link, &addr)
-				if err != nil {
-					return fmt.Errorf("failed to delete address %s from interface %s: %v", addr.IP, s.InterfaceName, err)
-				}
+		for _, other := range otherAddrs(addrs, addr6.IPNet) {
+			err := netlink.AddrDel(link, &other)
+			if err != nil {
+				return fmt.Errorf("failed to delete address %s from interface %s: %v", other.IP, s.InterfaceName, err)
 			}
 		}
 	}
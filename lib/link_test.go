@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestOtherAddrs(t *testing.T) {
+	keep := &net.IPNet{IP: net.IP{10, 0, 0, 1}, Mask: net.IPMask{255, 255, 252, 0}}
+	addrs := []netlink.Addr{
+		{IPNet: &net.IPNet{IP: net.IP{10, 0, 0, 1}, Mask: net.IPMask{255, 255, 252, 0}}},
+		{IPNet: &net.IPNet{IP: net.IP{192, 168, 9, 1}, Mask: net.IPMask{255, 255, 255, 0}}},
+		// the same IP with another mask is replaced too
+		{IPNet: &net.IPNet{IP: net.IP{10, 0, 0, 1}, Mask: net.IPMask{255, 255, 255, 0}}},
+	}
+
+	others := otherAddrs(addrs, keep)
+	if len(others) != 2 || others[0].IPNet.String() != "192.168.9.1/24" || others[1].IPNet.String() != "10.0.0.1/24" {
+		t.Fatalf("expected the other IPv4 addresses to be removed, got %v", others)
+	}
+
+	if others := otherAddrs(addrs[:1], keep); len(others) != 0 {
+		t.Fatalf("expected nothing to be removed, got %v", others)
+	}
+}
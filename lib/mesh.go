@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"net"
+	"sort"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// MeshPeer is another peer that a peer connects to directly in mesh mode,
+// rather than via the server
+type MeshPeer struct {
+	Peer Peer
+	// shared by the pair only, see MeshPresharedKey
+	PresharedKey JSONKey
+	AllowedIPs   []string
+	// Peer.Endpoint split, for RouterOS
+	EndpointHost string
+	EndpointPort string
+}
+
+// MeshPresharedKey derives the preshared key of a pair of peers from
+// MeshKey, so that it need not be stored for every pair and is unaffected by
+// rotating the server key. It is the same whichever way round the peers are
+// given.
+func (s *Server) MeshPresharedKey(a, b wgtypes.Key) JSONKey {
+	keys := [][]byte{a[:], b[:]}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	mac := hmac.New(sha256.New, s.MeshKey.Key[:])
+	mac.Write([]byte("dsnet mesh preshared key"))
+	mac.Write(keys[0])
+	mac.Write(keys[1])
+
+	var psk wgtypes.Key
+	copy(psk[:], mac.Sum(nil))
+	return JSONKey{Key: psk}
+}
+
+// GetMeshPeers returns the peers that peer links to directly in mesh mode.
+// Only peers with an Endpoint are reachable, so only peers with an Endpoint
+// are linked, to each other. Other peers keep routing via the server, which
+// the more specific AllowedIPs of mesh peers take precedence over.
+func (s *Server) GetMeshPeers(peer Peer) []MeshPeer {
+	meshPeers := make([]MeshPeer, 0)
+	if !s.Mesh || peer.Endpoint == "" {
+		return meshPeers
+	}
+
+	for _, other := range s.Peers {
+		if other.Endpoint == "" || other.PublicKey.Key == peer.PublicKey.Key {
+			continue
+		}
+
+		allowedIPs := make([]string, 0)
+		for _, allowedIP := range other.GetAllowedIPs() {
+			allowedIPs = append(allowedIPs, allowedIP.String())
+		}
+
+		// checked by validation
+		host, port, _ := net.SplitHostPort(other.Endpoint)
+
+		meshPeers = append(meshPeers, MeshPeer{
+			Peer:         other,
+			PresharedKey: s.MeshPresharedKey(peer.PublicKey.Key, other.PublicKey.Key),
+			AllowedIPs:   allowedIPs,
+			EndpointHost: host,
+			EndpointPort: port,
+		})
+	}
+
+	return meshPeers
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// testMeshServer returns a mesh server with peers builder0..2, all reachable
+// except builder2
+func testMeshServer(t *testing.T) *Server {
+	t.Helper()
+	s := testServer(t)
+	s.Mesh = true
+	meshKey, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate mesh key: %v", err)
+	}
+	s.MeshKey = JSONKey{Key: meshKey}
+	for i, endpoint := range []string{"192.0.2.10:51820", "[2001:db8::11]:51821", ""} {
+		peer, err := NewPeer(s, "", "", "alice", fmt.Sprintf("builder%d", i), "test")
+		if err != nil {
+			t.Fatalf("failed to create peer: %v", err)
+		}
+		peer.Endpoint = endpoint
+		s.Peers = append(s.Peers, peer)
+	}
+	return s
+}
+
+func TestMeshPresharedKey(t *testing.T) {
+	s := testMeshServer(t)
+	a, b, c := s.Peers[0].PublicKey.Key, s.Peers[1].PublicKey.Key, s.Peers[2].PublicKey.Key
+
+	if s.MeshPresharedKey(a, b) != s.MeshPresharedKey(b, a) {
+		t.Fatal("expected the same key either way round")
+	}
+	if s.MeshPresharedKey(a, b) == s.MeshPresharedKey(a, c) {
+		t.Fatal("expected a different key per pair")
+	}
+
+	other := testMeshServer(t)
+	if s.MeshPresharedKey(a, b) == other.MeshPresharedKey(a, b) {
+		t.Fatal("expected the key to depend on the mesh key")
+	}
+
+	// so that rotating the server key leaves mesh links alone
+	psk := s.MeshPresharedKey(a, b)
+	s.PrivateKey = other.PrivateKey
+	if s.MeshPresharedKey(a, b) != psk {
+		t.Fatal("expected the key not to depend on the server key")
+	}
+}
+
+func TestGetMeshPeers(t *testing.T) {
+	s := testMeshServer(t)
+
+	meshPeers := s.GetMeshPeers(s.Peers[0])
+	if len(meshPeers) != 1 || meshPeers[0].Peer.Hostname != "builder1" {
+		t.Fatalf("expected builder0 to link to builder1 only, got %+v", meshPeers)
+	}
+	if meshPeers[0].EndpointHost != "2001:db8::11" || meshPeers[0].EndpointPort != "51821" {
+		t.Fatalf("unexpected endpoint %s port %s", meshPeers[0].EndpointHost, meshPeers[0].EndpointPort)
+	}
+	if len(meshPeers[0].AllowedIPs) != 2 {
+		t.Fatalf("expected the IP and IP6 of builder1, got %v", meshPeers[0].AllowedIPs)
+	}
+
+	// NATed peers route everything via the server
+	if meshPeers := s.GetMeshPeers(s.Peers[2]); len(meshPeers) != 0 {
+		t.Fatalf("expected no mesh peers for builder2, got %+v", meshPeers)
+	}
+
+	s.Mesh = false
+	if meshPeers := s.GetMeshPeers(s.Peers[0]); len(meshPeers) != 0 {
+		t.Fatalf("expected no mesh peers without mesh mode, got %+v", meshPeers)
+	}
+}
+
+func TestGetWGPeerTemplateMesh(t *testing.T) {
+	s := testMeshServer(t)
+	builder0, builder1 := s.Peers[0], s.Peers[1]
+	psk := s.MeshPresharedKey(builder0.PublicKey.Key, builder1.PublicKey.Key).Key.String()
+
+	for _, peerType := range []PeerType{WGQuick, Vyatta, NixOS, RouterOS} {
+		buf, err := GetWGPeerTemplate(builder0, peerType, *s)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		conf := buf.String()
+		if !strings.Contains(conf, builder1.PublicKey.Key.String()) || !strings.Contains(conf, psk) {
+			t.Fatalf("expected builder1 with the pair preshared key in\n%s", conf)
+		}
+		if strings.Contains(conf, s.Peers[2].PublicKey.Key.String()) {
+			t.Fatalf("expected no link to NATed builder2 in\n%s", conf)
+		}
+	}
+
+	// peers listen on the port of their Endpoint
+	for peerType, expected := range map[PeerType]string{
+		WGQuick:  "\nListenPort=51821\n",
+		Vyatta:   "\nset interfaces wireguard wg0 listen-port 51821\n",
+		NixOS:    "\n    listenPort = 51821;\n",
+		RouterOS: " listen-port=51821 ",
+	} {
+		buf, err := GetWGPeerTemplate(builder1, peerType, *s)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("expected %q in\n%s", expected, buf)
+		}
+	}
+
+	// the hub config is the same as ever
+	buf, err := GetWGPeerTemplate(s.Peers[2], WGQuick, *s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := strings.Count(buf.String(), "[Peer]"); n != 1 {
+		t.Fatalf("expected only the server peer, got %d in\n%s", n, buf)
+	}
+	if strings.Contains(buf.String(), "ListenPort") {
+		t.Fatalf("expected no ListenPort without an Endpoint in\n%s", buf)
+	}
+
+	// and the mesh section parses as wg-quick
+	buf, err = GetWGPeerTemplate(builder0, WGQuick, *s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wgConf, err := ParseWGQuickConfig(buf)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if len(wgConf.Peers) != 2 || wgConf.Peers[1].Name != "builder1" || wgConf.Peers[1].Endpoint != builder1.Endpoint {
+		t.Fatalf("unexpected peers %+v", wgConf.Peers)
+	}
+}
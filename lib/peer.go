@@ -27,6 +27,16 @@ const (
 	// RouterOS is proprietary Linux based OS by MikroTik
 	// https://help.mikrotik.com/docs/display/ROS/WireGuard
 	RouterOS
+	// K8sSecret wraps the wg-quick config in a Kubernetes Secret manifest,
+	// for use with sidecar containers such as linuxserver/wireguard
+	K8sSecret
+	// DockerCompose wraps the wg-quick config in a compose snippet that
+	// mounts it into a linuxserver/wireguard container
+	DockerCompose
+	// Networkd is a systemd-networkd .netdev/.network pair. Only used when
+	// exporting the server config.
+	// https://www.freedesktop.org/software/systemd/man/systemd.netdev.html
+	Networkd
 )
 
 type Peer struct {
@@ -39,8 +49,39 @@ type Peer struct {
 	PublicKey           JSONKey
 	PrivateKey          JSONKey
 	PresharedKey        JSONKey
+	PresharedKeyCreated time.Time
 	Networks            []JSONIPNet
+	// overrides the server PersistentKeepalive if not 0
 	PersistentKeepalive int
+	// MTU of the peer interface, if not 0
+	MTU int
+	// static host:port the server connects to, if any
+	Endpoint string
+}
+
+// ResolveEndpoint resolves the static Endpoint of the peer, or returns nil if
+// it has none. Hostnames are resolved every time, to follow dynamic DNS.
+func (p *Peer) ResolveEndpoint() (*net.UDPAddr, error) {
+	if p.Endpoint == "" {
+		return nil, nil
+	}
+	return net.ResolveUDPAddr("udp", p.Endpoint)
+}
+
+// endpointPort returns the port of the static Endpoint of the peer, or "" if
+// it has none
+func (p *Peer) endpointPort() string {
+	// checked by validation
+	_, port, _ := net.SplitHostPort(p.Endpoint)
+	return port
+}
+
+// GetPersistentKeepalive returns the PersistentKeepalive the peer should use
+func (p *Peer) GetPersistentKeepalive(server Server) int {
+	if p.PersistentKeepalive > 0 {
+		return p.PersistentKeepalive
+	}
+	return server.PersistentKeepalive
 }
 
 // NewPeer generates a peer from the supplied arguments and generates keys if needed.
@@ -51,7 +92,23 @@ type Peer struct {
 //   - owner is the owner name (required)
 //   - hostname is the name of the peer (required)
 //   - description is the annotation for the peer
+//
+// The IPs are allocated outside every pool; see NewPeerWithIPs.
 func NewPeer(server *Server, private, public, owner, hostname, description string) (Peer, error) {
+	return NewPeerWithIPs(server, private, public, owner, hostname, description, PeerIPs{})
+}
+
+// PeerIPs chooses the IPs of a new peer. IP and IP6 are used if given, after
+// checking them with CheckStaticIP. Otherwise they are allocated from Pool,
+// or outside every pool if Pool is "".
+type PeerIPs struct {
+	IP   net.IP
+	IP6  net.IP
+	Pool string
+}
+
+// NewPeerWithIPs is NewPeer with the IPs chosen by ips
+func NewPeerWithIPs(server *Server, private, public, owner, hostname, description string, ips PeerIPs) (Peer, error) {
 	if owner == "" {
 		return Peer{}, errors.New("missing owner")
 	}
@@ -62,7 +119,9 @@ func NewPeer(server *Server, private, public, owner, hostname, description strin
 	var privateKey JSONKey
 	if private != "" {
 		userKey := &JSONKey{}
-		userKey.UnmarshalJSON([]byte(private))
+		if err := userKey.UnmarshalJSON([]byte(private)); err != nil {
+			return Peer{}, fmt.Errorf("invalid private key: %s", err)
+		}
 		privateKey = *userKey
 	} else {
 		var err error
@@ -77,17 +136,13 @@ func NewPeer(server *Server, private, public, owner, hostname, description strin
 		b64Key := strings.Trim(string(public), "\"")
 		key, err := wgtypes.ParseKey(b64Key)
 		if err != nil {
-			return Peer{}, err
+			return Peer{}, fmt.Errorf("invalid public key: %s", err)
 		}
 		publicKey = JSONKey{Key: key}
 		if private == "" {
 			privateKey = JSONKey{Key: wgtypes.Key([wgtypes.KeyLen]byte{})}
-		} else {
-			pubK := privateKey.PublicKey()
-			ascK := pubK.Key.String()
-			if ascK != public {
-				return Peer{}, fmt.Errorf("user-supplied private and public keys are not related")
-			}
+		} else if privateKey.PublicKey().Key != key {
+			return Peer{}, fmt.Errorf("user-supplied private and public keys are not related")
 		}
 	} else {
 		publicKey = privateKey.PublicKey()
@@ -98,29 +153,47 @@ func NewPeer(server *Server, private, public, owner, hostname, description strin
 		return Peer{}, fmt.Errorf("failed to generate private key: %s", err)
 	}
 
+	added := time.Now()
 	newPeer := Peer{
-		Owner:        owner,
-		Hostname:     hostname,
-		Description:  description,
-		Added:        time.Now(),
-		PublicKey:    publicKey,
-		PrivateKey:   privateKey,
-		PresharedKey: presharedKey,
-		Networks:     []JSONIPNet{},
+		Owner:               owner,
+		Hostname:            hostname,
+		Description:         description,
+		Added:               added,
+		PublicKey:           publicKey,
+		PrivateKey:          privateKey,
+		PresharedKey:        presharedKey,
+		PresharedKeyCreated: added,
+		Networks:            []JSONIPNet{},
 		// inherit from server setting, which is derived from config
 		PersistentKeepalive: server.PersistentKeepalive,
 	}
 
-	if len(server.Network.IPNet.Mask) > 0 {
-		newIP, err := server.AllocateIP()
+	if len(ips.IP) > 0 {
+		if ips.IP.To4() == nil {
+			return Peer{}, fmt.Errorf("%s is not an ipv4 address", ips.IP)
+		}
+		if err := server.CheckStaticIP(ips.IP); err != nil {
+			return Peer{}, err
+		}
+		newPeer.IP = ips.IP.To4()
+	} else if len(server.Network.IPNet.Mask) > 0 {
+		newIP, err := server.AllocatePoolIP(ips.Pool)
 		if err != nil {
 			return Peer{}, fmt.Errorf("failed to allocate ipv4 address: %s", err)
 		}
 		newPeer.IP = newIP
 	}
 
-	if len(server.Network6.IPNet.Mask) > 0 {
-		newIPV6, err := server.AllocateIP6()
+	if len(ips.IP6) > 0 {
+		if ips.IP6.To4() != nil {
+			return Peer{}, fmt.Errorf("%s is not an ipv6 address", ips.IP6)
+		}
+		if err := server.CheckStaticIP(ips.IP6); err != nil {
+			return Peer{}, err
+		}
+		newPeer.IP6 = ips.IP6
+	} else if len(server.Network6.IPNet.Mask) > 0 {
+		newIPV6, err := server.AllocatePoolIP6(ips.Pool)
 		if err != nil {
 			return Peer{}, fmt.Errorf("failed to allocate ipv6 address: %s", err)
 		}
@@ -192,6 +192,24 @@ func TestNewPeerWithMismatchedKeys(t *testing.T) {
 	}
 }
 
+func TestNewPeerInvalidPrivateKey(t *testing.T) {
+	s := testServer(t)
+
+	_, err := NewPeer(s, "not-a-key", "", "alice", "laptop", "test")
+	if err == nil {
+		t.Fatal("expected error for invalid private key")
+	}
+}
+
+func TestNewPeerInvalidPublicKey(t *testing.T) {
+	s := testServer(t)
+
+	_, err := NewPeer(s, "", "not-a-key", "alice", "laptop", "test")
+	if err == nil {
+		t.Fatal("expected error for invalid public key")
+	}
+}
+
 func TestNewPeerNoNetwork(t *testing.T) {
 	s := testServer(t)
 	s.IP = nil
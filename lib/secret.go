@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// sealedPrefix marks an encrypted value in the config file:
+// enc:v1:<base64 salt>:<base64 nonce+ciphertext>
+const sealedPrefix = "enc:v1:"
+
+const saltLen = 16
+
+// ErrNoSecretKey is returned when an encrypted value is found but no secret
+// key has been configured to decrypt it
+var ErrNoSecretKey = errors.New("config contains encrypted secrets but no secret key is configured, set DSNET_SECRET_KEY_FILE")
+
+// SecretBox encrypts secrets at rest with XChaCha20-Poly1305. The key is
+// either 32 random bytes, or derived from a passphrase with scrypt using the
+// salt stored with each value.
+type SecretBox struct {
+	rawKey     []byte
+	passphrase []byte
+	// salt used when sealing, so a config is sealed with a single scrypt
+	salt []byte
+
+	mu      sync.Mutex
+	derived map[string][]byte
+	// values opened, by plaintext, so that unchanged secrets are saved as
+	// they were rather than sealed again with a new nonce
+	opened map[string]string
+}
+
+// NewSecretBox creates a SecretBox from the contents of a key file. A
+// base64-encoded 32 byte value is used as the key directly; anything else is
+// treated as a passphrase.
+func NewSecretBox(keyMaterial []byte) (*SecretBox, error) {
+	material := strings.TrimSpace(string(keyMaterial))
+	if material == "" {
+		return nil, errors.New("secret key is empty")
+	}
+
+	box := &SecretBox{derived: make(map[string][]byte), opened: make(map[string]string)}
+
+	if raw, err := base64.StdEncoding.DecodeString(material); err == nil && len(raw) == chacha20poly1305.KeySize {
+		box.rawKey = raw
+		return box, nil
+	}
+
+	box.passphrase = []byte(material)
+	box.salt = make([]byte, saltLen)
+	if _, err := rand.Read(box.salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %s", err)
+	}
+	return box, nil
+}
+
+func (b *SecretBox) key(salt []byte) ([]byte, error) {
+	if b.rawKey != nil {
+		return b.rawKey, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if key, ok := b.derived[string(salt)]; ok {
+		return key, nil
+	}
+
+	key, err := scrypt.Key(b.passphrase, salt, 1<<15, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	b.derived[string(salt)] = key
+	return key, nil
+}
+
+// Seal encrypts plaintext to a string suitable for the config file. A
+// plaintext that was opened by the box is sealed to the value it was opened
+// from.
+func (b *SecretBox) Seal(plaintext []byte) (string, error) {
+	b.mu.Lock()
+	value, ok := b.opened[string(plaintext)]
+	b.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	key, err := b.key(b.salt)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %s", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(b.salt) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal
+func (b *SecretBox) Open(value string) ([]byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedPrefix), ":", 2)
+	if !IsSealed(value) || len(parts) != 2 {
+		return nil, errors.New("not an encrypted value")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %s", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %s", err)
+	}
+
+	key, err := b.key(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("invalid encrypted value: too short")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt secret, wrong secret key?")
+	}
+
+	b.mu.Lock()
+	b.opened[string(plaintext)] = value
+	b.mu.Unlock()
+	return plaintext, nil
+}
+
+// IsSealed reports whether a config value is encrypted
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// secretBox is used to open encrypted keys when unmarshalling, and by the
+// config to seal them when saving. nil means secrets are stored in plaintext.
+var secretBox *SecretBox
+
+// SetSecretBox sets the SecretBox used for secrets at rest, or nil to store
+// them in plaintext
+func SetSecretBox(box *SecretBox) {
+	secretBox = box
+}
+
+// SecretsEncrypted reports whether secrets are encrypted when saved
+func SecretsEncrypted() bool {
+	return secretBox != nil
+}
+
+// SealKey returns the key encrypted with the configured SecretBox
+func SealKey(k JSONKey) (string, error) {
+	if secretBox == nil {
+		return "", ErrNoSecretKey
+	}
+	return secretBox.Seal(k.Key[:])
+}
+
+func openKey(value string) ([]byte, error) {
+	if secretBox == nil {
+		return nil, ErrNoSecretKey
+	}
+	return secretBox.Open(value)
+}
+
+// WithoutSecretBox runs fn with secrets in plaintext, for instance to marshal
+// a config for another host that has its own secret key. Like SetSecretBox,
+// it is not safe to use concurrently with marshalling elsewhere.
+func WithoutSecretBox(fn func() error) error {
+	box := secretBox
+	secretBox = nil
+	defer func() { secretBox = box }()
+	return fn()
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func setSecretBoxForTest(t *testing.T, box *SecretBox) {
+	t.Helper()
+	SetSecretBox(box)
+	t.Cleanup(func() { SetSecretBox(nil) })
+}
+
+func TestSecretBoxRawKey(t *testing.T) {
+	raw := make([]byte, 32)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+
+	box, err := NewSecretBox([]byte(base64.StdEncoding.EncodeToString(raw) + "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if box.rawKey == nil {
+		t.Fatal("a base64 32 byte key should be used directly")
+	}
+
+	sealed, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "secret") {
+		t.Fatalf("unexpected sealed value %s", sealed)
+	}
+
+	plaintext, err := box.Open(sealed)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("round trip failed: %q, %v", plaintext, err)
+	}
+}
+
+func TestSecretBoxSealsOpenedValuesUnchanged(t *testing.T) {
+	saved, err := NewSecretBox([]byte("passphrase"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sealed, err := saved.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// as loaded by a later run, with a new salt
+	box, err := NewSecretBox([]byte("passphrase"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fresh, _ := box.Seal([]byte("secret")); fresh == sealed {
+		t.Fatal("expected a new nonce for a value not opened")
+	}
+	if _, err = box.Open(sealed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resealed, _ := box.Seal([]byte("secret")); resealed != sealed {
+		t.Fatal("expected an opened value to be sealed as it was")
+	}
+	if other, _ := box.Seal([]byte("other")); other == sealed {
+		t.Fatal("expected another value to be sealed anew")
+	}
+}
+
+func TestSecretBoxPassphrase(t *testing.T) {
+	box, err := NewSecretBox([]byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sealed, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a new box from the same passphrase has a different salt, but must
+	// open values using the salt stored with them
+	other, _ := NewSecretBox([]byte("correct horse battery staple"))
+	plaintext, err := other.Open(sealed)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("round trip failed: %q, %v", plaintext, err)
+	}
+
+	wrong, _ := NewSecretBox([]byte("wrong passphrase"))
+	if _, err := wrong.Open(sealed); err == nil {
+		t.Fatal("expected error opening with the wrong passphrase")
+	}
+}
+
+func TestNewSecretBoxEmpty(t *testing.T) {
+	if _, err := NewSecretBox([]byte(" \n")); err == nil {
+		t.Fatal("expected error for empty key")
+	}
+}
+
+func TestJSONKeyUnmarshalSealed(t *testing.T) {
+	box, _ := NewSecretBox([]byte("passphrase"))
+	setSecretBoxForTest(t, box)
+
+	privKey, _ := wgtypes.GeneratePrivateKey()
+	sealed, err := SealKey(JSONKey{Key: privKey})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var k JSONKey
+	if err := json.Unmarshal([]byte(`"`+sealed+`"`), &k); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if k.Key != privKey {
+		t.Fatal("sealed key did not round trip")
+	}
+
+	SetSecretBox(nil)
+	if err := json.Unmarshal([]byte(`"`+sealed+`"`), &k); err != ErrNoSecretKey {
+		t.Fatalf("expected ErrNoSecretKey, got %v", err)
+	}
+}
@@ -2,8 +2,10 @@ package lib
 
 import (
 	"fmt"
+	"math/big"
 	"math/rand"
 	"net"
+	"strings"
 	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -29,55 +31,114 @@ type Server struct {
 	Networks            []JSONIPNet
 	PersistentKeepalive int
 	MTU                 int
+	// link peers with an Endpoint directly, see GetMeshPeers
+	Mesh bool
+	// secret the preshared keys of mesh pairs are derived from
+	MeshKey JSONKey
+	// never allocated to peers, though they may be given explicitly
+	Reserved []IPRange
+	// ranges allocated only to peers added to the named pool
+	Pools map[string][]IPRange
 }
 
-func (s *Server) GetPeers() []wgtypes.PeerConfig {
+// UnresolvedEndpoint is a peer whose static Endpoint failed to resolve
+type UnresolvedEndpoint struct {
+	Hostname string
+	Endpoint string
+	Err      error
+}
+
+// UnresolvedEndpointsError lists the peers whose Endpoint failed to resolve.
+// The peers are configured regardless, leaving their endpoint alone.
+type UnresolvedEndpointsError []UnresolvedEndpoint
+
+func (e UnresolvedEndpointsError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, unresolved := range e {
+		msgs = append(msgs, fmt.Sprintf("failed to resolve endpoint %s of %s: %s", unresolved.Endpoint, unresolved.Hostname, unresolved.Err))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// GetPeers returns the device config of every peer. If the Endpoint of any
+// peer fails to resolve, all peers are still returned along with an
+// UnresolvedEndpointsError.
+func (s *Server) GetPeers() ([]wgtypes.PeerConfig, error) {
 	wgPeers := make([]wgtypes.PeerConfig, 0, len(s.Peers))
+	unresolved := make(UnresolvedEndpointsError, 0)
 
 	for _, peer := range s.Peers {
 		// create a new PSK in memory to avoid passing the same value by
 		// pointer to each peer (d'oh)
 		presharedKey := peer.PresharedKey.Key
+		// always set, so that removing a peer's keepalive disables it
+		keepalive := s.ServerKeepalive(peer)
 
-		// AllowedIPs = private IP + defined networks
-		allowedIPs := make([]net.IPNet, 0, len(peer.Networks)+2)
-
-		if len(peer.IP) > 0 {
-			allowedIPs = append(
-				allowedIPs,
-				net.IPNet{
-					IP:   peer.IP,
-					Mask: net.IPMask{255, 255, 255, 255},
-				},
-			)
-		}
-
-		if len(peer.IP6) > 0 {
-			allowedIPs = append(
-				allowedIPs,
-				net.IPNet{
-					IP:   peer.IP6,
-					Mask: net.IPMask{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
-				},
-			)
-		}
-
-		for _, net := range peer.Networks {
-			allowedIPs = append(allowedIPs, net.IPNet)
+		// nil leaves the endpoint alone, so the device keeps the last one
+		// if the name does not resolve
+		endpoint, err := peer.ResolveEndpoint()
+		if err != nil {
+			unresolved = append(unresolved, UnresolvedEndpoint{Hostname: peer.Hostname, Endpoint: peer.Endpoint, Err: err})
 		}
 
 		wgPeers = append(wgPeers, wgtypes.PeerConfig{
-			PublicKey:         peer.PublicKey.Key,
-			Remove:            false,
-			UpdateOnly:        false,
-			PresharedKey:      &presharedKey,
-			Endpoint:          nil,
-			ReplaceAllowedIPs: true,
-			AllowedIPs:        allowedIPs,
+			PublicKey:                   peer.PublicKey.Key,
+			Remove:                      false,
+			UpdateOnly:                  false,
+			PresharedKey:                &presharedKey,
+			Endpoint:                    endpoint,
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  peer.GetAllowedIPs(),
 		})
 	}
 
-	return wgPeers
+	if len(unresolved) > 0 {
+		return wgPeers, unresolved
+	}
+	return wgPeers, nil
+}
+
+// ServerKeepalive returns the keepalive the server sends to the peer. This
+// is only for peers with a PersistentKeepalive of their own, e.g. behind
+// aggressive NAT; others use the server setting from their side alone.
+func (s *Server) ServerKeepalive(peer Peer) time.Duration {
+	if peer.PersistentKeepalive > 0 && peer.PersistentKeepalive != s.PersistentKeepalive {
+		return time.Duration(peer.PersistentKeepalive) * time.Second
+	}
+	return 0
+}
+
+// GetAllowedIPs returns the server-side AllowedIPs of the peer
+func (peer *Peer) GetAllowedIPs() []net.IPNet {
+	// AllowedIPs = private IP + defined networks
+	allowedIPs := make([]net.IPNet, 0, len(peer.Networks)+2)
+
+	if len(peer.IP) > 0 {
+		allowedIPs = append(
+			allowedIPs,
+			net.IPNet{
+				IP:   peer.IP,
+				Mask: net.IPMask{255, 255, 255, 255},
+			},
+		)
+	}
+
+	if len(peer.IP6) > 0 {
+		allowedIPs = append(
+			allowedIPs,
+			net.IPNet{
+				IP:   peer.IP6,
+				Mask: net.IPMask{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			},
+		)
+	}
+
+	for _, net := range peer.Networks {
+		allowedIPs = append(allowedIPs, net.IPNet)
+	}
+
+	return allowedIPs
 }
 
 // AllocateIP finds a free IPv4 for a new Peer (sequential allocation)
@@ -103,7 +164,7 @@ func (s *Server) AllocateIP() (net.IP, error) {
 			IP[j] = IP[j] | byte(i>>shift)
 		}
 
-		if !s.IPAllocated(IP) {
+		if s.allocatable(IP, "") {
 			return IP, nil
 		}
 	}
@@ -132,7 +193,7 @@ func (s *Server) AllocateIP6() (net.IP, error) {
 			IP[j] = IP[j] | rbs[j]
 		}
 
-		if !s.IPAllocated(IP) {
+		if s.allocatable(IP, "") {
 			return IP, nil
 		}
 	}
@@ -140,6 +201,131 @@ func (s *Server) AllocateIP6() (net.IP, error) {
 	return nil, fmt.Errorf("Could not allocate random IPv6 after 10000 tries. This was highly unlikely!")
 }
 
+// AllocatePoolIP finds a free IPv4 for a new Peer in the named pool, in
+// order, or outside every pool if pool is "" or has no IPv4 ranges
+func (s *Server) AllocatePoolIP(pool string) (net.IP, error) {
+	ranges, err := s.poolRanges(pool, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return s.AllocateIP()
+	}
+
+	one := big.NewInt(1)
+	for _, r := range ranges {
+		for i := big.NewInt(0); i.Cmp(r.size()) < 0; i.Add(i, one) {
+			IP := r.nth(i)
+			if isHostIP(IP, s.Network.IPNet) && s.allocatable(IP, pool) {
+				return IP, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("IP range of pool %s exhausted", pool)
+}
+
+// AllocatePoolIP6 finds a free IPv6 for a new Peer in the named pool
+// (pseudorandom allocation), or outside every pool if pool is "" or has no
+// IPv6 ranges
+func (s *Server) AllocatePoolIP6(pool string) (net.IP, error) {
+	ranges, err := s.poolRanges(pool, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return s.AllocateIP6()
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+	for i := 0; i <= 10000; i++ {
+		IP := ranges[rnd.Intn(len(ranges))].random(rnd)
+		if isHostIP(IP, s.Network6.IPNet) && s.allocatable(IP, pool) {
+			return IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Could not allocate random IPv6 in pool %s after 10000 tries, it may be exhausted", pool)
+}
+
+// poolRanges returns the IPv4 or IPv6 ranges of the named pool
+func (s *Server) poolRanges(pool string, v6 bool) ([]IPRange, error) {
+	ranges := make([]IPRange, 0)
+	if pool == "" {
+		return ranges, nil
+	}
+
+	poolRanges, ok := s.Pools[pool]
+	if !ok {
+		return nil, fmt.Errorf("unknown pool %s", pool)
+	}
+	for _, r := range poolRanges {
+		if r.Is6() == v6 {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges, nil
+}
+
+// allocatable reports whether IP is free to allocate from pool, "" being
+// the rest of the network outside every pool
+func (s *Server) allocatable(IP net.IP, pool string) bool {
+	return !s.IPAllocated(IP) && !s.IPReserved(IP) && s.IPPool(IP) == pool
+}
+
+// IPReserved reports whether IP is in one of the Reserved ranges
+func (s *Server) IPReserved(IP net.IP) bool {
+	return rangesContain(s.Reserved, IP)
+}
+
+// IPPool returns the name of the pool IP is in, or "" if none
+func (s *Server) IPPool(IP net.IP) string {
+	for name, ranges := range s.Pools {
+		if rangesContain(ranges, IP) {
+			return name
+		}
+	}
+	return ""
+}
+
+// CheckStaticIP checks that IP can be given to a new peer explicitly: it must
+// be a host address in Network or Network6 and not allocated. Unlike with
+// allocation, reserved IPs and those in pools may be given.
+func (s *Server) CheckStaticIP(IP net.IP) error {
+	network := s.Network.IPNet
+	if IP.To4() == nil {
+		network = s.Network6.IPNet
+	}
+
+	if len(network.IP) == 0 {
+		return fmt.Errorf("no network defined in config for %s", IP)
+	}
+	if !network.Contains(IP) || !isHostIP(IP, network) {
+		return fmt.Errorf("%s is not a host address in the network %s", IP, network.String())
+	}
+	if s.IPAllocated(IP) {
+		return fmt.Errorf("%s is already allocated", IP)
+	}
+	return nil
+}
+
+// isHostIP reports whether IP is neither the network address nor, for IPv4,
+// the broadcast address of network
+func isHostIP(IP net.IP, network net.IPNet) bool {
+	IP = normaliseIP(IP)
+	networkIP := normaliseIP(network.IP.Mask(network.Mask))
+	if len(IP) != len(networkIP) || len(network.Mask) != len(IP) {
+		return false
+	}
+
+	broadcast := make(net.IP, len(IP))
+	for i := range IP {
+		broadcast[i] = networkIP[i] | ^network.Mask[i]
+	}
+
+	return !IP.Equal(networkIP) && (len(IP) == net.IPv6len || !IP.Equal(broadcast))
+}
+
 // IPAllocated checks the existing used ips and returns bool
 // depending on if the IP is in use
 func (s *Server) IPAllocated(IP net.IP) bool {
@@ -1,8 +1,11 @@
 package lib
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -183,7 +186,7 @@ func TestIPAllocatedPeerNetwork(t *testing.T) {
 
 func TestGetPeersEmpty(t *testing.T) {
 	s := testServer(t)
-	peers := s.GetPeers()
+	peers, _ := s.GetPeers()
 	if len(peers) != 0 {
 		t.Fatalf("expected 0 peers, got %d", len(peers))
 	}
@@ -203,7 +206,7 @@ func TestGetPeersWithPeer(t *testing.T) {
 		Networks:     []JSONIPNet{},
 	})
 
-	wgPeers := s.GetPeers()
+	wgPeers, _ := s.GetPeers()
 	if len(wgPeers) != 1 {
 		t.Fatalf("expected 1 peer, got %d", len(wgPeers))
 	}
@@ -245,7 +248,7 @@ func TestGetPeersAllowedIPsIncludesNetworks(t *testing.T) {
 		Networks:     []JSONIPNet{{IPNet: *subnet}},
 	})
 
-	wgPeers := s.GetPeers()
+	wgPeers, _ := s.GetPeers()
 	p := wgPeers[0]
 
 	// Should have /32 for IP + 1 extra network = 2
@@ -278,7 +281,7 @@ func TestGetPeersPresharedKeyIsolation(t *testing.T) {
 		},
 	)
 
-	wgPeers := s.GetPeers()
+	wgPeers, _ := s.GetPeers()
 
 	// Each peer should have its own preshared key (not sharing pointers)
 	if *wgPeers[0].PresharedKey == *wgPeers[1].PresharedKey {
@@ -291,3 +294,162 @@ func TestGetPeersPresharedKeyIsolation(t *testing.T) {
 		t.Fatal("peer2 preshared key mismatch")
 	}
 }
+
+func TestGetPeersKeepalive(t *testing.T) {
+	s := testServer(t)
+	for _, keepalive := range []int{0, 25, 10} {
+		peer, err := NewPeer(s, "", "", "alice", fmt.Sprintf("peer%d", keepalive), "test")
+		if err != nil {
+			t.Fatalf("failed to create peer: %v", err)
+		}
+		peer.PersistentKeepalive = keepalive
+		s.Peers = append(s.Peers, peer)
+	}
+
+	// only peers with their own keepalive get it from the server too; it is
+	// always set so that a removed keepalive is disabled
+	expected := []time.Duration{0, 0, 10 * time.Second}
+	wgPeers, _ := s.GetPeers()
+	for i, wgPeer := range wgPeers {
+		if wgPeer.PersistentKeepaliveInterval == nil || *wgPeer.PersistentKeepaliveInterval != expected[i] {
+			t.Fatalf("peer %d: expected keepalive %s, got %v", i, expected[i], wgPeer.PersistentKeepaliveInterval)
+		}
+	}
+}
+
+func TestGetPeersEndpoint(t *testing.T) {
+	s := testServer(t)
+	for _, endpoint := range []string{"", "192.0.2.1:51820", "[2001:db8::1]:51820", "192.0.2.1"} {
+		peer, err := NewPeer(s, "", "", "alice", fmt.Sprintf("peer%d", len(s.Peers)), "test")
+		if err != nil {
+			t.Fatalf("failed to create peer: %v", err)
+		}
+		peer.Endpoint = endpoint
+		s.Peers = append(s.Peers, peer)
+	}
+
+	// an endpoint that fails to resolve is left alone, as with none at all,
+	// and reported
+	wgPeers, err := s.GetPeers()
+	var unresolved UnresolvedEndpointsError
+	if !errors.As(err, &unresolved) || len(unresolved) != 1 || unresolved[0].Hostname != "peer3" {
+		t.Fatalf("expected peer3 to be unresolved, got %v", err)
+	}
+
+	expected := []string{"", "192.0.2.1:51820", "[2001:db8::1]:51820", ""}
+	for i, wgPeer := range wgPeers {
+		endpoint := ""
+		if wgPeer.Endpoint != nil {
+			endpoint = wgPeer.Endpoint.String()
+		}
+		if endpoint != expected[i] {
+			t.Fatalf("peer %d: expected endpoint %q, got %q", i, expected[i], endpoint)
+		}
+	}
+}
+
+func TestAllocateIPSkipsReserved(t *testing.T) {
+	s := testServer(t)
+	s.Reserved = []IPRange{mustParseIPRange(t, "10.0.0.1-10.0.0.20")}
+
+	ip, err := s.AllocateIP()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := (net.IP{10, 0, 0, 21}); !ip.Equal(expected) {
+		t.Fatalf("expected %s, got %s", expected, ip)
+	}
+}
+
+func TestAllocatePoolIP(t *testing.T) {
+	s := testServer(t)
+	s.Reserved = []IPRange{mustParseIPRange(t, "10.0.0.1-10.0.0.20")}
+	s.Pools = map[string][]IPRange{
+		"iot":   {mustParseIPRange(t, "10.0.0.16/28"), mustParseIPRange(t, "fd00::100/120")},
+		"staff": {mustParseIPRange(t, "10.0.0.32/27")},
+	}
+
+	// reserved IPs in the pool are skipped
+	ip, err := s.AllocatePoolIP("iot")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := (net.IP{10, 0, 0, 21}); !ip.Equal(expected) {
+		t.Fatalf("expected %s, got %s", expected, ip)
+	}
+
+	// and outside the pools, pooled IPs are skipped
+	s.Peers = append(s.Peers, Peer{IP: ip})
+	for i := 22; i <= 31; i++ {
+		s.Peers = append(s.Peers, Peer{IP: net.IP{10, 0, 0, byte(i)}})
+	}
+	if ip, err = s.AllocateIP(); err != nil || !ip.Equal(net.IP{10, 0, 0, 64}) {
+		t.Fatalf("expected 10.0.0.64, got %s (%v)", ip, err)
+	}
+
+	if _, err = s.AllocatePoolIP("iot"); err == nil {
+		t.Fatal("expected the pool to be exhausted")
+	}
+
+	ip6, err := s.AllocatePoolIP6("iot")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.IPPool(ip6) != "iot" {
+		t.Fatalf("expected %s to be in the iot pool", ip6)
+	}
+
+	// without IPv6 ranges, the pool gets an IPv6 from the rest of the network
+	if ip6, err = s.AllocatePoolIP6("staff"); err != nil || s.IPPool(ip6) != "" {
+		t.Fatalf("expected an IPv6 outside the pools, got %s (%v)", ip6, err)
+	}
+
+	if _, err = s.AllocatePoolIP("customers"); err == nil {
+		t.Fatal("expected an unknown pool to be rejected")
+	}
+}
+
+func TestCheckStaticIP(t *testing.T) {
+	s := testServer(t)
+	s.Reserved = []IPRange{mustParseIPRange(t, "10.0.0.1-10.0.0.20")}
+	s.Peers = []Peer{{IP: net.IP{10, 0, 0, 2}}}
+
+	for _, ip := range []string{"10.0.0.5", "fd00::5"} {
+		if err := s.CheckStaticIP(net.ParseIP(ip)); err != nil {
+			t.Fatalf("expected %s to be allowed, got %v", ip, err)
+		}
+	}
+
+	for _, ip := range []string{"10.0.0.2", "10.0.0.0", "10.0.3.255", "10.0.4.1", "fd01::1"} {
+		if err := s.CheckStaticIP(net.ParseIP(ip)); err == nil {
+			t.Fatalf("expected %s to be rejected", ip)
+		}
+	}
+}
+
+func TestNewPeerWithIPs(t *testing.T) {
+	s := testServer(t)
+	s.Pools = map[string][]IPRange{"iot": {mustParseIPRange(t, "10.0.0.32/27")}}
+
+	peer, err := NewPeerWithIPs(s, "", "", "alice", "router", "test", PeerIPs{IP: net.ParseIP("10.0.0.10"), Pool: "iot"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !peer.IP.Equal(net.IP{10, 0, 0, 10}) || len(peer.IP) != net.IPv4len {
+		t.Fatalf("expected the given IP, got %s", peer.IP)
+	}
+	if len(peer.IP6) == 0 {
+		t.Fatal("expected an IPv6 to be allocated")
+	}
+
+	if peer, err = NewPeerWithIPs(s, "", "", "alice", "sensor", "test", PeerIPs{Pool: "iot"}); err != nil || !peer.IP.Equal(net.IP{10, 0, 0, 32}) {
+		t.Fatalf("expected 10.0.0.32 from the pool, got %s (%v)", peer.IP, err)
+	}
+
+	if _, err = NewPeerWithIPs(s, "", "", "alice", "laptop", "test", PeerIPs{IP: net.ParseIP("10.0.0.1")}); err == nil {
+		t.Fatal("expected the server IP to be rejected")
+	}
+	if _, err = NewPeerWithIPs(s, "", "", "alice", "laptop", "test", PeerIPs{IP6: net.ParseIP("10.0.0.9")}); err == nil {
+		t.Fatal("expected an IPv4 address as IP6 to be rejected")
+	}
+}
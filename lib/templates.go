@@ -7,7 +7,13 @@ Address={{ .Peer.IP }}/{{ .CidrSize }}
 {{ if gt (.Server.Network6.IPNet.IP | len) 0 -}}
 Address={{ .Peer.IP6 }}/{{ .CidrSize6 }}
 {{ end -}}
-PrivateKey={{ .Peer.PrivateKey.Key }}
+{{ if .MTU -}}
+MTU={{ .MTU }}
+{{ end -}}
+{{ if .ListenPort -}}
+ListenPort={{ .ListenPort }}
+{{ end -}}
+PrivateKey={{ .PrivateKey }}
 {{- if .Server.DNS }}
 DNS={{ .Server.DNS }}
 {{ end }}
@@ -16,7 +22,7 @@ DNS={{ .Server.DNS }}
 PublicKey={{ .Server.PrivateKey.PublicKey.Key }}
 PresharedKey={{ .Peer.PresharedKey.Key }}
 Endpoint={{ .Endpoint }}:{{ .Server.ListenPort }}
-PersistentKeepalive={{ .Server.PersistentKeepalive }}
+PersistentKeepalive={{ .Keepalive }}
 {{ if gt (.Server.Network.IPNet.IP | len) 0 -}}
 AllowedIPs={{ .Server.Network.IPNet.IP }}/{{ .CidrSize }}
 {{ end -}}
@@ -26,6 +32,16 @@ AllowedIPs={{ .Server.Network6.IPNet.IP }}/{{ .CidrSize6  }}
 {{ range .Server.Networks -}}
 AllowedIPs={{ . }}
 {{ end -}}
+{{ range .MeshPeers }}
+[Peer]
+# Name = {{ .Peer.Hostname }}
+PublicKey={{ .Peer.PublicKey.Key }}
+PresharedKey={{ .PresharedKey.Key }}
+Endpoint={{ .Peer.Endpoint }}
+{{ range .AllowedIPs -}}
+AllowedIPs={{ . }}
+{{ end -}}
+{{ end -}}
 `
 
 const vyattaPeerConf = `configure
@@ -36,14 +52,20 @@ set interfaces wireguard wg0 address {{ .Peer.IP }}/{{ .CidrSize }}
 set interfaces wireguard wg0 address {{ .Peer.IP6 }}/{{ .CidrSize6 }}
 {{ end -}}
 set interfaces wireguard wg0 route-allowed-ips true
-set interfaces wireguard wg0 private-key {{ .Peer.PrivateKey.Key }}
+set interfaces wireguard wg0 private-key {{ .PrivateKey }}
+{{ if .ListenPort -}}
+set interfaces wireguard wg0 listen-port {{ .ListenPort }}
+{{ end -}}
+{{ if .MTU -}}
+set interfaces wireguard wg0 mtu {{ .MTU }}
+{{ end -}}
 set interfaces wireguard wg0 description {{ .Server.InterfaceName }}
 {{- if .Server.DNS }}
 #set service dns forwarding name-server {{ .Server.DNS }}
 {{ end }}
 
 set interfaces wireguard wg0 peer {{ .Server.PrivateKey.PublicKey.Key }} endpoint {{ .Endpoint }}:{{ .Server.ListenPort }}
-set interfaces wireguard wg0 peer {{ .Server.PrivateKey.PublicKey.Key }} persistent-keepalive {{ .Server.PersistentKeepalive }}
+set interfaces wireguard wg0 peer {{ .Server.PrivateKey.PublicKey.Key }} persistent-keepalive {{ .Keepalive }}
 set interfaces wireguard wg0 peer {{ .Server.PrivateKey.PublicKey.Key }} preshared-key {{ .Peer.PresharedKey.Key }}
 {{ if gt (.Server.Network.IPNet.IP | len) 0 -}}
 set interfaces wireguard wg0 peer {{ .Server.PrivateKey.PublicKey.Key }} allowed-ips {{ .Server.Network.IPNet.IP }}/{{ .CidrSize }}
@@ -54,6 +76,15 @@ set interfaces wireguard wg0 peer {{ .Server.PrivateKey.PublicKey.Key }} allowed
 {{ range .Server.Networks -}}
 set interfaces wireguard wg0 peer {{ .Server.PrivateKey.PublicKey.Key }} allowed-ips {{ . }}
 {{ end -}}
+{{ range .MeshPeers -}}
+{{ $key := .Peer.PublicKey.Key -}}
+set interfaces wireguard wg0 peer {{ $key }} description {{ .Peer.Hostname }}
+set interfaces wireguard wg0 peer {{ $key }} endpoint {{ .Peer.Endpoint }}
+set interfaces wireguard wg0 peer {{ $key }} preshared-key {{ .PresharedKey.Key }}
+{{ range .AllowedIPs -}}
+set interfaces wireguard wg0 peer {{ $key }} allowed-ips {{ . }}
+{{ end -}}
+{{ end -}}
 commit; save
 `
 
@@ -67,7 +98,13 @@ const nixosPeerConf = `networking.wireguard.interfaces = {{ "{" }}
       "{{ .Peer.IP6 }}/{{ .CidrSize6 }}"
       {{ end -}}
     ];
-    privateKey = "{{ .Peer.PrivateKey.Key }}";
+    privateKey = "{{ .PrivateKey }}";
+    {{ if .ListenPort -}}
+    listenPort = {{ .ListenPort }};
+    {{ end -}}
+    {{ if .MTU -}}
+    mtu = {{ .MTU }};
+    {{ end -}}
     peers = [
       {{ "{" }}
         publicKey = "{{ .Server.PrivateKey.PublicKey.Key }}";
@@ -84,17 +121,29 @@ const nixosPeerConf = `networking.wireguard.interfaces = {{ "{" }}
 		  {{ end -}}
         ];
         endpoint = "{{ .Endpoint }}:{{ .Server.ListenPort }}";
-        persistentKeepalive = {{ .Server.PersistentKeepalive }};
+        persistentKeepalive = {{ .Keepalive }};
 		dynamicEndpointRefreshRestartSeconds = 5; # restart on failure (e.g. DNS issue)
 		dynamicEndpointRefreshSeconds = 300; # refresh DNS periodically
       {{ "}" }}
+      {{ range .MeshPeers -}}
+      {{ "{" }} # {{ .Peer.Hostname }}
+        publicKey = "{{ .Peer.PublicKey.Key }}";
+        presharedKey = "{{ .PresharedKey.Key }}";
+        allowedIPs = [
+          {{ range .AllowedIPs -}}
+          "{{ . }}"
+          {{ end -}}
+        ];
+        endpoint = "{{ .Peer.Endpoint }}";
+      {{ "}" }}
+      {{ end -}}
     ];
   {{ "};" }}
 {{ "};" }}
 `
 
 const routerosPeerConf = `/interface wireguard
-add name=wg0 private-key="{{ .Peer.PrivateKey.Key }}";
+add name=wg0 {{ if .MTU }}mtu={{ .MTU }} {{ end }}{{ if .ListenPort }}listen-port={{ .ListenPort }} {{ end }}private-key="{{ .PrivateKey }}";
 /interface list member
 add interface=wg0 list=LAN
 /ip address
@@ -113,7 +162,7 @@ add interface=wg0 \
     preshared-key="{{ .Peer.PresharedKey.Key }}" \
     endpoint-address={{ .Endpoint }} \
     endpoint-port={{ .Server.ListenPort }} \
-    persistent-keepalive={{ .Server.PersistentKeepalive }}s \
+    persistent-keepalive={{ .Keepalive }}s \
     allowed-address=
         {{- if gt (.Server.Network.IPNet.IP | len) 0 }}
             {{- if $first}}{{$first = false}}{{else}},{{end}}
@@ -127,4 +176,182 @@ add interface=wg0 \
             {{- if $first}}{{$first = false}}{{else}},{{end}}
             {{- . }}
         {{- end }}
+{{ range .MeshPeers -}}
+{{ $first := true -}}
+add interface=wg0 comment="{{ .Peer.Hostname }}" \
+    public-key="{{ .Peer.PublicKey.Key }}" \
+    preshared-key="{{ .PresharedKey.Key }}" \
+    endpoint-address={{ .EndpointHost }} \
+    endpoint-port={{ .EndpointPort }} \
+    allowed-address=
+        {{- range .AllowedIPs }}
+            {{- if $first}}{{$first = false}}{{else}},{{end}}
+            {{- . }}
+        {{- end }}
+{{ end -}}
+`
+
+const k8sSecretPeerConf = `apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .ResourceName }}
+  labels:
+    app.kubernetes.io/managed-by: dsnet
+  annotations:
+    dsnet/hostname: {{ printf "%q" .Peer.Hostname }}
+    dsnet/owner: {{ printf "%q" .Peer.Owner }}
+    dsnet/description: {{ printf "%q" .Peer.Description }}
+type: Opaque
+stringData:
+  wg0.conf: |
+{{ indent 4 .WGQuickConf }}
+`
+
+const composePeerConf = `# requires docker compose >= 2.23 for inline config content
+services:
+  {{ .ResourceName }}:
+    image: lscr.io/linuxserver/wireguard:latest
+    cap_add:
+      - NET_ADMIN
+    sysctls:
+      - net.ipv4.conf.all.src_valid_mark=1
+    configs:
+      - source: {{ .ResourceName }}
+        target: /config/wg_confs/wg0.conf
+    restart: unless-stopped
+
+configs:
+  {{ .ResourceName }}:
+    content: |
+{{ indent 6 .WGQuickConf }}
+`
+
+const wgQuickServerConf = `[Interface]
+{{ if gt (.Server.Network.IPNet.IP | len) 0 -}}
+Address={{ .Server.IP }}/{{ .CidrSize }}
+{{ end -}}
+{{ if gt (.Server.Network6.IPNet.IP | len) 0 -}}
+Address={{ .Server.IP6 }}/{{ .CidrSize6 }}
+{{ end -}}
+ListenPort={{ .Server.ListenPort }}
+PrivateKey={{ .Server.PrivateKey.Key }}
+{{ if .Server.MTU -}}
+MTU={{ .Server.MTU }}
+{{ end -}}
+{{ if .Server.PostUp -}}
+PostUp={{ .Server.PostUp }}
+{{ end -}}
+{{ if .Server.PostDown -}}
+PostDown={{ .Server.PostDown }}
+{{ end -}}
+{{ range .Peers }}
+[Peer]
+# Name = {{ .Peer.Hostname }}
+# {{ .Peer.Owner }}: {{ .Peer.Description }}
+PublicKey={{ .Peer.PublicKey.Key }}
+PresharedKey={{ .Peer.PresharedKey.Key }}
+{{ if .Peer.Endpoint -}}
+Endpoint={{ .Peer.Endpoint }}
+{{ end -}}
+{{ if .Keepalive -}}
+PersistentKeepalive={{ .Keepalive }}
+{{ end -}}
+{{ range .AllowedIPs -}}
+AllowedIPs={{ . }}
+{{ end -}}
+{{ end -}}
+`
+
+const nixosServerConf = `networking.wireguard.interfaces = {{ "{" }}
+  {{ .Server.InterfaceName }} = {{ "{" }}
+    ips = [
+      {{ if gt (.Server.Network.IPNet.IP | len) 0 -}}
+      "{{ .Server.IP }}/{{ .CidrSize }}"
+      {{ end -}}
+      {{ if gt (.Server.Network6.IPNet.IP | len) 0 -}}
+      "{{ .Server.IP6 }}/{{ .CidrSize6 }}"
+      {{ end -}}
+    ];
+    listenPort = {{ .Server.ListenPort }};
+    privateKey = "{{ .Server.PrivateKey.Key }}";
+    {{ if .Server.MTU -}}
+    mtu = {{ .Server.MTU }};
+    {{ end -}}
+    {{ if .Server.PostUp -}}
+    postSetup = ''{{ .Server.PostUp }}'';
+    {{ end -}}
+    {{ if .Server.PostDown -}}
+    postShutdown = ''{{ .Server.PostDown }}'';
+    {{ end -}}
+    peers = [
+      {{ range .Peers -}}
+      {{ "{" }} # {{ .Peer.Hostname }}
+        publicKey = "{{ .Peer.PublicKey.Key }}";
+        presharedKey = "{{ .Peer.PresharedKey.Key }}";
+        {{ if .Peer.Endpoint -}}
+        endpoint = "{{ .Peer.Endpoint }}";
+        {{ end -}}
+        {{ if .Keepalive -}}
+        persistentKeepalive = {{ .Keepalive }};
+        {{ end -}}
+        allowedIPs = [
+          {{ range .AllowedIPs -}}
+          "{{ . }}"
+          {{ end -}}
+        ];
+      {{ "}" }}
+      {{ end -}}
+    ];
+  {{ "};" }}
+{{ "};" }}
+`
+
+const networkdServerConf = `# /etc/systemd/network/50-{{ .Server.InterfaceName }}.netdev
+# contains the private key: chown root:systemd-network, chmod 0640
+[NetDev]
+Name={{ .Server.InterfaceName }}
+Kind=wireguard
+{{ if .Server.MTU -}}
+MTUBytes={{ .Server.MTU }}
+{{ end -}}
+
+[WireGuard]
+PrivateKey={{ .Server.PrivateKey.Key }}
+ListenPort={{ .Server.ListenPort }}
+{{ range .Peers }}
+[WireGuardPeer]
+# {{ .Peer.Hostname }}
+PublicKey={{ .Peer.PublicKey.Key }}
+PresharedKey={{ .Peer.PresharedKey.Key }}
+{{ if .Peer.Endpoint -}}
+Endpoint={{ .Peer.Endpoint }}
+{{ end -}}
+{{ if .Keepalive -}}
+PersistentKeepalive={{ .Keepalive }}
+{{ end -}}
+{{ range .AllowedIPs -}}
+AllowedIPs={{ . }}
+{{ end -}}
+{{ end }}
+# /etc/systemd/network/50-{{ .Server.InterfaceName }}.network
+[Match]
+Name={{ .Server.InterfaceName }}
+
+[Network]
+{{ if gt (.Server.Network.IPNet.IP | len) 0 -}}
+Address={{ .Server.IP }}/{{ .CidrSize }}
+{{ end -}}
+{{ if gt (.Server.Network6.IPNet.IP | len) 0 -}}
+Address={{ .Server.IP6 }}/{{ .CidrSize6 }}
+{{ end -}}
+{{ if or .Server.PostUp .Server.PostDown -}}
+# PostUp/PostDown are not supported by systemd-networkd and must be
+# migrated separately:
+{{ if .Server.PostUp -}}
+# PostUp: {{ .Server.PostUp }}
+{{ end -}}
+{{ if .Server.PostDown -}}
+# PostDown: {{ .Server.PostDown }}
+{{ end -}}
+{{ end -}}
 `
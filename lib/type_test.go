@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net"
 	"testing"
+	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -214,3 +215,33 @@ func TestJSONKeyInStruct(t *testing.T) {
 		t.Fatal("key round trip through struct failed")
 	}
 }
+
+func TestJSONDurationRoundTrip(t *testing.T) {
+	original := JSONDuration{Duration: 90 * 24 * time.Hour}
+
+	b, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if string(b) != `"2160h0m0s"` {
+		t.Fatalf("unexpected JSON %s", b)
+	}
+
+	var decoded JSONDuration
+	if err = json.Unmarshal([]byte(`"2160h"`), &decoded); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if decoded != original {
+		t.Fatalf("expected %s, got %s", original, decoded)
+	}
+}
+
+func TestJSONDurationUnmarshalInvalid(t *testing.T) {
+	var d JSONDuration
+	if err := json.Unmarshal([]byte(`"90 days"`), &d); err == nil {
+		t.Fatal("expected error for invalid duration")
+	}
+	if err := json.Unmarshal([]byte(`""`), &d); err != nil || d.Duration != 0 {
+		t.Fatalf("empty string should be zero, got %s, %v", d, err)
+	}
+}
@@ -1,9 +1,11 @@
 package lib
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -60,11 +62,52 @@ func (k JSONKey) PublicKey() JSONKey {
 
 func (k *JSONKey) UnmarshalJSON(b []byte) error {
 	b64Key := strings.Trim(string(b), "\"")
+
+	// secrets may be encrypted at rest, see SecretBox
+	if IsSealed(b64Key) {
+		raw, err := openKey(b64Key)
+		if err != nil {
+			return err
+		}
+		key, err := wgtypes.NewKey(raw)
+		k.Key = key
+		return err
+	}
+
 	key, err := wgtypes.ParseKey(b64Key)
 	k.Key = key
 	return err
 }
 
+// JSONDuration is a time.Duration written as a string such as "720h"
+type JSONDuration struct {
+	Duration time.Duration
+}
+
+func (d JSONDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+func (d *JSONDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	if s == "" {
+		d.Duration = 0
+		return nil
+	}
+
+	duration, err := time.ParseDuration(s)
+	d.Duration = duration
+	return err
+}
+
+func (d JSONDuration) String() string {
+	return d.Duration.String()
+}
+
 func GenerateJSONPrivateKey() (JSONKey, error) {
 	privateKey, err := wgtypes.GeneratePrivateKey()
 
@@ -0,0 +1,278 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// WGQuickConfig is an existing WireGuard setup, parsed from a wg-quick config
+// file or read from a live interface, to be adopted by dsnet
+type WGQuickConfig struct {
+	Interface WGQuickInterface
+	Peers     []WGQuickPeer
+}
+
+// WGQuickInterface is the [Interface] section
+type WGQuickInterface struct {
+	PrivateKey wgtypes.Key
+	ListenPort int
+	// IP is the address of the interface, Mask the network it is in
+	Addresses []net.IPNet
+	MTU       int
+	PostUp    []string
+	PostDown  []string
+}
+
+// WGQuickPeer is a [Peer] section
+type WGQuickPeer struct {
+	// from a `# Name = ...` comment in or directly above the section, if any
+	Name                string
+	PublicKey           wgtypes.Key
+	PresharedKey        wgtypes.Key
+	AllowedIPs          []net.IPNet
+	Endpoint            string
+	PersistentKeepalive int
+}
+
+// matches the comments commonly used to annotate peers, e.g. `# Name = laptop`
+var wgQuickNameComment = regexp.MustCompile(`(?i)^#\s*(?:name|hostname|client)\s*[=:]\s*(.+?)\s*$`)
+
+// ParseWGQuickConfig parses a wg-quick(8) config file. Keys are case
+// insensitive and repeated keys are appended, as wg-quick does.
+func ParseWGQuickConfig(r io.Reader) (*WGQuickConfig, error) {
+	conf := &WGQuickConfig{}
+	scanner := bufio.NewScanner(r)
+	section := ""
+	pendingName := ""
+	keysInSection := 0
+	lineNum := 0
+
+	var peer *WGQuickPeer
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		if matches := wgQuickNameComment.FindStringSubmatch(line); matches != nil {
+			// a name comment directly under [Peer] names that peer, anywhere
+			// else it names the next one
+			if section == "peer" && keysInSection == 0 {
+				peer.Name = matches[1]
+			} else {
+				pendingName = matches[1]
+			}
+			continue
+		}
+
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			switch section {
+			case "interface":
+			case "peer":
+				conf.Peers = append(conf.Peers, WGQuickPeer{Name: pendingName})
+				peer = &conf.Peers[len(conf.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown section %s", lineNum, line)
+			}
+			pendingName = ""
+			keysInSection = 0
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		keysInSection++
+
+		var err error
+		switch section {
+		case "interface":
+			err = conf.Interface.set(key, value)
+		case "peer":
+			err = peer.set(key, value)
+		default:
+			err = fmt.Errorf("%s outside of a section", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if conf.Interface.PrivateKey == (wgtypes.Key{}) {
+		return nil, fmt.Errorf("no [Interface] PrivateKey found")
+	}
+
+	return conf, nil
+}
+
+func (i *WGQuickInterface) set(key, value string) error {
+	var err error
+
+	switch key {
+	case "privatekey":
+		i.PrivateKey, err = wgtypes.ParseKey(value)
+	case "listenport":
+		i.ListenPort, err = strconv.Atoi(value)
+	case "address":
+		var addrs []net.IPNet
+		addrs, err = parseIPNetList(value)
+		i.Addresses = append(i.Addresses, addrs...)
+	case "mtu":
+		i.MTU, err = strconv.Atoi(value)
+	case "postup":
+		i.PostUp = append(i.PostUp, value)
+	case "postdown":
+		i.PostDown = append(i.PostDown, value)
+	case "dns", "table", "preup", "predown", "saveconfig", "fwmark":
+		// only meaningful to wg-quick on this host
+	default:
+		return fmt.Errorf("unknown [Interface] key %s", key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", key, err)
+	}
+	return nil
+}
+
+func (p *WGQuickPeer) set(key, value string) error {
+	var err error
+
+	switch key {
+	case "publickey":
+		p.PublicKey, err = wgtypes.ParseKey(value)
+	case "presharedkey":
+		p.PresharedKey, err = wgtypes.ParseKey(value)
+	case "allowedips":
+		var nets []net.IPNet
+		nets, err = parseIPNetList(value)
+		p.AllowedIPs = append(p.AllowedIPs, nets...)
+	case "endpoint":
+		p.Endpoint = value
+	case "persistentkeepalive":
+		if value != "off" {
+			p.PersistentKeepalive, err = strconv.Atoi(value)
+		}
+	default:
+		return fmt.Errorf("unknown [Peer] key %s", key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", key, err)
+	}
+	return nil
+}
+
+// parseIPNetList parses a comma separated list of addresses or CIDRs. The
+// host part is retained in IP; a bare address is given a host mask.
+func parseIPNetList(value string) ([]net.IPNet, error) {
+	nets := make([]net.IPNet, 0)
+
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			IP := net.ParseIP(field)
+			if IP == nil {
+				return nil, fmt.Errorf("invalid address %s", field)
+			}
+			if IP.To4() != nil {
+				nets = append(nets, net.IPNet{IP: IP.To4(), Mask: net.CIDRMask(32, 32)})
+			} else {
+				nets = append(nets, net.IPNet{IP: IP, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+
+		IP, IPNet, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		if IP.To4() != nil {
+			IP = IP.To4()
+		}
+		nets = append(nets, net.IPNet{IP: IP, Mask: IPNet.Mask})
+	}
+
+	return nets, nil
+}
+
+// ReadWGInterface reads the configuration of a live WireGuard interface
+func ReadWGInterface(name string) (*WGQuickConfig, error) {
+	wg, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer wg.Close()
+
+	dev, err := wg.Device(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve device '%s' (%v)", name, err)
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface(%s): %v", name, err)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses for interface: %v", err)
+	}
+
+	conf := &WGQuickConfig{
+		Interface: WGQuickInterface{
+			PrivateKey: dev.PrivateKey,
+			ListenPort: dev.ListenPort,
+			MTU:        link.Attrs().MTU,
+		},
+	}
+
+	for _, addr := range addrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		conf.Interface.Addresses = append(conf.Interface.Addresses, *addr.IPNet)
+	}
+
+	for _, wgPeer := range dev.Peers {
+		peer := WGQuickPeer{
+			PublicKey:           wgPeer.PublicKey,
+			PresharedKey:        wgPeer.PresharedKey,
+			AllowedIPs:          wgPeer.AllowedIPs,
+			PersistentKeepalive: int(wgPeer.PersistentKeepaliveInterval.Seconds()),
+		}
+		if wgPeer.Endpoint != nil {
+			peer.Endpoint = wgPeer.Endpoint.String()
+		}
+		conf.Peers = append(conf.Peers, peer)
+	}
+
+	return conf, nil
+}
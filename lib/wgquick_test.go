@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func testWGQuickConfig(t *testing.T) (string, wgtypes.Key, wgtypes.Key) {
+	t.Helper()
+	serverKey, _ := wgtypes.GeneratePrivateKey()
+	peerKey, _ := wgtypes.GeneratePrivateKey()
+	psk, _ := wgtypes.GenerateKey()
+
+	conf := `[Interface]
+# server
+Address = 10.0.0.1/24, fd00::1/64
+ListenPort = 51821
+PrivateKey = ` + serverKey.String() + `
+MTU = 1380
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostDown = iptables -D FORWARD -i %i -j ACCEPT
+SaveConfig = false
+
+# Name = Alice's laptop
+[Peer]
+PublicKey = ` + peerKey.PublicKey().String() + `
+PresharedKey = ` + psk.String() + `
+AllowedIPs = 10.0.0.2/32, fd00::2/128
+AllowedIPs = 192.168.10.0/24 # office LAN
+
+[peer]
+# Name: router
+publickey = ` + psk.String() + `
+allowedips = 10.0.0.3
+Endpoint = 198.51.100.7:51820
+PersistentKeepalive = 15
+`
+	return conf, serverKey, peerKey
+}
+
+func TestParseWGQuickConfig(t *testing.T) {
+	raw, serverKey, peerKey := testWGQuickConfig(t)
+
+	conf, err := ParseWGQuickConfig(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if conf.Interface.PrivateKey != serverKey {
+		t.Fatal("private key mismatch")
+	}
+	if conf.Interface.ListenPort != 51821 || conf.Interface.MTU != 1380 {
+		t.Fatalf("unexpected port/MTU: %d/%d", conf.Interface.ListenPort, conf.Interface.MTU)
+	}
+	if len(conf.Interface.Addresses) != 2 || conf.Interface.Addresses[0].String() != "10.0.0.1/24" {
+		t.Fatalf("unexpected addresses: %v", conf.Interface.Addresses)
+	}
+	if len(conf.Interface.PostUp) != 1 || len(conf.Interface.PostDown) != 1 {
+		t.Fatal("expected PostUp and PostDown")
+	}
+
+	if len(conf.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(conf.Peers))
+	}
+	if conf.Peers[0].Name != "Alice's laptop" || conf.Peers[1].Name != "router" {
+		t.Fatalf("unexpected peer names %q, %q", conf.Peers[0].Name, conf.Peers[1].Name)
+	}
+	if conf.Peers[0].PublicKey != peerKey.PublicKey() {
+		t.Fatal("peer public key mismatch")
+	}
+	if len(conf.Peers[0].AllowedIPs) != 3 {
+		t.Fatalf("repeated AllowedIPs should be appended, got %v", conf.Peers[0].AllowedIPs)
+	}
+	if conf.Peers[1].AllowedIPs[0].String() != "10.0.0.3/32" {
+		t.Fatalf("bare address should get a host mask, got %s", conf.Peers[1].AllowedIPs[0].String())
+	}
+	if conf.Peers[1].Endpoint != "198.51.100.7:51820" || conf.Peers[1].PersistentKeepalive != 15 {
+		t.Fatal("unexpected endpoint/keepalive")
+	}
+}
+
+func TestParseWGQuickConfigTrailingNameComment(t *testing.T) {
+	serverKey, _ := wgtypes.GeneratePrivateKey()
+	raw := "[Interface]\nPrivateKey = " + serverKey.String() + "\n" +
+		"[Peer]\nPublicKey = " + serverKey.PublicKey().String() + "\n" +
+		"# Name = second\n" +
+		"[Peer]\nPublicKey = " + serverKey.PublicKey().String() + "\n"
+
+	conf, err := ParseWGQuickConfig(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.Peers[0].Name != "" || conf.Peers[1].Name != "second" {
+		t.Fatalf("name comment should apply to the following peer, got %q, %q", conf.Peers[0].Name, conf.Peers[1].Name)
+	}
+}
+
+func TestParseWGQuickConfigErrors(t *testing.T) {
+	serverKey, _ := wgtypes.GeneratePrivateKey()
+	tests := map[string]string{
+		"no private key":  "[Interface]\nListenPort = 51820\n",
+		"unknown section": "[Interface]\nPrivateKey = " + serverKey.String() + "\n[Nope]\n",
+		"unknown key":     "[Interface]\nPrivateKey = " + serverKey.String() + "\nColour = red\n",
+		"invalid key":     "[Interface]\nPrivateKey = nope\n",
+		"no section":      "PrivateKey = " + serverKey.String() + "\n",
+		"invalid address": "[Interface]\nPrivateKey = " + serverKey.String() + "\nAddress = 10.0.0.300\n",
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseWGQuickConfig(strings.NewReader(raw)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
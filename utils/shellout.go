@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
+	"strings"
 )
 
 func ShellOut(command string, name string) error {
@@ -15,3 +17,16 @@ func ShellOut(command string, name string) error {
 	}
 	return nil
 }
+
+// ShellOutput runs command with /bin/sh and returns its stdout
+func ShellOutput(command string, name string) (string, error) {
+	output, err := exec.Command("/bin/sh", "-c", command).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("failed to execute(%s - `%s`): %s: %s", name, command, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("failed to execute(%s - `%s`): %s", name, command, err)
+	}
+	return string(output), nil
+}
@@ -47,3 +47,23 @@ func TestShellOutEcho(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestShellOutput(t *testing.T) {
+	output, err := ShellOutput("echo hello", "test echo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "hello\n" {
+		t.Fatalf("expected output hello, got %q", output)
+	}
+}
+
+func TestShellOutputFailureIncludesStderr(t *testing.T) {
+	_, err := ShellOutput("echo oops >&2; false", "my-task")
+	if err == nil {
+		t.Fatal("expected error for failing command")
+	}
+	if !strings.Contains(err.Error(), "my-task") || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("error should mention task name and stderr, got: %s", err)
+	}
+}